@@ -0,0 +1,72 @@
+package dyngeo
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/crolly/dyngeo/internal/geo"
+)
+
+// attributes adapts *dynamodb.AttributeValue to geo.Attributes.
+type attributes struct{}
+
+var _ geo.Attributes[*dynamodb.AttributeValue] = attributes{}
+
+func (attributes) String(v *dynamodb.AttributeValue) (string, bool) {
+	if v == nil || v.S == nil {
+		return "", false
+	}
+
+	return *v.S, true
+}
+
+func (attributes) Number(v *dynamodb.AttributeValue) (string, bool) {
+	if v == nil || v.N == nil {
+		return "", false
+	}
+
+	return *v.N, true
+}
+
+func (attributes) Binary(v *dynamodb.AttributeValue) ([]byte, bool) {
+	if v == nil || v.B == nil {
+		return nil, false
+	}
+
+	return v.B, true
+}
+
+func (attributes) Map(v *dynamodb.AttributeValue) (map[string]*dynamodb.AttributeValue, bool) {
+	if v == nil || v.M == nil {
+		return nil, false
+	}
+
+	return v.M, true
+}
+
+func (attributes) List(v *dynamodb.AttributeValue) ([]*dynamodb.AttributeValue, bool) {
+	if v == nil || v.L == nil {
+		return nil, false
+	}
+
+	return v.L, true
+}
+
+func (attributes) NewString(s string) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{S: aws.String(s)}
+}
+
+func (attributes) NewNumber(n string) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{N: aws.String(n)}
+}
+
+func (attributes) NewBinary(b []byte) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{B: b}
+}
+
+func (attributes) NewMap(m map[string]*dynamodb.AttributeValue) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{M: m}
+}
+
+func (attributes) NewList(l []*dynamodb.AttributeValue) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{L: l}
+}
@@ -0,0 +1,395 @@
+package dyngeo
+
+import (
+	"reflect"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/crolly/dyngeo/internal/geo"
+)
+
+// Collection stores values of the struct type T as points. The fields tagged
+// with `dyngeo:"lat"`, `dyngeo:"lng"` and `dyngeo:"id"` hold the location and
+// the range key of a point, all other fields are marshalled with
+// dynamodbattribute. On results, the geo fields are filled from the stored
+// point, and a float field tagged with `dyngeo:"distance"` with the distance
+// in meters from the query center.
+//
+//	type Store struct {
+//		ID       string  `dyngeo:"id" dynamodbav:"-"`
+//		Lat      float64 `dyngeo:"lat"`
+//		Lng      float64 `dyngeo:"lng"`
+//		Distance float64 `dyngeo:"distance" dynamodbav:"distance"`
+//		Name     string  `dynamodbav:"name"`
+//	}
+type Collection[T any] struct {
+	dg     *DynGeo
+	fields geo.PointFields
+}
+
+// NewCollection returns the Collection of T stored by dg. T has to be a struct
+// with fields tagged lat, lng and id.
+func NewCollection[T any](dg *DynGeo) (*Collection[T], error) {
+	fields, err := geo.NewPointFields(reflect.TypeOf((*T)(nil)).Elem())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Collection[T]{dg: dg, fields: fields}, nil
+}
+
+// pointInput returns the range key and location of the item.
+func (c *Collection[T]) pointInput(item T) PointInput {
+	v := reflect.ValueOf(item)
+	lat, lng := c.fields.LatLng(v)
+
+	return PointInput{
+		RangeKeyValue: c.fields.RangeKey(v),
+		GeoPoint:      GeoPoint{Latitude: lat, Longitude: lng},
+	}
+}
+
+// marshal returns the attributes of the item, without its distance.
+func (c *Collection[T]) marshal(item T) (map[string]*dynamodb.AttributeValue, error) {
+	av, err := dynamodbattribute.MarshalMap(item)
+	if err != nil {
+		return nil, err
+	}
+	if c.fields.DistanceAttributeName != "" {
+		delete(av, c.fields.DistanceAttributeName)
+	}
+
+	return av, nil
+}
+
+// unmarshal unmarshals the item and fills the geo fields from its location
+// and range key and, if distanceAttributeName is set, its distance.
+func (c *Collection[T]) unmarshal(item map[string]*dynamodb.AttributeValue, distanceAttributeName string) (T, error) {
+	var out T
+	if err := dynamodbattribute.UnmarshalMap(item, &out); err != nil {
+		return out, err
+	}
+	v := reflect.ValueOf(&out)
+
+	codec := c.dg.Config.codec()
+	lat, lng, err := codec.Degrees(item)
+	if err != nil {
+		return out, err
+	}
+	c.fields.SetLatLng(v, lat, lng)
+
+	if rangeKey, ok := codec.RangeKey(item); ok {
+		if err := c.fields.SetRangeKey(v, rangeKey); err != nil {
+			return out, err
+		}
+	}
+
+	if distanceAttributeName != "" {
+		d, ok, err := codec.Float(item, distanceAttributeName)
+		if err != nil {
+			return out, err
+		}
+		if ok {
+			c.fields.SetDistance(v, d)
+		}
+	}
+
+	return out, nil
+}
+
+// results receives the items of a query in place of dynamodbattribute, see
+// DynGeo.unmarshallOutput.
+type results[T any] struct {
+	collection            *Collection[T]
+	distanceAttributeName string
+	items                 []T
+}
+
+func (r *results[T]) receive(items []map[string]*dynamodb.AttributeValue) error {
+	r.items = make([]T, len(items))
+	for i, item := range items {
+		out, err := r.collection.unmarshal(item, r.distanceAttributeName)
+		if err != nil {
+			return err
+		}
+		r.items[i] = out
+	}
+
+	return nil
+}
+
+// CollectionIterator is a QueryIterator returning its items as T, with the
+// geo fields filled like for the other queries of a Collection.
+type CollectionIterator[T any] struct {
+	it      *QueryIterator
+	results *results[T]
+	item    T
+	err     error
+}
+
+// Next advances the iterator to the next item. It returns false once all
+// items have been read, an item could not be unmarshalled, an error occurred
+// or the iterator has been closed.
+func (it *CollectionIterator[T]) Next() bool {
+	if it.err != nil || !it.it.Next() {
+		return false
+	}
+
+	item, err := it.results.collection.unmarshal(it.it.Item(), it.results.distanceAttributeName)
+	if err != nil {
+		it.err = err
+		it.it.Close()
+		return false
+	}
+	it.item = item
+
+	return true
+}
+
+// Item returns the current item.
+func (it *CollectionIterator[T]) Item() T {
+	return it.item
+}
+
+// Err returns the error that stopped the iteration, if any, see
+// QueryIterator.Err.
+func (it *CollectionIterator[T]) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+
+	return it.it.Err()
+}
+
+// Close stops the iteration, see QueryIterator.Close.
+func (it *CollectionIterator[T]) Close() {
+	it.it.Close()
+}
+
+// results returns the receiver of the query results. If T has a distance
+// field, the distance is injected into the results.
+func (c *Collection[T]) results(input *GeoQueryInput) *results[T] {
+	if c.fields.DistanceAttributeName != "" && input.DistanceAttributeName == "" {
+		input.DistanceAttributeName = c.fields.DistanceAttributeName
+	}
+
+	return &results[T]{collection: c, distanceAttributeName: input.DistanceAttributeName}
+}
+
+// Put writes the item as a point.
+func (c *Collection[T]) Put(item T) error {
+	return c.PutWithContext(aws.BackgroundContext(), item)
+}
+
+// PutWithContext is like Put, but takes a context for cancellation.
+func (c *Collection[T]) PutWithContext(ctx aws.Context, item T) error {
+	av, err := c.marshal(item)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.dg.PutPointWithContext(ctx, PutPointInput{
+		PointInput:   c.pointInput(item),
+		PutItemInput: dynamodb.PutItemInput{Item: av},
+	})
+
+	return err
+}
+
+// Get reads the point with the location and id of key. It returns
+// ErrPointNotFound if there is none.
+func (c *Collection[T]) Get(key T) (T, error) {
+	return c.GetWithContext(aws.BackgroundContext(), key)
+}
+
+// GetWithContext is like Get, but takes a context for cancellation.
+func (c *Collection[T]) GetWithContext(ctx aws.Context, key T) (T, error) {
+	var out T
+	output, err := c.dg.GetPointWithContext(ctx, GetPointInput{PointInput: c.pointInput(key)})
+	if err != nil {
+		return out, err
+	}
+	if len(output.Item) == 0 {
+		return out, ErrPointNotFound
+	}
+
+	return c.unmarshal(output.Item, "")
+}
+
+// Update replaces the attributes of the existing point with the location and
+// id of the item. It returns ErrPointNotFound if there is none. Use MovePoint
+// to change the location.
+func (c *Collection[T]) Update(item T) error {
+	return c.UpdateWithContext(aws.BackgroundContext(), item)
+}
+
+// UpdateWithContext is like Update, but takes a context for cancellation.
+func (c *Collection[T]) UpdateWithContext(ctx aws.Context, item T) error {
+	av, err := c.marshal(item)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.dg.PutPointWithContext(ctx, PutPointInput{
+		PointInput: c.pointInput(item),
+		PutItemInput: dynamodb.PutItemInput{
+			Item:                av,
+			ConditionExpression: aws.String("attribute_exists(#rangeKey)"),
+			ExpressionAttributeNames: map[string]*string{
+				"#rangeKey": aws.String(c.dg.Config.RangeKeyAttributeName),
+			},
+		},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return ErrPointNotFound
+	}
+
+	return err
+}
+
+// Delete deletes the point with the location and id of key.
+func (c *Collection[T]) Delete(key T) error {
+	return c.DeleteWithContext(aws.BackgroundContext(), key)
+}
+
+// DeleteWithContext is like Delete, but takes a context for cancellation.
+func (c *Collection[T]) DeleteWithContext(ctx aws.Context, key T) error {
+	_, err := c.dg.DeletePointWithContext(ctx, DeletePointInput{PointInput: c.pointInput(key)})
+
+	return err
+}
+
+// QueryRadius is like DynGeo.QueryRadius, but returns the results as T.
+func (c *Collection[T]) QueryRadius(input QueryRadiusInput) ([]T, error) {
+	return c.QueryRadiusWithContext(aws.BackgroundContext(), input)
+}
+
+// QueryRadiusWithContext is like QueryRadius, but takes a context for cancellation.
+func (c *Collection[T]) QueryRadiusWithContext(ctx aws.Context, input QueryRadiusInput) ([]T, error) {
+	out := c.results(&input.GeoQueryInput)
+	err := c.dg.QueryRadiusWithContext(ctx, input, out)
+
+	return out.items, err
+}
+
+// QueryRadiusPage is like DynGeo.QueryRadiusPage, but returns the results as
+// T.
+func (c *Collection[T]) QueryRadiusPage(input QueryRadiusInput) ([]T, *QueryRadiusOutput, error) {
+	return c.QueryRadiusPageWithContext(aws.BackgroundContext(), input)
+}
+
+// QueryRadiusPageWithContext is like QueryRadiusPage, but takes a context for cancellation.
+func (c *Collection[T]) QueryRadiusPageWithContext(ctx aws.Context, input QueryRadiusInput) ([]T, *QueryRadiusOutput, error) {
+	out := c.results(&input.GeoQueryInput)
+	output, err := c.dg.QueryRadiusPageWithContext(ctx, input, out)
+
+	return out.items, output, err
+}
+
+// QueryRadiusIter is like DynGeo.QueryRadiusIter, but streams the results as
+// T.
+func (c *Collection[T]) QueryRadiusIter(input QueryRadiusInput) *CollectionIterator[T] {
+	return c.QueryRadiusIterWithContext(aws.BackgroundContext(), input)
+}
+
+// QueryRadiusIterWithContext is like QueryRadiusIter, but takes a context for cancellation.
+func (c *Collection[T]) QueryRadiusIterWithContext(ctx aws.Context, input QueryRadiusInput) *CollectionIterator[T] {
+	out := c.results(&input.GeoQueryInput)
+
+	return &CollectionIterator[T]{it: c.dg.QueryRadiusIterWithContext(ctx, input), results: out}
+}
+
+// QueryRectangle is like DynGeo.QueryRectangle, but returns the results as T.
+func (c *Collection[T]) QueryRectangle(input QueryRectangleInput) ([]T, error) {
+	return c.QueryRectangleWithContext(aws.BackgroundContext(), input)
+}
+
+// QueryRectangleWithContext is like QueryRectangle, but takes a context for cancellation.
+func (c *Collection[T]) QueryRectangleWithContext(ctx aws.Context, input QueryRectangleInput) ([]T, error) {
+	out := c.results(&input.GeoQueryInput)
+	err := c.dg.QueryRectangleWithContext(ctx, input, out)
+
+	return out.items, err
+}
+
+// QueryRectanglePage is like DynGeo.QueryRectanglePage, but returns the
+// results as T.
+func (c *Collection[T]) QueryRectanglePage(input QueryRectangleInput) ([]T, *QueryRectangleOutput, error) {
+	return c.QueryRectanglePageWithContext(aws.BackgroundContext(), input)
+}
+
+// QueryRectanglePageWithContext is like QueryRectanglePage, but takes a context for cancellation.
+func (c *Collection[T]) QueryRectanglePageWithContext(ctx aws.Context, input QueryRectangleInput) ([]T, *QueryRectangleOutput, error) {
+	out := c.results(&input.GeoQueryInput)
+	output, err := c.dg.QueryRectanglePageWithContext(ctx, input, out)
+
+	return out.items, output, err
+}
+
+// QueryRectangleIter is like DynGeo.QueryRectangleIter, but streams the
+// results as T.
+func (c *Collection[T]) QueryRectangleIter(input QueryRectangleInput) *CollectionIterator[T] {
+	return c.QueryRectangleIterWithContext(aws.BackgroundContext(), input)
+}
+
+// QueryRectangleIterWithContext is like QueryRectangleIter, but takes a context for cancellation.
+func (c *Collection[T]) QueryRectangleIterWithContext(ctx aws.Context, input QueryRectangleInput) *CollectionIterator[T] {
+	out := c.results(&input.GeoQueryInput)
+
+	return &CollectionIterator[T]{it: c.dg.QueryRectangleIterWithContext(ctx, input), results: out}
+}
+
+// QuerySector is like DynGeo.QuerySector, but returns the results as T.
+func (c *Collection[T]) QuerySector(input QuerySectorInput) ([]T, error) {
+	return c.QuerySectorWithContext(aws.BackgroundContext(), input)
+}
+
+// QuerySectorWithContext is like QuerySector, but takes a context for cancellation.
+func (c *Collection[T]) QuerySectorWithContext(ctx aws.Context, input QuerySectorInput) ([]T, error) {
+	out := c.results(&input.GeoQueryInput)
+	err := c.dg.QuerySectorWithContext(ctx, input, out)
+
+	return out.items, err
+}
+
+// QueryPolygon is like DynGeo.QueryPolygon, but returns the results as T.
+func (c *Collection[T]) QueryPolygon(input QueryPolygonInput) ([]T, error) {
+	return c.QueryPolygonWithContext(aws.BackgroundContext(), input)
+}
+
+// QueryPolygonWithContext is like QueryPolygon, but takes a context for cancellation.
+func (c *Collection[T]) QueryPolygonWithContext(ctx aws.Context, input QueryPolygonInput) ([]T, error) {
+	out := c.results(&input.GeoQueryInput)
+	err := c.dg.QueryPolygonWithContext(ctx, input, out)
+
+	return out.items, err
+}
+
+// QueryCorridor is like DynGeo.QueryCorridor, but returns the results as T.
+// The distance field holds the distance from the start of the route.
+func (c *Collection[T]) QueryCorridor(input QueryCorridorInput) ([]T, *QueryCorridorOutput, error) {
+	return c.QueryCorridorWithContext(aws.BackgroundContext(), input)
+}
+
+// QueryCorridorWithContext is like QueryCorridor, but takes a context for cancellation.
+func (c *Collection[T]) QueryCorridorWithContext(ctx aws.Context, input QueryCorridorInput) ([]T, *QueryCorridorOutput, error) {
+	out := c.results(&input.GeoQueryInput)
+	output, err := c.dg.QueryCorridorWithContext(ctx, input, out)
+
+	return out.items, output, err
+}
+
+// QueryNearest is like DynGeo.QueryNearest, but returns the results as T.
+func (c *Collection[T]) QueryNearest(input QueryNearestInput) ([]T, error) {
+	return c.QueryNearestWithContext(aws.BackgroundContext(), input)
+}
+
+// QueryNearestWithContext is like QueryNearest, but takes a context for cancellation.
+func (c *Collection[T]) QueryNearestWithContext(ctx aws.Context, input QueryNearestInput) ([]T, error) {
+	out := c.results(&input.GeoQueryInput)
+	err := c.dg.QueryNearestWithContext(ctx, input, out)
+
+	return out.items, err
+}
@@ -1,28 +1,170 @@
 package dyngeo
 
 import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/crolly/dyngeo/internal/geo"
 	"github.com/golang/geo/s2"
 )
 
 // MERGE_THRESHOLD ...
-const MERGE_THRESHOLD = 2
+const MERGE_THRESHOLD = geo.MERGE_THRESHOLD
 
+// MAX_NEAREST_QUERIES bounds the DynamoDB queries of a QueryNearest.
+const MAX_NEAREST_QUERIES = geo.MAX_NEAREST_QUERIES
+
+// DynamoDBAPI lists the DynamoDB operations DynG(e)o uses. It is implemented
+// by *dynamodb.DynamoDB and every dynamodbiface.DynamoDBAPI, e.g. DAX clients,
+// clients wrapped for tracing or mocks.
+type DynamoDBAPI interface {
+	QueryWithContext(aws.Context, *dynamodb.QueryInput, ...request.Option) (*dynamodb.QueryOutput, error)
+	GetItemWithContext(aws.Context, *dynamodb.GetItemInput, ...request.Option) (*dynamodb.GetItemOutput, error)
+	PutItemWithContext(aws.Context, *dynamodb.PutItemInput, ...request.Option) (*dynamodb.PutItemOutput, error)
+	UpdateItemWithContext(aws.Context, *dynamodb.UpdateItemInput, ...request.Option) (*dynamodb.UpdateItemOutput, error)
+	DeleteItemWithContext(aws.Context, *dynamodb.DeleteItemInput, ...request.Option) (*dynamodb.DeleteItemOutput, error)
+	BatchWriteItemWithContext(aws.Context, *dynamodb.BatchWriteItemInput, ...request.Option) (*dynamodb.BatchWriteItemOutput, error)
+	BatchGetItemWithContext(aws.Context, *dynamodb.BatchGetItemInput, ...request.Option) (*dynamodb.BatchGetItemOutput, error)
+	TransactWriteItemsWithContext(aws.Context, *dynamodb.TransactWriteItemsInput, ...request.Option) (*dynamodb.TransactWriteItemsOutput, error)
+}
+
+var _ DynamoDBAPI = dynamodbiface.DynamoDBAPI(nil)
+
+// HashKeyScheme defines how the hash key of an item is derived from its geohash.
+type HashKeyScheme = geo.HashKeyScheme
+
+const (
+	// DecimalPrefixHashKey uses the first HashKeyLength decimal digits of the
+	// geohash as hash key.
+	DecimalPrefixHashKey = geo.DecimalPrefixHashKey
+	// ParentCellHashKey uses the ID of the geohash's ancestor cell at
+	// HashKeyCellLevel as hash key, so that every hash key covers exactly one
+	// S2 cell.
+	ParentCellHashKey = geo.ParentCellHashKey
+)
+
+// RangeKeyType is the attribute type of the range key in the table.
+type RangeKeyType = geo.RangeKeyType
+
+const (
+	// StringRangeKey stores range keys as strings, e.g. UUIDs or composite
+	// "tenant#id" keys.
+	StringRangeKey = geo.StringRangeKey
+	// NumberRangeKey stores range keys as numbers, e.g. timestamps.
+	NumberRangeKey = geo.NumberRangeKey
+)
+
+// StorageFormat selects the attributes the location of a point is stored in.
+type StorageFormat = geo.StorageFormat
+
+const (
+	// GeoJSONStringStorage stores a GeoJSON point encoded as JSON string in
+	// GeoJSONAttributeName, the format of earlier versions.
+	GeoJSONStringStorage = geo.GeoJSONStringStorage
+	// GeoJSONMapStorage stores a GeoJSON point as Map attribute in
+	// GeoJSONAttributeName.
+	GeoJSONMapStorage = geo.GeoJSONMapStorage
+	// LatLngStorage stores latitude and longitude as Number attributes in
+	// LatitudeAttributeName and LongitudeAttributeName.
+	LatLngStorage = geo.LatLngStorage
+)
+
+// DynGeoConfig ...
+//
+// MinCellLevel, MaxCellLevel and MaxCells configure the S2 region coverer
+// that turns query regions into cells, which defaults to exactly level 10.
+// If only MaxCellLevel is set, MinCellLevel is 0. If only MinCellLevel is
+// set, MaxCellLevel is 10 or MinCellLevel, whichever is higher.
+// With AdaptiveCovering the cell level is instead picked per query from the
+// size of the region and the hash key partitions, aiming for about MaxCells
+// cells. Regions spanning more than MAX_ADAPTIVE_CELLS hash keys then fail
+// with ErrRegionTooLarge.
+//
+// RangeKeyType is the attribute type of the range key in the table created
+// by GetCreateTableRequest. It has to match the type of the RangeKeyValue of
+// every point, other points are rejected with ErrInvalidRangeKey.
+//
+// StorageFormat selects how the location of a point is stored. It defaults to
+// GeoJSONStringStorage, use GeoJSONMapStorage|LatLngStorage to store both a
+// GeoJSON Map and Number attributes. Items are read in every format, so
+// tables written in another format can still be queried.
+//
+// NormalizeLongitude wraps longitudes outside ±180 into that range, e.g. 190
+// becomes -170. Otherwise they are rejected with ErrInvalidCoordinate, like
+// latitudes outside ±90.
+//
+// HashKeyScheme selects how hash keys are derived from geohashes. Tables
+// written with one scheme cannot be queried with the other.
+// HashKeyCellLevel is the level of the cells ParentCellHashKey uses as hash
+// keys. It has no default, as 0 is a valid level whose six cells are the cube
+// faces, so New fails if it isn't set with that scheme, e.g. to aws.Int(10).
+//
+// MaxConcurrency limits the number of DynamoDB queries in flight across all
+// geo queries of the DynGeo instance. 0 means no limit.
+// It also limits the number of concurrent requests of a batch write, which
+// defaults to 4.
 type DynGeoConfig struct {
-	TableName             string
-	ConsistentRead        bool
-	HashKeyAttributeName  string
-	RangeKeyAttributeName string
-	GeoHashAttributeName  string
-	GeoJSONAttributeName  string
-	GeoHashIndexName      string
-	HashKeyLength         int8
-	LongitudeFirst        bool
-
-	DynamoDBClient  *dynamodb.DynamoDB
+	TableName              string
+	ConsistentRead         bool
+	HashKeyAttributeName   string
+	RangeKeyAttributeName  string
+	RangeKeyType           RangeKeyType
+	GeoHashAttributeName   string
+	GeoJSONAttributeName   string
+	LatitudeAttributeName  string
+	LongitudeAttributeName string
+	StorageFormat          StorageFormat
+	GeoHashIndexName       string
+	HashKeyLength          int8
+	HashKeyScheme          HashKeyScheme
+	HashKeyCellLevel       *int
+	LongitudeFirst         bool
+	NormalizeLongitude     bool
+	MinCellLevel           int
+	MaxCellLevel           int
+	MaxCells               int
+	AdaptiveCovering       bool
+	MaxConcurrency         int
+
+	DynamoDBClient  DynamoDBAPI
 	s2RegionCoverer s2.RegionCoverer
 }
 
+// codec returns the geo.Codec reading and writing the geo attributes of
+// items as configured.
+func (config DynGeoConfig) codec() geo.Codec[*dynamodb.AttributeValue] {
+	return geo.Codec[*dynamodb.AttributeValue]{
+		Attributes: attributes{},
+		Schema: geo.Schema{
+			HashKeyAttributeName:   config.HashKeyAttributeName,
+			RangeKeyAttributeName:  config.RangeKeyAttributeName,
+			GeoHashAttributeName:   config.GeoHashAttributeName,
+			GeoJSONAttributeName:   config.GeoJSONAttributeName,
+			LatitudeAttributeName:  config.LatitudeAttributeName,
+			LongitudeAttributeName: config.LongitudeAttributeName,
+			StorageFormat:          config.StorageFormat,
+			LongitudeFirst:         config.LongitudeFirst,
+			NormalizeLongitude:     config.NormalizeLongitude,
+			Hasher: geo.Hasher{
+				Scheme:    config.HashKeyScheme,
+				Length:    config.HashKeyLength,
+				CellLevel: aws.IntValue(config.HashKeyCellLevel),
+			},
+		},
+	}
+}
+
+// engine returns the geo.Engine running the geo queries as configured.
+func (config DynGeoConfig) engine() geo.Engine[*dynamodb.AttributeValue] {
+	return geo.Engine[*dynamodb.AttributeValue]{
+		Codec:            config.codec(),
+		Coverer:          config.s2RegionCoverer,
+		AdaptiveCovering: config.AdaptiveCovering,
+		MaxConcurrency:   config.MaxConcurrency,
+	}
+}
+
 // func NewConfig(dynamoClient *dynamodb.DynamoDB, tableName string) DynGeoConfig {
 // 	return DynGeoConfig{
 // 		tableName:             tableName,
@@ -0,0 +1,67 @@
+package dyngeo
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/crolly/dyngeo/internal/geo"
+)
+
+// CountRadius counts the points within the radius around the center point
+// without returning them.
+func (dg DynGeo) CountRadius(input QueryRadiusInput) (*CountOutput, error) {
+	return dg.CountRadiusWithContext(aws.BackgroundContext(), input)
+}
+
+// CountRadiusWithContext is like CountRadius, but takes a context for cancellation.
+func (dg DynGeo) CountRadiusWithContext(ctx aws.Context, input QueryRadiusInput) (*CountOutput, error) {
+	q, err := input.query(dg.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	return dg.count(ctx, q, input.GeoQueryInput)
+}
+
+// CountRectangle counts the points within the rectangle without returning
+// them.
+func (dg DynGeo) CountRectangle(input QueryRectangleInput) (*CountOutput, error) {
+	return dg.CountRectangleWithContext(aws.BackgroundContext(), input)
+}
+
+// CountRectangleWithContext is like CountRectangle, but takes a context for cancellation.
+func (dg DynGeo) CountRectangleWithContext(ctx aws.Context, input QueryRectangleInput) (*CountOutput, error) {
+	q, err := input.query(dg.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	return dg.count(ctx, q, input.GeoQueryInput)
+}
+
+// CountPolygon counts the points within the polygon without returning them.
+func (dg DynGeo) CountPolygon(input QueryPolygonInput) (*CountOutput, error) {
+	return dg.CountPolygonWithContext(aws.BackgroundContext(), input)
+}
+
+// CountPolygonWithContext is like CountPolygon, but takes a context for cancellation.
+func (dg DynGeo) CountPolygonWithContext(ctx aws.Context, input QueryPolygonInput) (*CountOutput, error) {
+	q, err := input.query()
+	if err != nil {
+		return nil, err
+	}
+
+	return dg.count(ctx, q, input.GeoQueryInput)
+}
+
+// count counts the points within the shape, see geo.Engine.Count.
+func (dg DynGeo) count(ctx aws.Context, q geo.Shape, input GeoQueryInput) (*CountOutput, error) {
+	req := dg.db.request(input)
+	result, queryErr := dg.Config.engine().Count(ctx, q, req)
+	if req.Failed(queryErr) {
+		return nil, queryErr
+	}
+
+	return &CountOutput{
+		Count:            result.Count,
+		ConsumedCapacity: dg.consumedCapacity(result.CapacityUnits),
+	}, queryErr
+}
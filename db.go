@@ -1,30 +1,102 @@
 package dyngeo
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/imdario/mergo"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/crolly/dyngeo/internal/geo"
 )
 
 type db struct {
 	config DynGeoConfig
+	codec  geo.Codec[*dynamodb.AttributeValue]
+	pool   *geo.WorkerPool
 }
 
 func newDB(config DynGeoConfig) db {
 	return db{
 		config: config,
+		codec:  config.codec(),
+		pool:   geo.NewWorkerPool(config.MaxConcurrency),
 	}
 }
 
-func (db db) queryGeoHash(queryInput dynamodb.QueryInput, hashKey uint64, ghr geoHashRange) []*dynamodb.QueryOutput {
-	queryOutputs := []*dynamodb.QueryOutput{}
+// query issues a single Query request once the worker pool has a free slot.
+func (db db) query(ctx aws.Context, queryInput *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	if err := db.pool.Acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer db.pool.Release()
+
+	return db.config.DynamoDBClient.QueryWithContext(ctx, queryInput)
+}
+
+// request returns the geo.Request reading the geohash ranges with the
+// QueryInput of the query.
+func (db db) request(input GeoQueryInput) geo.Request[*dynamodb.AttributeValue] {
+	return geo.Request[*dynamodb.AttributeValue]{
+		Options: input.options(),
+		Query: func(ctx context.Context, hashKey uint64, ghr geo.Range, startKey map[string]*dynamodb.AttributeValue, limit int) (geo.Page[*dynamodb.AttributeValue], error) {
+			queryInput, err := db.geoHashQueryInput(input.QueryInput, hashKey, ghr)
+			if err != nil {
+				return geo.Page[*dynamodb.AttributeValue]{}, err
+			}
+			queryInput.ExclusiveStartKey = startKey
+			if limit > 0 {
+				queryInput.Limit = aws.Int64(int64(limit))
+			}
+
+			return db.queryPage(ctx, &queryInput)
+		},
+		Count: func(ctx context.Context, hashKey uint64, ghr geo.Range, startKey map[string]*dynamodb.AttributeValue, limit int) (geo.Page[*dynamodb.AttributeValue], error) {
+			queryInput, err := db.geoHashQueryInput(input.QueryInput, hashKey, ghr)
+			if err != nil {
+				return geo.Page[*dynamodb.AttributeValue]{}, err
+			}
+			queryInput.ExclusiveStartKey = startKey
+			queryInput.Select = aws.String("COUNT")
+			queryInput.AttributesToGet = nil
+			queryInput.ProjectionExpression = nil
+
+			return db.queryPage(ctx, &queryInput)
+		},
+	}
+}
+
+// queryPage issues the Query request and returns its page.
+func (db db) queryPage(ctx aws.Context, queryInput *dynamodb.QueryInput) (geo.Page[*dynamodb.AttributeValue], error) {
+	output, err := db.query(ctx, queryInput)
+	if err != nil {
+		return geo.Page[*dynamodb.AttributeValue]{}, err
+	}
+
+	page := geo.Page[*dynamodb.AttributeValue]{
+		Items:            output.Items,
+		LastEvaluatedKey: output.LastEvaluatedKey,
+		Count:            aws.Int64Value(output.Count),
+		ScannedCount:     aws.Int64Value(output.ScannedCount),
+	}
+	if output.ConsumedCapacity != nil {
+		page.CapacityUnits = aws.Float64Value(output.ConsumedCapacity.CapacityUnits)
+	}
 
+	return page, nil
+}
+
+// geoHashQueryInput restricts the query to the geohash range, filling in the
+// options left unset in queryInput.
+func (db db) geoHashQueryInput(queryInput dynamodb.QueryInput, hashKey uint64, ghr geo.Range) (dynamodb.QueryInput, error) {
 	keyConditions := map[string]*dynamodb.Condition{
 		db.config.HashKeyAttributeName: &dynamodb.Condition{
 			ComparisonOperator: aws.String("EQ"),
@@ -36,8 +108,8 @@ func (db db) queryGeoHash(queryInput dynamodb.QueryInput, hashKey uint64, ghr ge
 		db.config.GeoHashAttributeName: &dynamodb.Condition{
 			ComparisonOperator: aws.String("BETWEEN"),
 			AttributeValueList: []*dynamodb.AttributeValue{
-				&dynamodb.AttributeValue{N: aws.String(strconv.FormatUint(ghr.rangeMin, 10))},
-				&dynamodb.AttributeValue{N: aws.String(strconv.FormatUint(ghr.rangeMax, 10))},
+				&dynamodb.AttributeValue{N: aws.String(strconv.FormatUint(ghr.Min, 10))},
+				&dynamodb.AttributeValue{N: aws.String(strconv.FormatUint(ghr.Max, 10))},
 			},
 		},
 	}
@@ -50,128 +122,600 @@ func (db db) queryGeoHash(queryInput dynamodb.QueryInput, hashKey uint64, ghr ge
 	}
 
 	if err := mergo.Merge(&queryInput, defaultInput); err != nil {
-		fmt.Println(err)
+		return dynamodb.QueryInput{}, err
+	}
+
+	return queryInput, nil
+}
+
+func (db db) getPoint(ctx aws.Context, input GetPointInput) (*GetPointOutput, error) {
+	pointInput, err := geo.ValidPointInput(input.PointInput, db.config.RangeKeyType, db.config.NormalizeLongitude)
+	if err != nil {
+		return nil, err
 	}
 
-	output, queryOutputs := db.paginateQuery(queryInput, queryOutputs)
+	getItemInput := input.GetItemInput
+	getItemInput.TableName = aws.String(db.config.TableName)
+	getItemInput.Key = db.codec.Key(pointInput)
+
+	out, err := db.config.DynamoDBClient.GetItemWithContext(ctx, &getItemInput)
+
+	return &GetPointOutput{out}, err
+}
 
-	for output.LastEvaluatedKey != nil {
-		queryInput.ExclusiveStartKey = output.LastEvaluatedKey
-		output, queryOutputs = db.paginateQuery(queryInput, queryOutputs)
+// batchConcurrency returns the number of batch requests sent at the same
+// time.
+func (db db) batchConcurrency() int {
+	if db.config.MaxConcurrency > 0 {
+		return db.config.MaxConcurrency
 	}
 
-	return queryOutputs
+	return geo.BATCH_CONCURRENCY
 }
 
-func (db db) paginateQuery(queryInput dynamodb.QueryInput, queryOutputs []*dynamodb.QueryOutput) (*dynamodb.QueryOutput, []*dynamodb.QueryOutput) {
-	output, err := db.config.DynamoDBClient.Query(&queryInput)
+func (db db) putPoint(ctx aws.Context, input PutPointInput) (*PutPointOutput, error) {
+	pointInput, err := geo.ValidPointInput(input.PointInput, db.config.RangeKeyType, db.config.NormalizeLongitude)
 	if err != nil {
-		fmt.Println(err)
+		return nil, err
+	}
+
+	putItemInput := input.PutItemInput
+	putItemInput.TableName = aws.String(db.config.TableName)
+
+	item, err := db.codec.PointItem(input.PutItemInput.Item, pointInput)
+	if err != nil {
+		return nil, err
 	}
-	queryOutputs = append(queryOutputs, output)
+	putItemInput.Item = item
+
+	out, err := db.config.DynamoDBClient.PutItemWithContext(ctx, &putItemInput)
 
-	return output, queryOutputs
+	return &PutPointOutput{out}, err
 }
 
-func (db db) getPoint(input GetPointInput) (*GetPointOutput, error) {
-	_, hashKey := generateHashes(input.GeoPoint, db.config.HashKeyLength)
+// batchWrite sends the write requests in chunks of MAX_BATCH_WRITE_ITEMS,
+// which are sent concurrently. Unprocessed items are retried with exponential
+// backoff. It returns the indices of the requests that could not be written.
+// Requests for the same point fail with ErrDuplicateKey before any is sent.
+func (db db) batchWrite(ctx aws.Context, writeInputs []*dynamodb.WriteRequest) ([]geo.BatchFailure[int], error) {
+	items := make([]map[string]*dynamodb.AttributeValue, len(writeInputs))
+	for i, w := range writeInputs {
+		items[i] = writeRequestItem(w)
+	}
+	index, err := db.codec.NewBatchIndex(items)
+	if err != nil {
+		return nil, err
+	}
+
+	send := func(pending []int) ([]int, error) {
+		writeRequests := make([]*dynamodb.WriteRequest, len(pending))
+		for i, p := range pending {
+			writeRequests[i] = writeInputs[p]
+		}
 
-	getItemInput := input.GetItemInput
-	getItemInput.TableName = aws.String(db.config.TableName)
-	getItemInput.Key = map[string]*dynamodb.AttributeValue{
-		db.config.HashKeyAttributeName:  &dynamodb.AttributeValue{N: aws.String(strconv.FormatUint(hashKey, 10))},
-		db.config.RangeKeyAttributeName: &dynamodb.AttributeValue{S: aws.String(input.RangeKeyValue.String())},
+		out, err := db.config.DynamoDBClient.BatchWriteItemWithContext(ctx, &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]*dynamodb.WriteRequest{
+				db.config.TableName: writeRequests,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		unprocessed := []map[string]*dynamodb.AttributeValue{}
+		for _, w := range out.UnprocessedItems[db.config.TableName] {
+			unprocessed = append(unprocessed, writeRequestItem(w))
+		}
+
+		return db.codec.Positions(index, unprocessed), nil
 	}
 
-	out, err := db.config.DynamoDBClient.GetItem(&getItemInput)
+	return geo.RunBatches(ctx, len(writeInputs), MAX_BATCH_WRITE_ITEMS, db.batchConcurrency(), send)
+}
 
-	return &GetPointOutput{out}, err
+// writeRequestItem returns the item of a put or the key of a delete request.
+func writeRequestItem(w *dynamodb.WriteRequest) map[string]*dynamodb.AttributeValue {
+	if w.PutRequest != nil {
+		return w.PutRequest.Item
+	}
+
+	return w.DeleteRequest.Key
 }
 
-func (db db) putPoint(input PutPointInput) (*PutPointOutput, error) {
-	geoHash, hashKey := generateHashes(input.GeoPoint, db.config.HashKeyLength)
-	putItemInput := input.PutItemInput
-	putItemInput.TableName = aws.String(db.config.TableName)
-	putItemInput.Item = input.PutItemInput.Item
+// batchWritePoints writes the points with batchWrite. The inputs that could
+// not be written are reported in the output and by a *BatchWriteError.
+func (db db) batchWritePoints(ctx aws.Context, inputs []PutPointInput) (*BatchWritePointOutput, error) {
+	writeInputs := make([]*dynamodb.WriteRequest, len(inputs))
+	for i, input := range inputs {
+		pointInput, err := geo.ValidPointInput(input.PointInput, db.config.RangeKeyType, db.config.NormalizeLongitude)
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
 
-	putItemInput.Item[db.config.HashKeyAttributeName] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatUint(hashKey, 10))}
-	putItemInput.Item[db.config.RangeKeyAttributeName] = &dynamodb.AttributeValue{S: aws.String(input.RangeKeyValue.String())}
-	putItemInput.Item[db.config.GeoHashAttributeName] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatUint(geoHash, 10))}
+		item, err := db.codec.PointItem(input.PutItemInput.Item, pointInput)
+		if err != nil {
+			return nil, err
+		}
 
-	jsonAttr, err := json.Marshal(newGeoJSONAttribute(input.GeoPoint, db.config.LongitudeFirst))
+		writeInputs[i] = &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: item}}
+	}
+
+	failures, err := db.batchWrite(ctx, writeInputs)
 	if err != nil {
 		return nil, err
 	}
-	putItemInput.Item[db.config.GeoJSONAttributeName] = &dynamodb.AttributeValue{S: aws.String(string(jsonAttr))}
 
-	out, err := db.config.DynamoDBClient.PutItem(&putItemInput)
+	output := &BatchWritePointOutput{BatchWriteItemOutput: &dynamodb.BatchWriteItemOutput{}}
+	if len(failures) == 0 {
+		return output, nil
+	}
 
-	return &PutPointOutput{out}, err
+	output.Failures = geo.InputFailures(inputs, failures)
+	unprocessed := []*dynamodb.WriteRequest{}
+	for _, f := range failures {
+		unprocessed = append(unprocessed, writeInputs[f.Item])
+	}
+	output.UnprocessedItems = map[string][]*dynamodb.WriteRequest{
+		db.config.TableName: unprocessed,
+	}
+
+	return output, &BatchWriteError{Failures: output.Failures}
 }
 
-func (db db) batchWritePoints(inputs []PutPointInput) (*BatchWritePointOutput, error) {
-	writeInputs := []*dynamodb.WriteRequest{}
-	for _, input := range inputs {
-		geoHash, hashKey := generateHashes(input.GeoPoint, db.config.HashKeyLength)
-		putItemInput := input.PutItemInput
+// batchDeletePoints deletes the points with batchWrite. The inputs that could
+// not be deleted are reported in the output and by a *BatchPointError.
+func (db db) batchDeletePoints(ctx aws.Context, inputs []PointInput) (*BatchDeletePointOutput, error) {
+	writeInputs := make([]*dynamodb.WriteRequest, len(inputs))
+	for i, input := range inputs {
+		pointInput, err := geo.ValidPointInput(input, db.config.RangeKeyType, db.config.NormalizeLongitude)
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
+
+		writeInputs[i] = &dynamodb.WriteRequest{DeleteRequest: &dynamodb.DeleteRequest{Key: db.codec.Key(pointInput)}}
+	}
+
+	failures, err := db.batchWrite(ctx, writeInputs)
+	if err != nil {
+		return nil, err
+	}
+
+	output := &BatchDeletePointOutput{BatchWriteItemOutput: &dynamodb.BatchWriteItemOutput{}}
+	if len(failures) == 0 {
+		return output, nil
+	}
 
-		putRequest := dynamodb.PutRequest{
-			Item: putItemInput.Item,
+	output.Failures = geo.InputFailures(inputs, failures)
+	unprocessed := []*dynamodb.WriteRequest{}
+	for _, f := range failures {
+		unprocessed = append(unprocessed, writeInputs[f.Item])
+	}
+	output.UnprocessedItems = map[string][]*dynamodb.WriteRequest{
+		db.config.TableName: unprocessed,
+	}
+
+	return output, &BatchPointError{Failures: output.Failures}
+}
+
+// batchGetPoints reads the points in chunks of MAX_BATCH_GET_ITEMS keys, which
+// are sent concurrently. Unprocessed keys are retried with exponential
+// backoff. It returns the items found, in no particular order, and reports
+// the inputs that could not be read in the output and by a *BatchPointError.
+// Keys of the same point fail with ErrDuplicateKey before any is sent.
+func (db db) batchGetPoints(ctx aws.Context, inputs []PointInput) ([]map[string]*dynamodb.AttributeValue, *BatchGetPointOutput, error) {
+	keys := make([]map[string]*dynamodb.AttributeValue, len(inputs))
+	for i, input := range inputs {
+		pointInput, err := geo.ValidPointInput(input, db.config.RangeKeyType, db.config.NormalizeLongitude)
+		if err != nil {
+			return nil, nil, fmt.Errorf("input %d: %w", i, err)
+		}
+
+		keys[i] = db.codec.Key(pointInput)
+	}
+	index, err := db.codec.NewBatchIndex(keys)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	items := []map[string]*dynamodb.AttributeValue{}
+	mtx := &sync.Mutex{}
+
+	send := func(pending []int) ([]int, error) {
+		requestKeys := make([]map[string]*dynamodb.AttributeValue, len(pending))
+		for i, p := range pending {
+			requestKeys[i] = keys[p]
 		}
-		putRequest.Item[db.config.HashKeyAttributeName] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatUint(hashKey, 10))}
-		putRequest.Item[db.config.RangeKeyAttributeName] = &dynamodb.AttributeValue{S: aws.String(input.RangeKeyValue.String())}
-		putRequest.Item[db.config.GeoHashAttributeName] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatUint(geoHash, 10))}
 
-		jsonAttr, err := json.Marshal(newGeoJSONAttribute(input.GeoPoint, db.config.LongitudeFirst))
+		out, err := db.config.DynamoDBClient.BatchGetItemWithContext(ctx, &dynamodb.BatchGetItemInput{
+			RequestItems: map[string]*dynamodb.KeysAndAttributes{
+				db.config.TableName: &dynamodb.KeysAndAttributes{
+					Keys:           requestKeys,
+					ConsistentRead: aws.Bool(db.config.ConsistentRead),
+				},
+			},
+		})
 		if err != nil {
 			return nil, err
 		}
-		putRequest.Item[db.config.GeoJSONAttributeName] = &dynamodb.AttributeValue{S: aws.String(string(jsonAttr))}
 
-		writeInputs = append(writeInputs, &dynamodb.WriteRequest{PutRequest: &putRequest})
+		mtx.Lock()
+		items = append(items, out.Responses[db.config.TableName]...)
+		mtx.Unlock()
+
+		if keysAndAttributes, ok := out.UnprocessedKeys[db.config.TableName]; ok {
+			return db.codec.Positions(index, keysAndAttributes.Keys), nil
+		}
+
+		return nil, nil
 	}
 
-	out, err := db.config.DynamoDBClient.BatchWriteItem(&dynamodb.BatchWriteItemInput{
-		RequestItems: map[string][]*dynamodb.WriteRequest{
-			db.config.TableName: writeInputs,
-		},
-	})
+	failures, err := geo.RunBatches(ctx, len(inputs), MAX_BATCH_GET_ITEMS, db.batchConcurrency(), send)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	output := &BatchGetPointOutput{}
+	if len(failures) == 0 {
+		return items, output, nil
+	}
+
+	output.Failures = geo.InputFailures(inputs, failures)
 
-	return &BatchWritePointOutput{out}, err
+	return items, output, &BatchPointError{Failures: output.Failures}
 }
 
-func (db db) updatePoint(input UpdatePointInput) (*UpdatePointOutput, error) {
-	_, hashKey := generateHashes(input.GeoPoint, db.config.HashKeyLength)
+func (db db) updatePoint(ctx aws.Context, input UpdatePointInput) (*UpdatePointOutput, error) {
+	pointInput, err := geo.ValidPointInput(input.PointInput, db.config.RangeKeyType, db.config.NormalizeLongitude)
+	if err != nil {
+		return nil, err
+	}
 
 	input.UpdateItemInput.TableName = aws.String(db.config.TableName)
 	if input.UpdateItemInput.Key == nil {
-		input.UpdateItemInput.Key = map[string]*dynamodb.AttributeValue{
-			db.config.HashKeyAttributeName:  &dynamodb.AttributeValue{N: aws.String(strconv.FormatUint(hashKey, 10))},
-			db.config.RangeKeyAttributeName: &dynamodb.AttributeValue{S: aws.String(input.RangeKeyValue.String())},
-		}
+		input.UpdateItemInput.Key = db.codec.Key(pointInput)
 	}
 
-	// geoHash and geoJSON cannot be updated
+	// geoHash and the location attributes cannot be updated
 	if input.UpdateItemInput.AttributeUpdates != nil {
 		delete(input.UpdateItemInput.AttributeUpdates, db.config.GeoHashAttributeName)
-		delete(input.UpdateItemInput.AttributeUpdates, db.config.GeoJSONAttributeName)
+		for _, name := range db.codec.LocationAttributeNames() {
+			delete(input.UpdateItemInput.AttributeUpdates, name)
+		}
 	}
 
-	out, err := db.config.DynamoDBClient.UpdateItem(&input.UpdateItemInput)
+	out, err := db.config.DynamoDBClient.UpdateItemWithContext(ctx, &input.UpdateItemInput)
 
 	return &UpdatePointOutput{out}, err
 }
 
-func (db db) deletePoint(input DeletePointInput) (*DeletePointOutput, error) {
-	_, hashKey := generateHashes(input.GeoPoint, db.config.HashKeyLength)
+// movePoint moves the point to its new location. The item is read first, to
+// find the location attributes of other storage formats it has been written
+// with, which are removed. Within the same hash key only the geohash and
+// location attributes are updated. Otherwise the item is deleted and written
+// with its new key, geohash and location in a single transaction, keeping all
+// other attributes. The write is conditioned on the geohash read, so it fails
+// with ErrPointModified if the point has been moved or deleted in the
+// meantime. The transaction is also canceled if an item already exists at the
+// new key.
+func (db db) movePoint(ctx aws.Context, input MovePointInput) (*MovePointOutput, error) {
+	pointInput, err := geo.ValidPointInput(input.PointInput, db.config.RangeKeyType, db.config.NormalizeLongitude)
+	if err != nil {
+		return nil, err
+	}
+	input.PointInput = pointInput
+	if input.NewGeoPoint, err = geo.ValidGeoPoint("NewGeoPoint", input.NewGeoPoint, db.config.NormalizeLongitude); err != nil {
+		return nil, err
+	}
+
+	newInput := PointInput{RangeKeyValue: input.RangeKeyValue, GeoPoint: input.NewGeoPoint}
+	_, oldHashKey := db.codec.Hasher.Hashes(input.GeoPoint.LatLng())
+	_, newHashKey := db.codec.Hasher.Hashes(input.NewGeoPoint.LatLng())
+
+	oldItem, err := db.config.DynamoDBClient.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(db.config.TableName),
+		Key:            db.codec.Key(input.PointInput),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(oldItem.Item) == 0 {
+		return nil, ErrPointNotFound
+	}
+
+	// the condition has to be built before the item is changed in place
+	unmoved, readNames, values := db.codec.MoveCondition(oldItem.Item)
+	conditionNames := map[string]*string{}
+	for k, name := range readNames {
+		conditionNames[k] = aws.String(name)
+	}
+
+	if oldHashKey == newHashKey {
+		item, err := db.codec.PointItem(nil, newInput)
+		if err != nil {
+			return nil, err
+		}
+
+		update, locationNames, locationValues := db.codec.LocationUpdate(item, db.codec.StaleLocationAttributeNames(oldItem.Item))
+		for k, name := range locationNames {
+			conditionNames[k] = aws.String(name)
+		}
+		for k, v := range locationValues {
+			values[k] = v
+		}
+
+		out, err := db.config.DynamoDBClient.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+			TableName:                 aws.String(db.config.TableName),
+			Key:                       db.codec.Key(input.PointInput),
+			UpdateExpression:          aws.String(update),
+			ConditionExpression:       aws.String(unmoved),
+			ExpressionAttributeNames:  conditionNames,
+			ExpressionAttributeValues: values,
+		})
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return nil, ErrPointModified
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return &MovePointOutput{UpdateItemOutput: out}, nil
+	}
+
+	item, err := db.codec.MovedPointItem(oldItem.Item, newInput)
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]*string{
+		"#hashKey":  aws.String(db.config.HashKeyAttributeName),
+		"#rangeKey": aws.String(db.config.RangeKeyAttributeName),
+	}
+	out, err := db.config.DynamoDBClient.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			&dynamodb.TransactWriteItem{
+				Delete: &dynamodb.Delete{
+					TableName:                 aws.String(db.config.TableName),
+					Key:                       db.codec.Key(input.PointInput),
+					ConditionExpression:       aws.String(unmoved),
+					ExpressionAttributeNames:  conditionNames,
+					ExpressionAttributeValues: values,
+				},
+			},
+			&dynamodb.TransactWriteItem{
+				Put: &dynamodb.Put{
+					TableName:                aws.String(db.config.TableName),
+					Item:                     item,
+					ConditionExpression:      aws.String("attribute_not_exists(#hashKey) AND attribute_not_exists(#rangeKey)"),
+					ExpressionAttributeNames: names,
+				},
+			},
+		},
+	})
+	if reasons, ok := cancellationReasons(err); ok && len(reasons) > 0 && reasons[0].Code == "ConditionalCheckFailed" {
+		return nil, ErrPointModified
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &MovePointOutput{TransactWriteItemsOutput: out}, nil
+}
+
+// transactItem returns the transaction item of the operation, with the key
+// and geo attributes computed like for putPoint, updatePoint and deletePoint.
+func (db db) transactItem(input TransactWritePointInput) (*dynamodb.TransactWriteItem, error) {
+	ops := 0
+	for _, set := range []bool{input.Put != nil, input.Update != nil, input.Delete != nil, input.ConditionCheck != nil} {
+		if set {
+			ops++
+		}
+	}
+	if ops != 1 {
+		return nil, errors.New("exactly one of Put, Update, Delete and ConditionCheck is required")
+	}
+
+	var pointInput PointInput
+	switch {
+	case input.Put != nil:
+		pointInput = input.Put.PointInput
+	case input.Update != nil:
+		pointInput = input.Update.PointInput
+	case input.Delete != nil:
+		pointInput = input.Delete.PointInput
+	default:
+		pointInput = input.ConditionCheck.PointInput
+	}
+	pointInput, err := geo.ValidPointInput(pointInput, db.config.RangeKeyType, db.config.NormalizeLongitude)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case input.Put != nil:
+		if input.Put.PutItemInput.Expected != nil || input.Put.PutItemInput.ConditionalOperator != nil {
+			return nil, errors.New("transactions don't support Expected and ConditionalOperator, use ConditionExpression")
+		}
+		item, err := db.codec.PointItem(input.Put.PutItemInput.Item, pointInput)
+		if err != nil {
+			return nil, err
+		}
+
+		return &dynamodb.TransactWriteItem{Put: &dynamodb.Put{
+			TableName:                 aws.String(db.config.TableName),
+			Item:                      item,
+			ConditionExpression:       input.Put.PutItemInput.ConditionExpression,
+			ExpressionAttributeNames:  input.Put.PutItemInput.ExpressionAttributeNames,
+			ExpressionAttributeValues: input.Put.PutItemInput.ExpressionAttributeValues,
+		}}, nil
+	case input.Update != nil:
+		updateInput := input.Update.UpdateItemInput
+		if updateInput.Expected != nil || updateInput.ConditionalOperator != nil {
+			return nil, errors.New("transactions don't support Expected and ConditionalOperator, use ConditionExpression")
+		}
+		key := updateInput.Key
+		if key == nil {
+			key = db.codec.Key(pointInput)
+		}
+
+		update := &dynamodb.Update{
+			TableName:                 aws.String(db.config.TableName),
+			Key:                       key,
+			UpdateExpression:          updateInput.UpdateExpression,
+			ConditionExpression:       updateInput.ConditionExpression,
+			ExpressionAttributeNames:  updateInput.ExpressionAttributeNames,
+			ExpressionAttributeValues: updateInput.ExpressionAttributeValues,
+		}
+		if updateInput.AttributeUpdates != nil {
+			if updateInput.UpdateExpression != nil {
+				return nil, errors.New("AttributeUpdates and UpdateExpression cannot be used together")
+			}
+			if err := db.translateAttributeUpdates(update, updateInput.AttributeUpdates); err != nil {
+				return nil, err
+			}
+		}
+
+		return &dynamodb.TransactWriteItem{Update: update}, nil
+	case input.Delete != nil:
+		if input.Delete.DeleteItemInput.Expected != nil || input.Delete.DeleteItemInput.ConditionalOperator != nil {
+			return nil, errors.New("transactions don't support Expected and ConditionalOperator, use ConditionExpression")
+		}
+
+		return &dynamodb.TransactWriteItem{Delete: &dynamodb.Delete{
+			TableName:                 aws.String(db.config.TableName),
+			Key:                       db.codec.Key(pointInput),
+			ConditionExpression:       input.Delete.DeleteItemInput.ConditionExpression,
+			ExpressionAttributeNames:  input.Delete.DeleteItemInput.ExpressionAttributeNames,
+			ExpressionAttributeValues: input.Delete.DeleteItemInput.ExpressionAttributeValues,
+		}}, nil
+	default:
+		conditionCheck := input.ConditionCheck.ConditionCheck
+		conditionCheck.TableName = aws.String(db.config.TableName)
+		conditionCheck.Key = db.codec.Key(pointInput)
+
+		return &dynamodb.TransactWriteItem{ConditionCheck: &conditionCheck}, nil
+	}
+}
+
+// translateAttributeUpdates sets the update expression of update to the
+// legacy AttributeUpdates, which transactions don't support. Like for
+// updatePoint, the geohash and location attributes are left out.
+func (db db) translateAttributeUpdates(update *dynamodb.Update, attributeUpdates map[string]*dynamodb.AttributeValueUpdate) error {
+	protected := map[string]bool{db.config.GeoHashAttributeName: true}
+	for _, name := range db.codec.LocationAttributeNames() {
+		protected[name] = true
+	}
+
+	attributeNames := make([]string, 0, len(attributeUpdates))
+	for name := range attributeUpdates {
+		if !protected[name] {
+			attributeNames = append(attributeNames, name)
+		}
+	}
+	if len(attributeNames) == 0 {
+		return errors.New("AttributeUpdates only updates the geohash and location attributes")
+	}
+	sort.Strings(attributeNames)
+
+	names := map[string]*string{}
+	for k, name := range update.ExpressionAttributeNames {
+		names[k] = name
+	}
+	values := map[string]*dynamodb.AttributeValue{}
+	for k, value := range update.ExpressionAttributeValues {
+		values[k] = value
+	}
+
+	clauses := map[string][]string{}
+	for i, name := range attributeNames {
+		attributeUpdate := attributeUpdates[name]
+		nameKey, valueKey := fmt.Sprintf("#update%d", i), fmt.Sprintf(":update%d", i)
+		names[nameKey] = aws.String(name)
+		if attributeUpdate.Value != nil {
+			values[valueKey] = attributeUpdate.Value
+		}
+
+		switch action := aws.StringValue(attributeUpdate.Action); {
+		case action == "" || action == dynamodb.AttributeActionPut:
+			clauses["SET"] = append(clauses["SET"], nameKey+" = "+valueKey)
+		case action == dynamodb.AttributeActionAdd:
+			clauses["ADD"] = append(clauses["ADD"], nameKey+" "+valueKey)
+		case action == dynamodb.AttributeActionDelete && attributeUpdate.Value != nil:
+			clauses["DELETE"] = append(clauses["DELETE"], nameKey+" "+valueKey)
+		case action == dynamodb.AttributeActionDelete:
+			clauses["REMOVE"] = append(clauses["REMOVE"], nameKey)
+		default:
+			return fmt.Errorf("unknown action %s of attribute %s", action, name)
+		}
+	}
+
+	expression := []string{}
+	for _, clause := range []string{"SET", "REMOVE", "ADD", "DELETE"} {
+		if len(clauses[clause]) > 0 {
+			expression = append(expression, clause+" "+strings.Join(clauses[clause], ", "))
+		}
+	}
+	update.UpdateExpression = aws.String(strings.Join(expression, " "))
+	update.ExpressionAttributeNames = names
+	if len(values) > 0 {
+		update.ExpressionAttributeValues = values
+	}
+
+	return nil
+}
+
+// transactWritePoints runs all operations in a single transaction. If
+// DynamoDB cancels it, the cancellation reasons are mapped back to the inputs
+// in a *TransactWriteError.
+func (db db) transactWritePoints(ctx aws.Context, inputs []TransactWritePointInput) (*TransactWritePointOutput, error) {
+	transactItems := make([]*dynamodb.TransactWriteItem, len(inputs))
+	for i, input := range inputs {
+		item, err := db.transactItem(input)
+		if err != nil {
+			return nil, err
+		}
+		transactItems[i] = item
+	}
+
+	out, err := db.config.DynamoDBClient.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: transactItems,
+	})
+	if reasons, ok := cancellationReasons(err); ok {
+		return nil, geo.NewTransactError(inputs, reasons, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &TransactWritePointOutput{out}, nil
+}
+
+// cancellationReasons returns the reasons DynamoDB canceled a transaction
+// for, if err is a *dynamodb.TransactionCanceledException.
+func cancellationReasons(err error) ([]geo.CancellationReason, bool) {
+	var canceled *dynamodb.TransactionCanceledException
+	if !errors.As(err, &canceled) {
+		return nil, false
+	}
+
+	reasons := make([]geo.CancellationReason, len(canceled.CancellationReasons))
+	for i, reason := range canceled.CancellationReasons {
+		reasons[i] = geo.CancellationReason{Code: aws.StringValue(reason.Code), Message: aws.StringValue(reason.Message)}
+	}
+
+	return reasons, true
+}
+
+func (db db) deletePoint(ctx aws.Context, input DeletePointInput) (*DeletePointOutput, error) {
+	pointInput, err := geo.ValidPointInput(input.PointInput, db.config.RangeKeyType, db.config.NormalizeLongitude)
+	if err != nil {
+		return nil, err
+	}
 
 	deleteItemInput := input.DeleteItemInput
 	deleteItemInput.TableName = aws.String(db.config.TableName)
-	deleteItemInput.Key = map[string]*dynamodb.AttributeValue{
-		db.config.HashKeyAttributeName:  &dynamodb.AttributeValue{N: aws.String(strconv.FormatUint(hashKey, 10))},
-		db.config.RangeKeyAttributeName: &dynamodb.AttributeValue{S: aws.String(input.RangeKeyValue.String())},
-	}
-	out, err := db.config.DynamoDBClient.DeleteItem(&deleteItemInput)
+	deleteItemInput.Key = db.codec.Key(pointInput)
+	out, err := db.config.DynamoDBClient.DeleteItemWithContext(ctx, &deleteItemInput)
 
 	return &DeletePointOutput{out}, err
 }
@@ -200,7 +744,7 @@ func GetCreateTableRequest(config DynGeoConfig) *dynamodb.CreateTableInput {
 			},
 			&dynamodb.AttributeDefinition{
 				AttributeName: aws.String(config.RangeKeyAttributeName),
-				AttributeType: aws.String("S"),
+				AttributeType: aws.String(config.RangeKeyType.AttributeType()),
 			},
 			&dynamodb.AttributeDefinition{
 				AttributeName: aws.String(config.GeoHashAttributeName),
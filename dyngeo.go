@@ -1,14 +1,14 @@
 package dyngeo
 
 import (
-	"encoding/json"
 	"errors"
-	"sync"
 
 	"github.com/imdario/mergo"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/crolly/dyngeo/internal/geo"
 	"github.com/golang/geo/s2"
 )
 
@@ -28,28 +28,48 @@ func New(config DynGeoConfig) (*DynGeo, error) {
 	}
 
 	defaultConfig := DynGeoConfig{
-		TableName:             config.TableName,
-		ConsistentRead:        false,
-		HashKeyAttributeName:  "hashKey",
-		RangeKeyAttributeName: "rangeKey",
-		GeoHashAttributeName:  "geohash",
-		GeoJSONAttributeName:  "geoJson",
-		GeoHashIndexName:      "geohash-index",
-		HashKeyLength:         2,
-		LongitudeFirst:        true,
+		TableName:              config.TableName,
+		ConsistentRead:         false,
+		HashKeyAttributeName:   "hashKey",
+		RangeKeyAttributeName:  "rangeKey",
+		GeoHashAttributeName:   "geohash",
+		GeoJSONAttributeName:   "geoJson",
+		LatitudeAttributeName:  "lat",
+		LongitudeAttributeName: "lng",
+		GeoHashIndexName:       "geohash-index",
+		HashKeyLength:          2,
+		LongitudeFirst:         true,
+		MaxCells:               10,
 
 		DynamoDBClient: config.DynamoDBClient,
 	}
 
-	err := mergo.Merge(&config, defaultConfig)
+	// cell levels of 0 are valid, so mergo cannot fill them in
+	minCellLevel, maxCellLevel, err := geo.CoverLevels(config.MinCellLevel, config.MaxCellLevel)
 	if err != nil {
 		return nil, err
 	}
+	if config.HashKeyCellLevel != nil {
+		if err := geo.ValidateLevel("HashKeyCellLevel", *config.HashKeyCellLevel); err != nil {
+			return nil, err
+		}
+	} else if config.HashKeyScheme == ParentCellHashKey {
+		return nil, errors.New("HashKeyCellLevel needs to be set with ParentCellHashKey")
+	}
+	if config.MaxCells < 0 {
+		return nil, errors.New("MaxCells needs to be greater than 0")
+	}
+
+	err = mergo.Merge(&config, defaultConfig)
+	if err != nil {
+		return nil, err
+	}
+	config.MinCellLevel, config.MaxCellLevel = minCellLevel, maxCellLevel
 
 	config.s2RegionCoverer = s2.RegionCoverer{
-		MinLevel: 10,
-		MaxLevel: 10,
-		MaxCells: 10,
+		MinLevel: config.MinCellLevel,
+		MaxLevel: config.MaxCellLevel,
+		MaxCells: config.MaxCells,
 		LevelMod: 0,
 	}
 
@@ -60,154 +80,361 @@ func New(config DynGeoConfig) (*DynGeo, error) {
 }
 
 func (dg DynGeo) PutPoint(input PutPointInput) (*PutPointOutput, error) {
-	return dg.db.putPoint(input)
+	return dg.PutPointWithContext(aws.BackgroundContext(), input)
 }
 
+// PutPointWithContext is like PutPoint, but takes a context for cancellation.
+func (dg DynGeo) PutPointWithContext(ctx aws.Context, input PutPointInput) (*PutPointOutput, error) {
+	return dg.db.putPoint(ctx, input)
+}
+
+// BatchWritePoints writes the points in batches of MAX_BATCH_WRITE_ITEMS.
+// Points that could not be written are reported in the output and by a
+// *BatchWriteError. If two inputs are for the same point, nothing is written
+// and the error is ErrDuplicateKey.
 func (dg DynGeo) BatchWritePoints(inputs []PutPointInput) (*BatchWritePointOutput, error) {
-	return dg.db.batchWritePoints(inputs)
+	return dg.BatchWritePointsWithContext(aws.BackgroundContext(), inputs)
+}
+
+// BatchWritePointsWithContext is like BatchWritePoints, but takes a context for cancellation.
+func (dg DynGeo) BatchWritePointsWithContext(ctx aws.Context, inputs []PutPointInput) (*BatchWritePointOutput, error) {
+	return dg.db.batchWritePoints(ctx, inputs)
+}
+
+// BatchGetPoints reads the points in batches of MAX_BATCH_GET_ITEMS and
+// unmarshals the items found into out, in no particular order. Points that
+// don't exist are skipped. Points that could not be read are reported in the
+// output and by a *BatchPointError, after the other points have been
+// unmarshalled. If two inputs are for the same point, nothing is read and the
+// error is ErrDuplicateKey.
+func (dg DynGeo) BatchGetPoints(inputs []PointInput, out interface{}) (*BatchGetPointOutput, error) {
+	return dg.BatchGetPointsWithContext(aws.BackgroundContext(), inputs, out)
+}
+
+// BatchGetPointsWithContext is like BatchGetPoints, but takes a context for cancellation.
+func (dg DynGeo) BatchGetPointsWithContext(ctx aws.Context, inputs []PointInput, out interface{}) (*BatchGetPointOutput, error) {
+	items, output, batchErr := dg.db.batchGetPoints(ctx, inputs)
+	if output == nil {
+		return nil, batchErr
+	}
+
+	if err := dg.unmarshallOutput(items, out); err != nil {
+		return nil, err
+	}
+
+	return output, batchErr
+}
+
+// BatchDeletePoints deletes the points in batches of MAX_BATCH_WRITE_ITEMS.
+// Points that could not be deleted are reported in the output and by a
+// *BatchPointError. If two inputs are for the same point, nothing is deleted
+// and the error is ErrDuplicateKey.
+func (dg DynGeo) BatchDeletePoints(inputs []PointInput) (*BatchDeletePointOutput, error) {
+	return dg.BatchDeletePointsWithContext(aws.BackgroundContext(), inputs)
+}
+
+// BatchDeletePointsWithContext is like BatchDeletePoints, but takes a context for cancellation.
+func (dg DynGeo) BatchDeletePointsWithContext(ctx aws.Context, inputs []PointInput) (*BatchDeletePointOutput, error) {
+	return dg.db.batchDeletePoints(ctx, inputs)
 }
 
 func (dg DynGeo) GetPoint(input GetPointInput) (*GetPointOutput, error) {
-	return dg.db.getPoint(input)
+	return dg.GetPointWithContext(aws.BackgroundContext(), input)
+}
+
+// GetPointWithContext is like GetPoint, but takes a context for cancellation.
+func (dg DynGeo) GetPointWithContext(ctx aws.Context, input GetPointInput) (*GetPointOutput, error) {
+	return dg.db.getPoint(ctx, input)
 }
 
 func (dg DynGeo) UpdatePoint(input UpdatePointInput) (*UpdatePointOutput, error) {
-	return dg.db.updatePoint(input)
+	return dg.UpdatePointWithContext(aws.BackgroundContext(), input)
+}
+
+// UpdatePointWithContext is like UpdatePoint, but takes a context for cancellation.
+func (dg DynGeo) UpdatePointWithContext(ctx aws.Context, input UpdatePointInput) (*UpdatePointOutput, error) {
+	return dg.db.updatePoint(ctx, input)
+}
+
+// TransactWritePoints runs a mix of put, update, delete and condition check
+// operations on points as a single transaction, so either all of them are
+// applied or none. The keys and geo attributes are computed like for
+// PutPoint, UpdatePoint and DeletePoint. Legacy AttributeUpdates are
+// translated into an update expression, legacy Expected conditions are
+// rejected. If DynamoDB cancels the transaction, a *TransactWriteError lists
+// the operations that caused it.
+func (dg DynGeo) TransactWritePoints(inputs []TransactWritePointInput) (*TransactWritePointOutput, error) {
+	return dg.TransactWritePointsWithContext(aws.BackgroundContext(), inputs)
+}
+
+// TransactWritePointsWithContext is like TransactWritePoints, but takes a context for cancellation.
+func (dg DynGeo) TransactWritePointsWithContext(ctx aws.Context, inputs []TransactWritePointInput) (*TransactWritePointOutput, error) {
+	return dg.db.transactWritePoints(ctx, inputs)
+}
+
+// MovePoint moves a point to NewGeoPoint, recomputing its hash key, geohash
+// and location while keeping all other attributes. Location attributes the
+// point has been written with under other StorageFormats are removed, user
+// attributes of the same names are kept. If the hash key changes, the old
+// item is deleted and the new one written in a single transaction, otherwise
+// the item is updated in place. It returns ErrPointNotFound if there is no
+// point at the old location and ErrPointModified if the point has been moved
+// or deleted concurrently.
+func (dg DynGeo) MovePoint(input MovePointInput) (*MovePointOutput, error) {
+	return dg.MovePointWithContext(aws.BackgroundContext(), input)
+}
+
+// MovePointWithContext is like MovePoint, but takes a context for cancellation.
+func (dg DynGeo) MovePointWithContext(ctx aws.Context, input MovePointInput) (*MovePointOutput, error) {
+	return dg.db.movePoint(ctx, input)
 }
 
 func (dg DynGeo) DeletePoint(input DeletePointInput) (*DeletePointOutput, error) {
-	return dg.db.deletePoint(input)
+	return dg.DeletePointWithContext(aws.BackgroundContext(), input)
+}
+
+// DeletePointWithContext is like DeletePoint, but takes a context for cancellation.
+func (dg DynGeo) DeletePointWithContext(ctx aws.Context, input DeletePointInput) (*DeletePointOutput, error) {
+	return dg.db.deletePoint(ctx, input)
 }
 
 func (dg DynGeo) QueryRadius(input QueryRadiusInput, out interface{}) error {
-	output, err := dg.queryRadius(input)
+	return dg.QueryRadiusWithContext(aws.BackgroundContext(), input, out)
+}
+
+// QueryRadiusWithContext is like QueryRadius, but takes a context for cancellation.
+func (dg DynGeo) QueryRadiusWithContext(ctx aws.Context, input QueryRadiusInput, out interface{}) error {
+	q, err := input.query(dg.Config)
 	if err != nil {
 		return err
 	}
 
-	return dg.unmarshallOutput(output, out)
+	return dg.query(ctx, q, input.GeoQueryInput, out)
 }
 
 func (dg DynGeo) QueryRectangle(input QueryRectangleInput, out interface{}) error {
-	output, err := dg.queryRectangle(input)
+	return dg.QueryRectangleWithContext(aws.BackgroundContext(), input, out)
+}
+
+// QueryRectangleWithContext is like QueryRectangle, but takes a context for cancellation.
+func (dg DynGeo) QueryRectangleWithContext(ctx aws.Context, input QueryRectangleInput, out interface{}) error {
+	q, err := input.query(dg.Config)
 	if err != nil {
 		return err
 	}
 
-	return dg.unmarshallOutput(output, out)
+	return dg.query(ctx, q, input.GeoQueryInput, out)
 }
 
-func (dg DynGeo) queryRectangle(input QueryRectangleInput) ([]map[string]*dynamodb.AttributeValue, error) {
-	latLngRect := rectFromQueryRectangleInput(input)
-	covering := newCovering(dg.Config.s2RegionCoverer.Covering(s2.Region(latLngRect)))
-	results := dg.dispatchQueries(covering, input.GeoQueryInput)
+// QueryRadiusPage is like QueryRadius, but returns at most input.Limit
+// results and a token to continue with in the output.
+func (dg DynGeo) QueryRadiusPage(input QueryRadiusInput, out interface{}) (*QueryRadiusOutput, error) {
+	return dg.QueryRadiusPageWithContext(aws.BackgroundContext(), input, out)
+}
 
-	return dg.filterByRect(results, input)
+// QueryRadiusPageWithContext is like QueryRadiusPage, but takes a context for cancellation.
+func (dg DynGeo) QueryRadiusPageWithContext(ctx aws.Context, input QueryRadiusInput, out interface{}) (*QueryRadiusOutput, error) {
+	q, err := input.pageQuery(dg.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := dg.queryPage(ctx, q, input.GeoQueryInput, input.PageInput, out)
+	if output == nil {
+		return nil, err
+	}
+
+	return &QueryRadiusOutput{output}, err
 }
 
-func (dg DynGeo) queryRadius(input QueryRadiusInput) ([]map[string]*dynamodb.AttributeValue, error) {
-	latLngRect := boundingLatLngFromQueryRadiusInput(input)
-	covering := newCovering(dg.Config.s2RegionCoverer.Covering(s2.Region(latLngRect)))
-	results := dg.dispatchQueries(covering, input.GeoQueryInput)
+// QueryRectanglePage is like QueryRectangle, but returns at most input.Limit
+// results and a token to continue with in the output.
+func (dg DynGeo) QueryRectanglePage(input QueryRectangleInput, out interface{}) (*QueryRectangleOutput, error) {
+	return dg.QueryRectanglePageWithContext(aws.BackgroundContext(), input, out)
+}
 
-	return dg.filterByRadius(results, input)
+// QueryRectanglePageWithContext is like QueryRectanglePage, but takes a context for cancellation.
+func (dg DynGeo) QueryRectanglePageWithContext(ctx aws.Context, input QueryRectangleInput, out interface{}) (*QueryRectangleOutput, error) {
+	q, err := input.pageQuery(dg.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := dg.queryPage(ctx, q, input.GeoQueryInput, input.PageInput, out)
+	if output == nil {
+		return nil, err
+	}
+
+	return &QueryRectangleOutput{output}, err
 }
 
-func (dg DynGeo) dispatchQueries(covering covering, input GeoQueryInput) []map[string]*dynamodb.AttributeValue {
-	results := [][]*dynamodb.QueryOutput{}
-	wg := &sync.WaitGroup{}
-	mtx := &sync.Mutex{}
+// QueryRadiusIter streams the results of a radius query. Results are not
+// sorted across pages.
+func (dg DynGeo) QueryRadiusIter(input QueryRadiusInput) *QueryIterator {
+	return dg.QueryRadiusIterWithContext(aws.BackgroundContext(), input)
+}
 
-	hashRanges := covering.getGeoHashRanges(dg.Config.HashKeyLength)
-	iterations := len(hashRanges)
-	wg.Add(iterations)
-	for i := 0; i < iterations; i++ {
-		go func(i int) {
-			defer wg.Done()
-			g := hashRanges[i]
-			hashKey := generateHashKey(g.rangeMin, dg.Config.HashKeyLength)
-			output := dg.db.queryGeoHash(input.QueryInput, hashKey, g)
-			mtx.Lock()
-			results = append(results, output)
-			mtx.Unlock()
-		}(i)
+// QueryRadiusIterWithContext is like QueryRadiusIter, but takes a context for cancellation.
+func (dg DynGeo) QueryRadiusIterWithContext(ctx aws.Context, input QueryRadiusInput) *QueryIterator {
+	q, err := input.query(dg.Config)
+	if err != nil {
+		return &QueryIterator{geo.FailedIterator[*dynamodb.AttributeValue](err)}
 	}
 
-	wg.Wait()
+	return &QueryIterator{dg.Config.engine().Iterate(ctx, q, dg.db.request(input.GeoQueryInput))}
+}
+
+// QueryRectangleIter streams the results of a rectangle query. Results are
+// not sorted across pages.
+func (dg DynGeo) QueryRectangleIter(input QueryRectangleInput) *QueryIterator {
+	return dg.QueryRectangleIterWithContext(aws.BackgroundContext(), input)
+}
 
-	var mergedResults []map[string]*dynamodb.AttributeValue
-	for _, o := range results {
-		for _, r := range o {
-			mergedResults = append(mergedResults, r.Items...)
-		}
+// QueryRectangleIterWithContext is like QueryRectangleIter, but takes a context for cancellation.
+func (dg DynGeo) QueryRectangleIterWithContext(ctx aws.Context, input QueryRectangleInput) *QueryIterator {
+	q, err := input.query(dg.Config)
+	if err != nil {
+		return &QueryIterator{geo.FailedIterator[*dynamodb.AttributeValue](err)}
 	}
 
-	return mergedResults
+	return &QueryIterator{dg.Config.engine().Iterate(ctx, q, dg.db.request(input.GeoQueryInput))}
 }
 
-func (dg DynGeo) filterByRect(list []map[string]*dynamodb.AttributeValue, input QueryRectangleInput) ([]map[string]*dynamodb.AttributeValue, error) {
-	var filtered []map[string]*dynamodb.AttributeValue
-	latLngRect := rectFromQueryRectangleInput(input)
+func (dg DynGeo) QuerySector(input QuerySectorInput, out interface{}) error {
+	return dg.QuerySectorWithContext(aws.BackgroundContext(), input, out)
+}
 
-	for _, item := range list {
-		latLng, err := dg.latLngFromItem(item)
-		if err != nil {
-			return nil, err
-		}
+// QuerySectorWithContext is like QuerySector, but takes a context for cancellation.
+func (dg DynGeo) QuerySectorWithContext(ctx aws.Context, input QuerySectorInput, out interface{}) error {
+	q, err := input.query(dg.Config)
+	if err != nil {
+		return err
+	}
 
-		if latLngRect.ContainsLatLng(*latLng) {
-			filtered = append(filtered, item)
-		}
+	return dg.query(ctx, q, input.GeoQueryInput, out)
+}
+
+func (dg DynGeo) QueryPolygon(input QueryPolygonInput, out interface{}) error {
+	return dg.QueryPolygonWithContext(aws.BackgroundContext(), input, out)
+}
+
+// QueryPolygonWithContext is like QueryPolygon, but takes a context for cancellation.
+func (dg DynGeo) QueryPolygonWithContext(ctx aws.Context, input QueryPolygonInput, out interface{}) error {
+	q, err := input.query()
+	if err != nil {
+		return err
 	}
 
-	return filtered, nil
+	return dg.query(ctx, q, input.GeoQueryInput, out)
 }
 
-func (dg DynGeo) filterByRadius(list []map[string]*dynamodb.AttributeValue, input QueryRadiusInput) ([]map[string]*dynamodb.AttributeValue, error) {
-	var filtered []map[string]*dynamodb.AttributeValue
+func (dg DynGeo) QueryCorridor(input QueryCorridorInput, out interface{}) (*QueryCorridorOutput, error) {
+	return dg.QueryCorridorWithContext(aws.BackgroundContext(), input, out)
+}
 
-	centerLatLng := s2.LatLngFromDegrees(input.CenterPoint.Latitude, input.CenterPoint.Longitude)
-	radius := input.RadiusInMeter
+// QueryCorridorWithContext is like QueryCorridor, but takes a context for cancellation.
+func (dg DynGeo) QueryCorridorWithContext(ctx aws.Context, input QueryCorridorInput, out interface{}) (*QueryCorridorOutput, error) {
+	q, err := input.query(dg.Config)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, item := range list {
-		latLng, err := dg.latLngFromItem(item)
-		if err != nil {
-			return nil, err
-		}
+	req := dg.db.request(input.GeoQueryInput)
+	items, distances, queryErr := dg.Config.engine().Corridor(ctx, q, req)
+	if req.Failed(queryErr) {
+		return nil, queryErr
+	}
 
-		if getEarthDistance(centerLatLng, *latLng) <= float64(radius) {
-			filtered = append(filtered, item)
-		}
+	if err := dg.unmarshallOutput(items, out); err != nil {
+		return nil, err
 	}
 
-	return filtered, nil
+	return &QueryCorridorOutput{RouteDistances: distances}, queryErr
+}
+
+func (dg DynGeo) QueryNearest(input QueryNearestInput, out interface{}) error {
+	return dg.QueryNearestWithContext(aws.BackgroundContext(), input, out)
 }
 
-func (dg DynGeo) latLngFromItem(item map[string]*dynamodb.AttributeValue) (*s2.LatLng, error) {
-	geoJSON := []byte(*item[dg.Config.GeoJSONAttributeName].S)
-	geoJSONAttr := GeoJSONAttribute{}
-	err := json.Unmarshal(geoJSON, &geoJSONAttr)
+// QueryNearestWithContext is like QueryNearest, but takes a context for cancellation.
+func (dg DynGeo) QueryNearestWithContext(ctx aws.Context, input QueryNearestInput, out interface{}) error {
+	q, err := input.query(dg.Config)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	req := dg.db.request(input.GeoQueryInput)
+	items, queryErr := dg.Config.engine().Nearest(ctx, q, req)
+	if req.Failed(queryErr) {
+		return queryErr
+	}
+
+	if err := dg.unmarshallOutput(items, out); err != nil {
+		return err
+	}
+
+	return queryErr
+}
+
+// query runs the query of the shape and unmarshals its results into out.
+// With AllowPartialResults, failed ranges are reported by a *QueryError after
+// the other results have been unmarshalled.
+func (dg DynGeo) query(ctx aws.Context, q geo.Shape, input GeoQueryInput, out interface{}) error {
+	req := dg.db.request(input)
+	items, queryErr := dg.Config.engine().Query(ctx, q, req)
+	if req.Failed(queryErr) {
+		return queryErr
 	}
 
-	coordinates := geoJSONAttr.Coordinates
-	var lng float64
-	var lat float64
-	if dg.Config.LongitudeFirst {
-		lng = coordinates[0]
-		lat = coordinates[1]
-	} else {
-		lng = coordinates[1]
-		lat = coordinates[0]
+	if err := dg.unmarshallOutput(items, out); err != nil {
+		return err
+	}
+
+	return queryErr
+}
+
+// queryPage reads a page of the results of the query and unmarshals them into
+// out.
+func (dg DynGeo) queryPage(ctx aws.Context, q geo.Shape, input GeoQueryInput, page PageInput, out interface{}) (*GeoQueryOutput, error) {
+	req := dg.db.request(input)
+	items, summary, queryErr := dg.Config.engine().QueryPage(ctx, q, req, page)
+	if req.Failed(queryErr) {
+		return nil, queryErr
+	}
+
+	if err := dg.unmarshallOutput(items, out); err != nil {
+		return nil, err
 	}
 
-	latLng := s2.LatLngFromDegrees(lat, lng)
+	return &GeoQueryOutput{
+		QueryOutput: &dynamodb.QueryOutput{
+			Count:            aws.Int64(summary.Count),
+			ScannedCount:     aws.Int64(summary.ScannedCount),
+			ConsumedCapacity: dg.consumedCapacity(summary.CapacityUnits),
+		},
+		ContinuationToken: summary.ContinuationToken,
+	}, queryErr
+}
 
-	return &latLng, nil
+// consumedCapacity returns the capacity units a query consumed on the table.
+func (dg DynGeo) consumedCapacity(capacityUnits float64) *dynamodb.ConsumedCapacity {
+	return &dynamodb.ConsumedCapacity{
+		TableName:     aws.String(dg.Config.TableName),
+		CapacityUnits: aws.Float64(capacityUnits),
+	}
+}
+
+// itemReceiver is implemented by query outputs that unmarshal the items
+// themselves, like the results of a Collection.
+type itemReceiver interface {
+	receive(items []map[string]*dynamodb.AttributeValue) error
 }
 
 func (dg DynGeo) unmarshallOutput(output []map[string]*dynamodb.AttributeValue, out interface{}) error {
+	if receiver, ok := out.(itemReceiver); ok {
+		return receiver.receive(output)
+	}
+
 	err := dynamodbattribute.UnmarshalListOfMaps(output, out)
 	if err != nil {
 		return err
@@ -0,0 +1,1997 @@
+package dyngeo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/crolly/dyngeo/internal/geo"
+	"github.com/gofrs/uuid"
+	"github.com/golang/geo/r3"
+	"github.com/golang/geo/s2"
+)
+
+type testLocation struct {
+	name   string
+	point  GeoPoint
+	inside bool
+}
+
+func newTestDynGeo(t *testing.T) *DynGeo {
+	dg, err := New(DynGeoConfig{
+		DynamoDBClient: &dynamodb.DynamoDB{},
+		TableName:      "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dg
+}
+
+func testItems(t *testing.T, dg *DynGeo, locations []testLocation) []map[string]*dynamodb.AttributeValue {
+	items := []map[string]*dynamodb.AttributeValue{}
+	for _, l := range locations {
+		geoJSON, err := json.Marshal(geo.NewGeoJSONAttribute(l.point.Latitude, l.point.Longitude, dg.Config.LongitudeFirst))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		items = append(items, map[string]*dynamodb.AttributeValue{
+			"name":                         {S: aws.String(l.name)},
+			dg.Config.GeoJSONAttributeName: {S: aws.String(string(geoJSON))},
+		})
+	}
+
+	return items
+}
+
+// rawItems receives the items of a query unmarshalled, see itemReceiver.
+type rawItems []map[string]*dynamodb.AttributeValue
+
+func (r *rawItems) receive(items []map[string]*dynamodb.AttributeValue) error {
+	*r = items
+	return nil
+}
+
+func queryRadius(dg *DynGeo, input QueryRadiusInput) (rawItems, error) {
+	var items rawItems
+	err := dg.QueryRadius(input, &items)
+
+	return items, err
+}
+
+// filterLocations returns the covering of the query and the test locations
+// passing its filter.
+func filterLocations(t *testing.T, dg *DynGeo, q geo.Shape, locations []testLocation) (s2.CellUnion, []map[string]*dynamodb.AttributeValue) {
+	e := dg.Config.engine()
+	found, err := e.Filter(q, testItems(t, dg, locations))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filtered := []map[string]*dynamodb.AttributeValue{}
+	for _, item := range found {
+		filtered = append(filtered, item.Item)
+	}
+
+	covering, err := e.Cover(q.Region())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return covering, filtered
+}
+
+// checkResults verifies that exactly the inside locations passed the filter
+// and that the covering of the query region contains all of them, i.e. that
+// they would have been queried in the first place.
+func checkResults(t *testing.T, covering s2.CellUnion, locations []testLocation, filtered []map[string]*dynamodb.AttributeValue) {
+	found := map[string]bool{}
+	for _, item := range filtered {
+		found[*item["name"].S] = true
+	}
+
+	for _, l := range locations {
+		if found[l.name] != l.inside {
+			t.Errorf("%s: got inside %v, want %v", l.name, found[l.name], l.inside)
+		}
+
+		p := s2.PointFromLatLng(s2.LatLngFromDegrees(l.point.Latitude, l.point.Longitude))
+		if l.inside && !covering.ContainsPoint(p) {
+			t.Errorf("%s: not contained in the covering", l.name)
+		}
+	}
+}
+
+func TestQueryRectangleAntimeridianAndPoles(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     QueryRectangleInput
+		locations []testLocation
+	}{
+		{
+			name: "Fiji",
+			input: QueryRectangleInput{
+				MinPoint: &GeoPoint{Latitude: -21, Longitude: 176},
+				MaxPoint: &GeoPoint{Latitude: -12, Longitude: -178},
+			},
+			locations: []testLocation{
+				{"Suva", GeoPoint{Latitude: -18.1416, Longitude: 178.4415}, true},
+				{"Taveuni", GeoPoint{Latitude: -16.85, Longitude: -179.85}, true},
+				{"Lau Islands", GeoPoint{Latitude: -18.5, Longitude: -178.5}, true},
+				{"Vanuatu", GeoPoint{Latitude: -17.73, Longitude: 168.32}, false},
+				{"Tonga", GeoPoint{Latitude: -21.13, Longitude: -175.2}, false},
+				{"Gulf of Guinea", GeoPoint{Latitude: -18, Longitude: 0}, false},
+			},
+		},
+		{
+			name: "Aleutian Islands",
+			input: QueryRectangleInput{
+				MinPoint: &GeoPoint{Latitude: 51, Longitude: 172},
+				MaxPoint: &GeoPoint{Latitude: 55, Longitude: -165},
+			},
+			locations: []testLocation{
+				{"Attu", GeoPoint{Latitude: 52.93, Longitude: 172.94}, true},
+				{"Amchitka", GeoPoint{Latitude: 51.5, Longitude: 179.0}, true},
+				{"Adak", GeoPoint{Latitude: 51.88, Longitude: -176.66}, true},
+				{"Unalaska", GeoPoint{Latitude: 53.87, Longitude: -166.54}, true},
+				{"Anchorage", GeoPoint{Latitude: 61.22, Longitude: -149.9}, false},
+				{"Petropavlovsk", GeoPoint{Latitude: 53.02, Longitude: 158.65}, false},
+			},
+		},
+		{
+			name: "west of east spanning most of the equator",
+			input: QueryRectangleInput{
+				MinPoint: &GeoPoint{Latitude: 0, Longitude: 10},
+				MaxPoint: &GeoPoint{Latitude: 0.1, Longitude: 0},
+			},
+			locations: []testLocation{
+				{"180", GeoPoint{Latitude: 0.05, Longitude: 180}, true},
+				{"90 east", GeoPoint{Latitude: 0.05, Longitude: 90}, true},
+				{"90 west", GeoPoint{Latitude: 0.05, Longitude: -90}, true},
+				{"gap", GeoPoint{Latitude: 0.05, Longitude: 5}, false},
+			},
+		},
+		{
+			name: "Arctic",
+			input: QueryRectangleInput{
+				MinPoint: &GeoPoint{Latitude: 85, Longitude: -180},
+				MaxPoint: &GeoPoint{Latitude: 90, Longitude: 180},
+			},
+			locations: []testLocation{
+				{"North Pole", GeoPoint{Latitude: 90, Longitude: 0}, true},
+				{"Pacific side", GeoPoint{Latitude: 89, Longitude: -135}, true},
+				{"Atlantic side", GeoPoint{Latitude: 86, Longitude: 20}, true},
+				{"Svalbard", GeoPoint{Latitude: 80, Longitude: 15}, false},
+			},
+		},
+	}
+
+	dg := newTestDynGeo(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := tt.input.query(dg.Config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			covering, filtered := filterLocations(t, dg, q, tt.locations)
+			checkResults(t, covering, tt.locations, filtered)
+		})
+	}
+}
+
+func TestQueryRadiusAntimeridianAndPoles(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     QueryRadiusInput
+		locations []testLocation
+	}{
+		{
+			name: "Fiji",
+			input: QueryRadiusInput{
+				CenterPoint:   GeoPoint{Latitude: -17, Longitude: 180},
+				RadiusInMeter: 100000,
+			},
+			locations: []testLocation{
+				{"west of 180", GeoPoint{Latitude: -17, Longitude: 179.5}, true},
+				{"east of 180", GeoPoint{Latitude: -17, Longitude: -179.5}, true},
+				{"Suva", GeoPoint{Latitude: -18.1416, Longitude: 178.4415}, false},
+			},
+		},
+		{
+			name: "Alaska",
+			input: QueryRadiusInput{
+				CenterPoint:   GeoPoint{Latitude: 51.88, Longitude: -176.66},
+				RadiusInMeter: 400000,
+			},
+			locations: []testLocation{
+				{"Atka", GeoPoint{Latitude: 52.2, Longitude: -174.2}, true},
+				{"Amchitka", GeoPoint{Latitude: 51.5, Longitude: 179.0}, true},
+				{"Attu", GeoPoint{Latitude: 52.93, Longitude: 172.94}, false},
+				{"Unalaska", GeoPoint{Latitude: 53.87, Longitude: -166.54}, false},
+			},
+		},
+		{
+			name: "North Pole",
+			input: QueryRadiusInput{
+				CenterPoint:   GeoPoint{Latitude: 90, Longitude: 0},
+				RadiusInMeter: 100000,
+			},
+			locations: []testLocation{
+				{"Greenwich meridian", GeoPoint{Latitude: 89.5, Longitude: 0}, true},
+				{"90 east", GeoPoint{Latitude: 89.5, Longitude: 90}, true},
+				{"near 180", GeoPoint{Latitude: 89.5, Longitude: 179.9}, true},
+				{"90 west", GeoPoint{Latitude: 89.5, Longitude: -90}, true},
+				{"too far", GeoPoint{Latitude: 89, Longitude: 45}, false},
+			},
+		},
+		{
+			name: "South Pole",
+			input: QueryRadiusInput{
+				CenterPoint:   GeoPoint{Latitude: -90, Longitude: 0},
+				RadiusInMeter: 200000,
+			},
+			locations: []testLocation{
+				{"Amundsen-Scott", GeoPoint{Latitude: -89.99, Longitude: 139.27}, true},
+				{"inside", GeoPoint{Latitude: -88.5, Longitude: 123}, true},
+				{"too far", GeoPoint{Latitude: -88, Longitude: -60}, false},
+			},
+		},
+		{
+			name: "circle over the pole",
+			input: QueryRadiusInput{
+				CenterPoint:   GeoPoint{Latitude: 89.9, Longitude: 0},
+				RadiusInMeter: 50000,
+			},
+			locations: []testLocation{
+				{"across the pole", GeoPoint{Latitude: 89.9, Longitude: 180}, true},
+				{"90 east", GeoPoint{Latitude: 89.7, Longitude: 90}, true},
+				{"too far across the pole", GeoPoint{Latitude: 89.5, Longitude: 180}, false},
+			},
+		},
+	}
+
+	dg := newTestDynGeo(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := tt.input.query(dg.Config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			covering, filtered := filterLocations(t, dg, q, tt.locations)
+			checkResults(t, covering, tt.locations, filtered)
+		})
+	}
+}
+
+// fakeDynamoDB keeps items in memory and answers queries on the geohash index.
+// Queries of hash keys in failHashKeys fail.
+type fakeDynamoDB struct {
+	DynamoDBAPI
+
+	config       DynGeoConfig
+	mtx          sync.Mutex
+	items        []map[string]*dynamodb.AttributeValue
+	failHashKeys map[uint64]bool
+
+	// batchSizes and getSizes record the size of every BatchWriteItem and
+	// BatchGetItem request. Items in unprocessed are left unprocessed as many
+	// times as their count, requests with items in failRangeKeys fail.
+	batchSizes    []int
+	getSizes      []int
+	unprocessed   map[string]int
+	failRangeKeys map[string]bool
+
+	// afterGet is called with the stored item after GetItem read it, e.g. to
+	// modify it concurrently.
+	afterGet func(item map[string]*dynamodb.AttributeValue)
+
+	// transacted is the input of the last TransactWriteItems request.
+	transacted *dynamodb.TransactWriteItemsInput
+
+	// countQueries and itemQueries count the queries with and without Select
+	// COUNT, counted the items found by the former. Every query consumes half
+	// a capacity unit.
+	countQueries int
+	itemQueries  int
+	counted      int64
+}
+
+func (f *fakeDynamoDB) BatchWriteItemWithContext(ctx aws.Context, input *dynamodb.BatchWriteItemInput, opts ...request.Option) (*dynamodb.BatchWriteItemOutput, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	requests := input.RequestItems[f.config.TableName]
+	f.batchSizes = append(f.batchSizes, len(requests))
+	for _, w := range requests {
+		if f.failRangeKeys[f.rangeKey(writeRequestItem(w))] {
+			return nil, errors.New("validation failed")
+		}
+	}
+
+	output := &dynamodb.BatchWriteItemOutput{UnprocessedItems: map[string][]*dynamodb.WriteRequest{}}
+	for _, w := range requests {
+		rangeKey := f.rangeKey(writeRequestItem(w))
+		if f.unprocessed[rangeKey] > 0 {
+			f.unprocessed[rangeKey]--
+			output.UnprocessedItems[f.config.TableName] = append(output.UnprocessedItems[f.config.TableName], w)
+			continue
+		}
+
+		if w.DeleteRequest != nil {
+			if i := f.find(w.DeleteRequest.Key); i >= 0 {
+				f.items = append(f.items[:i], f.items[i+1:]...)
+			}
+			continue
+		}
+		f.items = append(f.items, w.PutRequest.Item)
+	}
+
+	return output, nil
+}
+
+func (f *fakeDynamoDB) BatchGetItemWithContext(ctx aws.Context, input *dynamodb.BatchGetItemInput, opts ...request.Option) (*dynamodb.BatchGetItemOutput, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	keys := input.RequestItems[f.config.TableName].Keys
+	f.getSizes = append(f.getSizes, len(keys))
+	for _, key := range keys {
+		if f.failRangeKeys[f.rangeKey(key)] {
+			return nil, errors.New("validation failed")
+		}
+	}
+
+	output := &dynamodb.BatchGetItemOutput{
+		Responses:       map[string][]map[string]*dynamodb.AttributeValue{},
+		UnprocessedKeys: map[string]*dynamodb.KeysAndAttributes{},
+	}
+	unprocessed := []map[string]*dynamodb.AttributeValue{}
+	for _, key := range keys {
+		rangeKey := f.rangeKey(key)
+		if f.unprocessed[rangeKey] > 0 {
+			f.unprocessed[rangeKey]--
+			unprocessed = append(unprocessed, key)
+			continue
+		}
+
+		if i := f.find(key); i >= 0 {
+			output.Responses[f.config.TableName] = append(output.Responses[f.config.TableName], f.items[i])
+		}
+	}
+	if len(unprocessed) > 0 {
+		output.UnprocessedKeys[f.config.TableName] = &dynamodb.KeysAndAttributes{Keys: unprocessed}
+	}
+
+	return output, nil
+}
+
+func (f *fakeDynamoDB) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if i := f.find(input.Key); i >= 0 {
+		item := map[string]*dynamodb.AttributeValue{}
+		for name, value := range f.items[i] {
+			item[name] = value
+		}
+		if f.afterGet != nil {
+			f.afterGet(f.items[i])
+		}
+
+		return &dynamodb.GetItemOutput{Item: item}, nil
+	}
+
+	return &dynamodb.GetItemOutput{}, nil
+}
+
+// UpdateItemWithContext only supports the SET, REMOVE and condition
+// expressions of MovePoint.
+func (f *fakeDynamoDB) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	i := f.find(input.Key)
+	if i < 0 || !f.holds(input.Key, input.ExpressionAttributeNames, input.ExpressionAttributeValues) {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
+	for name, attr := range input.ExpressionAttributeNames {
+		switch {
+		case strings.HasPrefix(name, "#stale"):
+			delete(f.items[i], *attr)
+		case !strings.HasPrefix(name, "#read"):
+			if value, ok := input.ExpressionAttributeValues[":"+name[1:]]; ok {
+				f.items[i][*attr] = value
+			}
+		}
+	}
+
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+// TransactWriteItemsWithContext cancels transactions with items in
+// failRangeKeys or deletes whose "#readN = :readN" conditions don't hold and
+// ignores updates and condition checks otherwise.
+func (f *fakeDynamoDB) TransactWriteItemsWithContext(ctx aws.Context, input *dynamodb.TransactWriteItemsInput, opts ...request.Option) (*dynamodb.TransactWriteItemsOutput, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	f.transacted = input
+	canceled := &dynamodb.TransactionCanceledException{Message_: aws.String("Transaction cancelled")}
+	failed := false
+	for _, w := range input.TransactItems {
+		var key map[string]*dynamodb.AttributeValue
+		switch {
+		case w.ConditionCheck != nil:
+			key = w.ConditionCheck.Key
+		case w.Delete != nil:
+			key = w.Delete.Key
+		case w.Put != nil:
+			key = w.Put.Item
+		case w.Update != nil:
+			key = w.Update.Key
+		}
+
+		reason := &dynamodb.CancellationReason{Code: aws.String("None")}
+		if f.failRangeKeys[f.rangeKey(key)] || (w.Delete != nil && !f.holds(w.Delete.Key, w.Delete.ExpressionAttributeNames, w.Delete.ExpressionAttributeValues)) {
+			reason = &dynamodb.CancellationReason{Code: aws.String("ConditionalCheckFailed"), Message: aws.String("The conditional request failed")}
+			failed = true
+		}
+		canceled.CancellationReasons = append(canceled.CancellationReasons, reason)
+	}
+	if failed {
+		return nil, canceled
+	}
+
+	for _, w := range input.TransactItems {
+		switch {
+		case w.Delete != nil:
+			if i := f.find(w.Delete.Key); i >= 0 {
+				f.items = append(f.items[:i], f.items[i+1:]...)
+			}
+		case w.Put != nil:
+			f.items = append(f.items, w.Put.Item)
+		}
+	}
+
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+// holds reports whether the "#readN = :readN" conditions of MovePoint hold
+// for the item with the key.
+func (f *fakeDynamoDB) holds(key map[string]*dynamodb.AttributeValue, names map[string]*string, values map[string]*dynamodb.AttributeValue) bool {
+	i := f.find(key)
+	for name, attr := range names {
+		if strings.HasPrefix(name, "#read") && (i < 0 || !reflect.DeepEqual(f.items[i][*attr], values[":"+name[1:]])) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// rangeKey returns the string or number range key of the item.
+func (f *fakeDynamoDB) rangeKey(item map[string]*dynamodb.AttributeValue) string {
+	rangeKey := item[f.config.RangeKeyAttributeName]
+
+	return aws.StringValue(rangeKey.S) + aws.StringValue(rangeKey.N)
+}
+
+// find returns the index of the item with the key or -1.
+func (f *fakeDynamoDB) find(key map[string]*dynamodb.AttributeValue) int {
+	for i, item := range f.items {
+		if *item[f.config.HashKeyAttributeName].N == *key[f.config.HashKeyAttributeName].N &&
+			f.rangeKey(item) == f.rangeKey(key) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (f *fakeDynamoDB) DeleteItemWithContext(ctx aws.Context, input *dynamodb.DeleteItemInput, opts ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if i := f.find(input.Key); i >= 0 {
+		f.items = append(f.items[:i], f.items[i+1:]...)
+	}
+
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func (f *fakeDynamoDB) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	f.items = append(f.items, input.Item)
+
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (f *fakeDynamoDB) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	hashKey := *input.KeyConditions[f.config.HashKeyAttributeName].AttributeValueList[0].N
+	between := input.KeyConditions[f.config.GeoHashAttributeName].AttributeValueList
+	min, _ := strconv.ParseUint(*between[0].N, 10, 64)
+	max, _ := strconv.ParseUint(*between[1].N, 10, 64)
+
+	if h, _ := strconv.ParseUint(hashKey, 10, 64); f.failHashKeys[h] {
+		return nil, errors.New("throttled")
+	}
+
+	output := &dynamodb.QueryOutput{}
+	for _, item := range f.items {
+		geoHash, _ := strconv.ParseUint(*item[f.config.GeoHashAttributeName].N, 10, 64)
+		if *item[f.config.HashKeyAttributeName].N == hashKey && geoHash >= min && geoHash <= max {
+			// like DynamoDB, every query returns new items
+			found := map[string]*dynamodb.AttributeValue{}
+			for name, value := range item {
+				found[name] = value
+			}
+			output.Items = append(output.Items, found)
+		}
+	}
+	output.Count = aws.Int64(int64(len(output.Items)))
+	output.ConsumedCapacity = &dynamodb.ConsumedCapacity{CapacityUnits: aws.Float64(0.5)}
+	if aws.StringValue(input.Select) == "COUNT" {
+		f.countQueries++
+		f.counted += *output.Count
+		output.Items = nil
+	} else {
+		f.itemQueries++
+	}
+
+	return output, nil
+}
+
+func newFakeDynGeo(t *testing.T, config DynGeoConfig) (*DynGeo, *fakeDynamoDB) {
+	fake := &fakeDynamoDB{
+		failHashKeys:  map[uint64]bool{},
+		unprocessed:   map[string]int{},
+		failRangeKeys: map[string]bool{},
+	}
+	config.DynamoDBClient = fake
+	config.TableName = "test"
+
+	dg, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.config = dg.Config
+
+	return dg, fake
+}
+
+func TestQueryRadiusFakeClient(t *testing.T) {
+	dg, fake := newFakeDynGeo(t, DynGeoConfig{HashKeyScheme: ParentCellHashKey, HashKeyCellLevel: aws.Int(14)})
+
+	center := GeoPoint{Latitude: 40.7769099, Longitude: -73.9822532}
+	points := map[string]GeoPoint{
+		"central park":  {Latitude: 40.7812, Longitude: -73.9665},
+		"times square":  {Latitude: 40.7580, Longitude: -73.9855},
+		"brooklyn":      {Latitude: 40.6782, Longitude: -73.9442},
+		"jersey city":   {Latitude: 40.7178, Longitude: -74.0431},
+		"philadelphia":  {Latitude: 39.9526, Longitude: -75.1652},
+		"lincoln plaza": {Latitude: 40.7725, Longitude: -73.9835},
+	}
+	for name, p := range points {
+		input := PutPointInput{PointInput: PointInput{RangeKeyValue: RangeKeyFromString(name), GeoPoint: p}}
+		input.PutItemInput.Item = map[string]*dynamodb.AttributeValue{"name": {S: aws.String(name)}}
+		if _, err := dg.PutPoint(input); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	input := QueryRadiusInput{CenterPoint: center, RadiusInMeter: 5000}
+	items, err := queryRadius(dg, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]bool{}
+	for _, item := range items {
+		found[*item["name"].S] = true
+	}
+	for _, name := range []string{"central park", "times square", "lincoln plaza"} {
+		if !found[name] {
+			t.Errorf("%s not found", name)
+		}
+	}
+	if len(found) != 3 {
+		t.Errorf("found %v, want 3 points", found)
+	}
+
+	// fail the hash key of times square
+	_, hashKey := dg.db.codec.Hasher.Hashes(points["times square"].LatLng())
+	fake.failHashKeys[hashKey] = true
+
+	_, err = queryRadius(dg, input)
+	var queryErr *QueryError
+	if !errors.As(err, &queryErr) || len(queryErr.Failures) == 0 {
+		t.Fatalf("got error %v, want a *QueryError", err)
+	}
+	for _, f := range queryErr.Failures {
+		if f.HashKey != hashKey {
+			t.Errorf("failed hash key %d, want %d", f.HashKey, hashKey)
+		}
+	}
+
+	input.AllowPartialResults = true
+	items, err = queryRadius(dg, input)
+	if !errors.As(err, &queryErr) {
+		t.Fatalf("got error %v, want a *QueryError", err)
+	}
+	for _, item := range items {
+		if name := *item["name"].S; name == "times square" {
+			t.Errorf("%s found in a failed range", name)
+		}
+	}
+	if len(items) == 0 {
+		t.Error("no partial results")
+	}
+
+	// a canceled context stops the queries of all ranges
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	queries := fake.itemQueries
+	err = dg.QueryRadiusWithContext(ctx, input, &rawItems{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+	if fake.itemQueries != queries {
+		t.Errorf("got %d queries after canceling, want none", fake.itemQueries-queries)
+	}
+}
+
+func TestCountRadiusFakeClient(t *testing.T) {
+	dg, fake := newFakeDynGeo(t, DynGeoConfig{})
+
+	center := GeoPoint{Latitude: 40.7769099, Longitude: -73.9822532}
+	input := QueryRadiusInput{CenterPoint: center, RadiusInMeter: 40000}
+	var want int64
+	for lat := 40.3; lat <= 41.25; lat += 0.05 {
+		for lng := -74.6; lng <= -73.35; lng += 0.05 {
+			p := GeoPoint{Latitude: lat, Longitude: lng}
+			if _, err := dg.PutPoint(PutPointInput{PointInput: PointInput{RangeKeyValue: RangeKeyFromString(strconv.Itoa(len(fake.items))), GeoPoint: p}}); err != nil {
+				t.Fatal(err)
+			}
+			if geo.EarthDistance(center.LatLng(), p.LatLng()) <= float64(input.RadiusInMeter) {
+				want++
+			}
+		}
+	}
+
+	output, err := dg.CountRadius(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output.Count != want {
+		t.Errorf("counted %d points, want %d", output.Count, want)
+	}
+
+	// interior cells are counted by DynamoDB, boundary cells are read and
+	// filtered
+	if fake.countQueries == 0 || fake.counted == 0 {
+		t.Errorf("got %d COUNT queries counting %d points, want interior cells counted", fake.countQueries, fake.counted)
+	}
+	if fake.itemQueries == 0 {
+		t.Error("got no queries reading the items of boundary cells")
+	}
+	if fake.counted >= want {
+		t.Errorf("COUNT queries counted %d points, want fewer than the %d within the radius", fake.counted, want)
+	}
+	queries := fake.countQueries + fake.itemQueries
+	if units := aws.Float64Value(output.ConsumedCapacity.CapacityUnits); units != 0.5*float64(queries) {
+		t.Errorf("consumed %v capacity units, want %v for %d queries", units, 0.5*float64(queries), queries)
+	}
+}
+
+func TestCollectionFakeClient(t *testing.T) {
+	type store struct {
+		ID       string  `dyngeo:"id" dynamodbav:"-"`
+		Lat      float64 `dyngeo:"lat" dynamodbav:"-"`
+		Lng      float64 `dyngeo:"lng" dynamodbav:"-"`
+		Distance float64 `dyngeo:"distance" dynamodbav:"distance"`
+	}
+
+	if _, err := NewCollection[struct{ Name string }](&DynGeo{}); err == nil {
+		t.Error("got no error for a type without geo fields")
+	}
+
+	dg, fake := newFakeDynGeo(t, DynGeoConfig{})
+	stores, err := NewCollection[store](dg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	points := map[string]GeoPoint{
+		"central park":  {Latitude: 40.7812, Longitude: -73.9665},
+		"times square":  {Latitude: 40.7580, Longitude: -73.9855},
+		"lincoln plaza": {Latitude: 40.7725, Longitude: -73.9835},
+		"astoria":       {Latitude: 40.7644, Longitude: -73.9235},
+		"philadelphia":  {Latitude: 39.9526, Longitude: -75.1652},
+	}
+	for id, p := range points {
+		if err := stores.Put(store{ID: id, Lat: p.Latitude, Lng: p.Longitude}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(fake.items) != len(points) {
+		t.Fatalf("got %d items, want %d", len(fake.items), len(points))
+	}
+	if rangeKey := fake.rangeKey(fake.items[0]); points[rangeKey] == (GeoPoint{}) {
+		t.Errorf("got range key %q, want the id", rangeKey)
+	}
+
+	// the geo fields are filled from the stored point
+	key := store{ID: "times square", Lat: 40.7580, Lng: -73.9855}
+	got, err := stores.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != key.ID || math.Abs(got.Lat-key.Lat) > 1e-9 || math.Abs(got.Lng-key.Lng) > 1e-9 || got.Distance != 0 {
+		t.Errorf("got %+v, want %+v", got, key)
+	}
+
+	center := GeoPoint{Latitude: 40.7769099, Longitude: -73.9822532}
+	minPoint := GeoPoint{Latitude: 40.75, Longitude: -73.99}
+	maxPoint := GeoPoint{Latitude: 40.79, Longitude: -73.96}
+	// iterated results arrive unsorted
+	collect := func(it *CollectionIterator[store]) ([]store, error) {
+		defer it.Close()
+		results := []store{}
+		for it.Next() {
+			results = append(results, it.Item())
+		}
+		sort.Slice(results, func(i, j int) bool { return results[i].ID < results[j].ID })
+		return results, it.Err()
+	}
+	tests := []struct {
+		name  string
+		query func() ([]store, error)
+		want  []string
+	}{
+		{
+			name: "radius",
+			query: func() ([]store, error) {
+				input := QueryRadiusInput{CenterPoint: center, RadiusInMeter: 5000}
+				input.SortByDistance = SortAscending
+				return stores.QueryRadius(input)
+			},
+			want: []string{"lincoln plaza", "central park", "times square"},
+		},
+		{
+			name: "rectangle",
+			query: func() ([]store, error) {
+				input := QueryRectangleInput{MinPoint: &minPoint, MaxPoint: &maxPoint}
+				input.SortByDistance = SortDescending
+				return stores.QueryRectangle(input)
+			},
+			want: []string{"times square", "central park", "lincoln plaza"},
+		},
+		{
+			name: "radius iterator",
+			query: func() ([]store, error) {
+				return collect(stores.QueryRadiusIter(QueryRadiusInput{CenterPoint: center, RadiusInMeter: 5000}))
+			},
+			want: []string{"central park", "lincoln plaza", "times square"},
+		},
+		{
+			name: "rectangle iterator",
+			query: func() ([]store, error) {
+				return collect(stores.QueryRectangleIter(QueryRectangleInput{MinPoint: &minPoint, MaxPoint: &maxPoint}))
+			},
+			want: []string{"central park", "lincoln plaza", "times square"},
+		},
+		{
+			name: "sector",
+			query: func() ([]store, error) {
+				input := QuerySectorInput{CenterPoint: center, RadiusInMeter: 6000, HeadingInDegree: 90, WidthInDegree: 90}
+				input.SortByDistance = SortAscending
+				return stores.QuerySector(input)
+			},
+			want: []string{"central park", "astoria"},
+		},
+		{
+			name: "polygon",
+			query: func() ([]store, error) {
+				return stores.QueryPolygon(QueryPolygonInput{
+					GeoJSON: []byte(`{"type":"Polygon","coordinates":[[[-73.93,40.755],[-73.91,40.755],[-73.91,40.775],[-73.93,40.775],[-73.93,40.755]]]}`),
+				})
+			},
+			want: []string{"astoria"},
+		},
+		{
+			name: "nearest",
+			query: func() ([]store, error) {
+				return stores.QueryNearest(QueryNearestInput{CenterPoint: center, K: 2, MaxDistanceInMeter: 10000})
+			},
+			want: []string{"lincoln plaza", "central park"},
+		},
+		{
+			name: "corridor",
+			query: func() ([]store, error) {
+				results, output, err := stores.QueryCorridor(QueryCorridorInput{
+					Polyline:      []GeoPoint{{Latitude: 40.70, Longitude: -73.99}, {Latitude: 40.80, Longitude: -73.99}},
+					BufferInMeter: 800,
+				})
+				if err == nil && len(output.RouteDistances) != len(results) {
+					t.Errorf("got %d route distances for %d results", len(output.RouteDistances), len(results))
+				}
+				return results, err
+			},
+			want: []string{"times square", "lincoln plaza"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := tt.query()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := []string{}
+			for _, r := range results {
+				ids = append(ids, r.ID)
+				p := points[r.ID]
+				if math.Abs(r.Lat-p.Latitude) > 1e-9 || math.Abs(r.Lng-p.Longitude) > 1e-9 {
+					t.Errorf("%s: got location %v, %v, want %+v", r.ID, r.Lat, r.Lng, p)
+				}
+				if r.Distance <= 0 {
+					t.Errorf("%s: got distance %v, want it filled in", r.ID, r.Distance)
+				}
+			}
+			if !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("got %v, want %v", ids, tt.want)
+			}
+		})
+	}
+
+	if err := stores.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stores.Get(key); !errors.Is(err, ErrPointNotFound) {
+		t.Errorf("got error %v after Delete, want ErrPointNotFound", err)
+	}
+}
+
+// testPutInputs returns n points north of each other with random range keys.
+func testPutInputs(n int) []PutPointInput {
+	inputs := make([]PutPointInput, n)
+	for i := range inputs {
+		inputs[i].RangeKeyValue = RangeKeyFromUUID(uuid.Must(uuid.NewV4()))
+		inputs[i].GeoPoint = GeoPoint{Latitude: 40 + float64(i)/100, Longitude: -74}
+	}
+
+	return inputs
+}
+
+// failedInputs returns the positions of the failed points in inputs.
+func failedInputs(inputs []PointInput, failed []PointInput) []int {
+	positions := []int{}
+	for _, f := range failed {
+		for i, input := range inputs {
+			if input.RangeKeyValue == f.RangeKeyValue {
+				positions = append(positions, i)
+				break
+			}
+		}
+	}
+
+	return positions
+}
+
+// span returns the integers [from, to).
+func span(from, to int) []int {
+	s := []int{}
+	for i := from; i < to; i++ {
+		s = append(s, i)
+	}
+
+	return s
+}
+
+func TestBatchWritePointsFakeClient(t *testing.T) {
+	defer geo.SetRetryBaseDelay(geo.SetRetryBaseDelay(time.Microsecond))
+
+	tests := []struct {
+		name        string
+		duplicate   int
+		unprocessed map[int]int
+		failing     []int
+		wantBatches int
+		wantFailed  []int
+		wantErr     error
+		wantMessage string
+	}{
+		{name: "chunks", wantBatches: 3},
+		// the first and last chunk are retried once and twice
+		{name: "unprocessed items", unprocessed: map[int]int{3: 1, 59: 2}, wantBatches: 6},
+		// the first chunk is retried MAX_BATCH_RETRIES times, the second once
+		{
+			name:        "exhausted retries",
+			unprocessed: map[int]int{3: MAX_BATCH_RETRIES + 1, 42: 1},
+			wantBatches: 3 + MAX_BATCH_RETRIES + 1,
+			wantFailed:  []int{3},
+			wantErr:     ErrUnprocessed,
+		},
+		// a failing request fails the other items of its chunk as well
+		{name: "failing request", failing: []int{30}, wantBatches: 3, wantFailed: span(25, 50), wantMessage: "validation failed"},
+		// the same point twice fails the whole batch before anything is sent
+		{name: "duplicate keys", duplicate: 42, wantErr: ErrDuplicateKey, wantMessage: "inputs 42 and 60"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dg, fake := newFakeDynGeo(t, DynGeoConfig{})
+			inputs := testPutInputs(60)
+			points := make([]PointInput, len(inputs))
+			for i, input := range inputs {
+				points[i] = input.PointInput
+			}
+			for i, n := range tt.unprocessed {
+				fake.unprocessed[inputs[i].RangeKeyValue.String()] = n
+			}
+			for _, i := range tt.failing {
+				fake.failRangeKeys[inputs[i].RangeKeyValue.String()] = true
+			}
+			if tt.duplicate > 0 {
+				inputs = append(inputs, inputs[tt.duplicate])
+			}
+
+			output, err := dg.BatchWritePoints(inputs)
+			if (err != nil) != (tt.wantErr != nil || tt.wantFailed != nil) || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), tt.wantMessage) {
+				t.Errorf("got error %q, want it to mention %q", err, tt.wantMessage)
+			}
+
+			if len(fake.batchSizes) != tt.wantBatches {
+				t.Errorf("sent %d batches, want %d", len(fake.batchSizes), tt.wantBatches)
+			}
+			for _, size := range fake.batchSizes {
+				if size > MAX_BATCH_WRITE_ITEMS {
+					t.Errorf("batch of %d items", size)
+				}
+			}
+			if tt.duplicate > 0 {
+				if output != nil || len(fake.items) != 0 {
+					t.Errorf("got output %v and %d items, want nothing written", output, len(fake.items))
+				}
+				return
+			}
+
+			failed := []PointInput{}
+			for _, f := range output.Failures {
+				failed = append(failed, f.Input.PointInput)
+			}
+			if got := failedInputs(points, failed); len(got)+len(tt.wantFailed) > 0 && !reflect.DeepEqual(got, tt.wantFailed) {
+				t.Errorf("got failed inputs %v, want %v", got, tt.wantFailed)
+			}
+			var batchErr *BatchWriteError
+			if tt.wantFailed != nil && !errors.As(err, &batchErr) {
+				t.Errorf("got error %v, want a *BatchWriteError", err)
+			}
+			if n := len(output.UnprocessedItems[dg.Config.TableName]); n != len(tt.wantFailed) {
+				t.Errorf("got %d unprocessed items, want %d", n, len(tt.wantFailed))
+			}
+			if want := len(points) - len(tt.wantFailed); len(fake.items) != want {
+				t.Errorf("wrote %d items, want %d", len(fake.items), want)
+			}
+		})
+	}
+}
+
+func TestBatchGetAndDeletePointsFakeClient(t *testing.T) {
+	defer geo.SetRetryBaseDelay(geo.SetRetryBaseDelay(time.Microsecond))
+
+	// get reads the points, delete deletes them; both return the remaining
+	// items of the table
+	type operation func(dg *DynGeo, fake *fakeDynamoDB, points []PointInput) ([]BatchPointFailure, int, error)
+	get := func(dg *DynGeo, fake *fakeDynamoDB, points []PointInput) ([]BatchPointFailure, int, error) {
+		items, output, err := dg.db.batchGetPoints(aws.BackgroundContext(), points)
+		if output == nil {
+			return nil, len(items), err
+		}
+		return output.Failures, len(items), err
+	}
+	del := func(dg *DynGeo, fake *fakeDynamoDB, points []PointInput) ([]BatchPointFailure, int, error) {
+		output, err := dg.BatchDeletePoints(points)
+		if output == nil {
+			return nil, len(fake.items), err
+		}
+		return output.Failures, len(fake.items), err
+	}
+
+	missing := PointInput{RangeKeyValue: RangeKeyFromUUID(uuid.Must(uuid.NewV4())), GeoPoint: GeoPoint{Latitude: 1, Longitude: 1}}
+	tests := []struct {
+		name        string
+		op          operation
+		points      func(points []PointInput) []PointInput
+		unprocessed map[int]int
+		failing     []int
+		wantBatches int
+		wantFailed  []int
+		wantItems   int
+		wantErr     error
+		wantMessage string
+	}{
+		{
+			name:        "get chunks",
+			op:          get,
+			points:      func(p []PointInput) []PointInput { return append(p, missing) },
+			wantBatches: 2,
+			wantItems:   150,
+		},
+		// the chunks are retried with the single unprocessed keys
+		{name: "get unprocessed keys", op: get, unprocessed: map[int]int{7: 1, 120: 2}, wantBatches: 5, wantItems: 150},
+		{
+			name:        "get exhausted retries",
+			op:          get,
+			unprocessed: map[int]int{7: MAX_BATCH_RETRIES + 1},
+			wantBatches: 2 + MAX_BATCH_RETRIES,
+			wantFailed:  []int{7},
+			wantItems:   149,
+			wantErr:     ErrUnprocessed,
+		},
+		// a failing request fails the other keys of its chunk as well
+		{name: "get failing request", op: get, failing: []int{120}, wantBatches: 2, wantFailed: span(100, 150), wantItems: 100, wantMessage: "validation failed"},
+		{
+			name:        "get duplicate keys",
+			op:          get,
+			points:      func(p []PointInput) []PointInput { return append(p, p[7]) },
+			wantErr:     ErrDuplicateKey,
+			wantMessage: "inputs 7 and 150",
+		},
+		{name: "delete chunks", op: del, points: func(p []PointInput) []PointInput { return p[:100] }, wantBatches: 4, wantItems: 50},
+		{
+			name:        "delete exhausted retries",
+			op:          del,
+			points:      func(p []PointInput) []PointInput { return p[:100] },
+			unprocessed: map[int]int{3: MAX_BATCH_RETRIES + 1},
+			wantBatches: 4 + MAX_BATCH_RETRIES,
+			wantFailed:  []int{3},
+			wantItems:   51,
+			wantErr:     ErrUnprocessed,
+		},
+		{
+			name:        "delete duplicate keys",
+			op:          del,
+			points:      func(p []PointInput) []PointInput { return []PointInput{p[3], p[0], p[3]} },
+			wantItems:   150,
+			wantErr:     ErrDuplicateKey,
+			wantMessage: "inputs 0 and 2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dg, fake := newFakeDynGeo(t, DynGeoConfig{})
+			inputs := testPutInputs(150)
+			if _, err := dg.BatchWritePoints(inputs); err != nil {
+				t.Fatal(err)
+			}
+			fake.batchSizes = nil
+
+			points := make([]PointInput, len(inputs))
+			for i, input := range inputs {
+				points[i] = input.PointInput
+			}
+			for i, n := range tt.unprocessed {
+				fake.unprocessed[inputs[i].RangeKeyValue.String()] = n
+			}
+			for _, i := range tt.failing {
+				fake.failRangeKeys[inputs[i].RangeKeyValue.String()] = true
+			}
+			sent := points
+			if tt.points != nil {
+				sent = tt.points(points)
+			}
+
+			failures, items, err := tt.op(dg, fake, sent)
+			if (err != nil) != (tt.wantErr != nil || tt.wantFailed != nil) || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), tt.wantMessage) {
+				t.Errorf("got error %q, want it to mention %q", err, tt.wantMessage)
+			}
+			var batchErr *BatchPointError
+			if tt.wantFailed != nil && !errors.As(err, &batchErr) {
+				t.Errorf("got error %v, want a *BatchPointError", err)
+			}
+
+			failed := []PointInput{}
+			for _, f := range failures {
+				failed = append(failed, f.Input)
+			}
+			if got := failedInputs(points, failed); len(got)+len(tt.wantFailed) > 0 && !reflect.DeepEqual(got, tt.wantFailed) {
+				t.Errorf("got failed inputs %v, want %v", got, tt.wantFailed)
+			}
+			if items != tt.wantItems {
+				t.Errorf("got %d items, want %d", items, tt.wantItems)
+			}
+
+			batches := append(fake.getSizes, fake.batchSizes...)
+			if len(batches) != tt.wantBatches {
+				t.Errorf("sent %d batches, want %d", len(batches), tt.wantBatches)
+			}
+			for _, size := range fake.getSizes {
+				if size > MAX_BATCH_GET_ITEMS {
+					t.Errorf("batch of %d keys", size)
+				}
+			}
+		})
+	}
+}
+
+func TestMovePointFakeClient(t *testing.T) {
+	dg, fake := newFakeDynGeo(t, DynGeoConfig{})
+
+	input := PointInput{RangeKeyValue: RangeKeyFromUUID(uuid.Must(uuid.NewV4())), GeoPoint: GeoPoint{Latitude: 40.7128, Longitude: -74.006}}
+	_, err := dg.PutPoint(PutPointInput{
+		PointInput: input,
+		PutItemInput: dynamodb.PutItemInput{
+			Item: map[string]*dynamodb.AttributeValue{"name": &dynamodb.AttributeValue{S: aws.String("New York")}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(p GeoPoint) {
+		t.Helper()
+		geoHash, hashKey := dg.db.codec.Hasher.Hashes(p.LatLng())
+		if len(fake.items) != 1 {
+			t.Fatalf("got %d items, want 1", len(fake.items))
+		}
+		item := fake.items[0]
+		if *item[dg.Config.HashKeyAttributeName].N != strconv.FormatUint(hashKey, 10) || *item[dg.Config.GeoHashAttributeName].N != strconv.FormatUint(geoHash, 10) {
+			t.Errorf("item %v not moved to %v", item, p)
+		}
+		if aws.StringValue(item["name"].S) != "New York" || *item[dg.Config.RangeKeyAttributeName].S != input.RangeKeyValue.String() {
+			t.Errorf("item %v lost its attributes", item)
+		}
+	}
+
+	// a few meters stay within the hash key
+	nearby := GeoPoint{Latitude: 40.7129, Longitude: -74.0061}
+	output, err := dg.MovePoint(MovePointInput{PointInput: input, NewGeoPoint: nearby})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output.UpdateItemOutput == nil {
+		t.Error("moving within the hash key didn't update the item")
+	}
+	check(nearby)
+
+	input.GeoPoint = nearby
+	tokyo := GeoPoint{Latitude: 35.6762, Longitude: 139.6503}
+	output, err = dg.MovePoint(MovePointInput{PointInput: input, NewGeoPoint: tokyo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output.TransactWriteItemsOutput == nil {
+		t.Error("moving to another hash key didn't use a transaction")
+	}
+	check(tokyo)
+
+	// the point isn't at the old location anymore
+	for _, p := range []GeoPoint{tokyo, {Latitude: 40.7129, Longitude: -74.0062}} {
+		if _, err := dg.MovePoint(MovePointInput{PointInput: input, NewGeoPoint: p}); !errors.Is(err, ErrPointNotFound) {
+			t.Errorf("got error %v, want ErrPointNotFound", err)
+		}
+	}
+
+	// the point is moved between reading and moving it, within the hash key
+	// and to another one
+	input.GeoPoint = tokyo
+	geoHash := fake.items[0][dg.Config.GeoHashAttributeName]
+	fake.afterGet = func(item map[string]*dynamodb.AttributeValue) {
+		item[dg.Config.GeoHashAttributeName] = &dynamodb.AttributeValue{N: aws.String("1")}
+	}
+	for _, p := range []GeoPoint{{Latitude: 35.6763, Longitude: 139.6504}, nearby} {
+		fake.items[0][dg.Config.GeoHashAttributeName] = geoHash
+		if _, err := dg.MovePoint(MovePointInput{PointInput: input, NewGeoPoint: p}); !errors.Is(err, ErrPointModified) {
+			t.Errorf("got error %v moving to %v, want ErrPointModified", err, p)
+		}
+		if len(fake.items) != 1 || *fake.items[0][dg.Config.GeoHashAttributeName].N != "1" {
+			t.Errorf("got items %v, want the moved item left in place", fake.items)
+		}
+	}
+
+	// other attributes may change concurrently
+	fake.afterGet = func(item map[string]*dynamodb.AttributeValue) {
+		item["visited"] = &dynamodb.AttributeValue{BOOL: aws.Bool(true)}
+	}
+	fake.items[0][dg.Config.GeoHashAttributeName] = geoHash
+	if _, err := dg.MovePoint(MovePointInput{PointInput: input, NewGeoPoint: nearby}); err != nil {
+		t.Fatal(err)
+	}
+	check(nearby)
+}
+
+func TestMovePointUserAttributesFakeClient(t *testing.T) {
+	input := PointInput{RangeKeyValue: RangeKeyFromString("new york"), GeoPoint: GeoPoint{Latitude: 40.7128, Longitude: -74.006}}
+	nearby := GeoPoint{Latitude: 40.7129, Longitude: -74.0061}
+	tokyo := GeoPoint{Latitude: 35.6762, Longitude: 139.6503}
+
+	tests := []struct {
+		name       string
+		config     DynGeoConfig
+		attributes map[string]*dynamodb.AttributeValue
+	}{
+		{
+			name: "lat and lng numbers",
+			attributes: map[string]*dynamodb.AttributeValue{
+				"lat": {N: aws.String("1")},
+				"lng": {N: aws.String("2")},
+			},
+		},
+		{
+			name: "lat and lng strings",
+			attributes: map[string]*dynamodb.AttributeValue{
+				"lat": {S: aws.String("40.7128")},
+				"lng": {S: aws.String("-74.006")},
+			},
+		},
+		{
+			name:   "geoJson string",
+			config: DynGeoConfig{StorageFormat: LatLngStorage},
+			attributes: map[string]*dynamodb.AttributeValue{
+				"geoJson": {S: aws.String(`{"type":"LineString"}`)},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, p := range []GeoPoint{nearby, tokyo} {
+				dg, fake := newFakeDynGeo(t, tt.config)
+				item := map[string]*dynamodb.AttributeValue{}
+				for name, value := range tt.attributes {
+					item[name] = value
+				}
+				if _, err := dg.PutPoint(PutPointInput{PointInput: input, PutItemInput: dynamodb.PutItemInput{Item: item}}); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := dg.MovePoint(MovePointInput{PointInput: input, NewGeoPoint: p}); err != nil {
+					t.Fatal(err)
+				}
+
+				for name, value := range tt.attributes {
+					if !reflect.DeepEqual(fake.items[0][name], value) {
+						t.Errorf("moving to %v changed user attribute %s to %v", p, name, fake.items[0][name])
+					}
+				}
+				lat, lng, err := dg.db.codec.Degrees(fake.items[0])
+				if err != nil || lat != p.Latitude || lng != p.Longitude {
+					t.Errorf("got %v, %v, error %v after moving to %v", lat, lng, err, p)
+				}
+			}
+		})
+	}
+}
+
+func TestMovePointStaleLocationFakeClient(t *testing.T) {
+	input := PointInput{RangeKeyValue: RangeKeyFromString("new york"), GeoPoint: GeoPoint{Latitude: 40.7128, Longitude: -74.006}}
+	nearby := GeoPoint{Latitude: 40.7129, Longitude: -74.0061}
+	tokyo := GeoPoint{Latitude: 35.6762, Longitude: 139.6503}
+
+	tests := []struct {
+		name   string
+		legacy StorageFormat
+		format StorageFormat
+		stale  []string
+	}{
+		{name: "GeoJSON to lat/lng", legacy: GeoJSONStringStorage, format: LatLngStorage, stale: []string{"geoJson"}},
+		{name: "lat/lng to GeoJSON", legacy: LatLngStorage, format: GeoJSONMapStorage, stale: []string{"lat", "lng"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			legacy, fake := newFakeDynGeo(t, DynGeoConfig{StorageFormat: tt.legacy})
+			dg, err := New(DynGeoConfig{TableName: "test", DynamoDBClient: fake, StorageFormat: tt.format})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, p := range []GeoPoint{nearby, tokyo} {
+				fake.items = nil
+				if _, err := legacy.PutPoint(PutPointInput{PointInput: input}); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := dg.MovePoint(MovePointInput{PointInput: input, NewGeoPoint: p}); err != nil {
+					t.Fatal(err)
+				}
+
+				item := fake.items[0]
+				for _, name := range tt.stale {
+					if _, ok := item[name]; ok {
+						t.Errorf("moving to %v kept %s of the old location", p, name)
+					}
+				}
+				lat, lng, err := legacy.db.codec.Degrees(item)
+				if err != nil || lat != p.Latitude || lng != p.Longitude {
+					t.Errorf("got %v, %v, error %v after moving to %v", lat, lng, err, p)
+				}
+			}
+		})
+	}
+}
+
+func TestTransactWritePointsFakeClient(t *testing.T) {
+	driver := PointInput{RangeKeyValue: RangeKeyFromString("driver"), GeoPoint: GeoPoint{Latitude: 52.52, Longitude: 13.405}}
+	pickup := PointInput{RangeKeyValue: RangeKeyFromString("pickup"), GeoPoint: GeoPoint{Latitude: 52.5, Longitude: 13.4}}
+	dropOff := PointInput{RangeKeyValue: RangeKeyFromString("drop-off"), GeoPoint: GeoPoint{Latitude: 48.137, Longitude: 11.575}}
+	old := PointInput{RangeKeyValue: RangeKeyFromString("old"), GeoPoint: GeoPoint{Latitude: 50.11, Longitude: 8.682}}
+	inputs := []TransactWritePointInput{
+		{ConditionCheck: &ConditionCheckPointInput{
+			PointInput: driver,
+			ConditionCheck: dynamodb.ConditionCheck{
+				ConditionExpression: aws.String("attribute_exists(rangeKey)"),
+			},
+		}},
+		{Put: &PutPointInput{PointInput: pickup}},
+		{Put: &PutPointInput{PointInput: dropOff}},
+		{Delete: &DeletePointInput{PointInput: old}},
+	}
+
+	tests := []struct {
+		name       string
+		failing    []PointInput
+		wantFailed []int
+	}{
+		{name: "applied"},
+		{name: "condition check", failing: []PointInput{driver}, wantFailed: []int{0}},
+		{name: "put", failing: []PointInput{dropOff}, wantFailed: []int{2}},
+		{name: "put and delete", failing: []PointInput{old, pickup}, wantFailed: []int{1, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dg, fake := newFakeDynGeo(t, DynGeoConfig{})
+			if _, err := dg.PutPoint(PutPointInput{PointInput: old}); err != nil {
+				t.Fatal(err)
+			}
+			for _, p := range tt.failing {
+				fake.failRangeKeys[p.RangeKeyValue.String()] = true
+			}
+
+			_, err := dg.TransactWritePoints(inputs)
+			if tt.wantFailed == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(fake.items) != 2 || fake.find(dg.db.codec.Key(pickup)) < 0 || fake.find(dg.db.codec.Key(dropOff)) < 0 {
+					t.Errorf("got items %v, want pickup and drop-off", fake.items)
+				}
+				return
+			}
+
+			var transactErr *TransactWriteError
+			if !errors.As(err, &transactErr) {
+				t.Fatalf("got error %v, want a *TransactWriteError", err)
+			}
+			var canceled *dynamodb.TransactionCanceledException
+			if !errors.As(err, &canceled) {
+				t.Errorf("got error %v, want it to wrap the TransactionCanceledException", err)
+			}
+			// the reasons are mapped back to the inputs causing them
+			failed := []int{}
+			for _, f := range transactErr.Failures {
+				failed = append(failed, f.Index)
+				if !reflect.DeepEqual(f.Input, inputs[f.Index]) || f.Code != "ConditionalCheckFailed" {
+					t.Errorf("failure %d: got input %+v and code %s", f.Index, f.Input, f.Code)
+				}
+			}
+			if !reflect.DeepEqual(failed, tt.wantFailed) {
+				t.Errorf("got failed operations %v, want %v", failed, tt.wantFailed)
+			}
+			if len(fake.items) != 1 || fake.find(dg.db.codec.Key(old)) < 0 {
+				t.Errorf("got items %v, want none of the operations applied", fake.items)
+			}
+		})
+	}
+
+	dg, _ := newFakeDynGeo(t, DynGeoConfig{})
+	if _, err := dg.TransactWritePoints([]TransactWritePointInput{{}}); err == nil {
+		t.Error("got no error for an operation without Put, Update, Delete or ConditionCheck")
+	}
+}
+
+func TestTransactWritePointsLegacyFakeClient(t *testing.T) {
+	point := PointInput{RangeKeyValue: RangeKeyFromString("point"), GeoPoint: GeoPoint{Latitude: 52.52, Longitude: 13.405}}
+	expected := map[string]*dynamodb.ExpectedAttributeValue{"name": {Exists: aws.Bool(true)}}
+
+	tests := []struct {
+		name       string
+		input      TransactWritePointInput
+		wantUpdate string
+		wantNames  map[string]string
+		wantErr    bool
+	}{
+		{
+			name: "attribute updates",
+			input: TransactWritePointInput{Update: &UpdatePointInput{
+				PointInput: point,
+				UpdateItemInput: dynamodb.UpdateItemInput{
+					AttributeUpdates: map[string]*dynamodb.AttributeValueUpdate{
+						"name":    {Action: aws.String(dynamodb.AttributeActionPut), Value: &dynamodb.AttributeValue{S: aws.String("Berlin")}},
+						"visits":  {Action: aws.String(dynamodb.AttributeActionAdd), Value: &dynamodb.AttributeValue{N: aws.String("1")}},
+						"old":     {Action: aws.String(dynamodb.AttributeActionDelete)},
+						"geohash": {Action: aws.String(dynamodb.AttributeActionPut), Value: &dynamodb.AttributeValue{N: aws.String("1")}},
+						"geoJson": {Action: aws.String(dynamodb.AttributeActionDelete)},
+					},
+				},
+			}},
+			wantUpdate: "SET #update0 = :update0 REMOVE #update1 ADD #update2 :update2",
+			wantNames:  map[string]string{"#update0": "name", "#update1": "old", "#update2": "visits"},
+		},
+		{
+			name: "only geo attribute updates",
+			input: TransactWritePointInput{Update: &UpdatePointInput{
+				PointInput: point,
+				UpdateItemInput: dynamodb.UpdateItemInput{
+					AttributeUpdates: map[string]*dynamodb.AttributeValueUpdate{
+						"geohash": {Action: aws.String(dynamodb.AttributeActionPut), Value: &dynamodb.AttributeValue{N: aws.String("1")}},
+					},
+				},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "attribute updates and update expression",
+			input: TransactWritePointInput{Update: &UpdatePointInput{
+				PointInput: point,
+				UpdateItemInput: dynamodb.UpdateItemInput{
+					UpdateExpression: aws.String("REMOVE old"),
+					AttributeUpdates: map[string]*dynamodb.AttributeValueUpdate{
+						"name": {Value: &dynamodb.AttributeValue{S: aws.String("Berlin")}},
+					},
+				},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "update expected",
+			input: TransactWritePointInput{Update: &UpdatePointInput{
+				PointInput:      point,
+				UpdateItemInput: dynamodb.UpdateItemInput{UpdateExpression: aws.String("REMOVE old"), Expected: expected},
+			}},
+			wantErr: true,
+		},
+		{
+			name:    "put expected",
+			input:   TransactWritePointInput{Put: &PutPointInput{PointInput: point, PutItemInput: dynamodb.PutItemInput{Expected: expected}}},
+			wantErr: true,
+		},
+		{
+			name:    "delete conditional operator",
+			input:   TransactWritePointInput{Delete: &DeletePointInput{PointInput: point, DeleteItemInput: dynamodb.DeleteItemInput{ConditionalOperator: aws.String("AND")}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dg, fake := newFakeDynGeo(t, DynGeoConfig{})
+
+			_, err := dg.TransactWritePoints([]TransactWritePointInput{tt.input})
+			if tt.wantErr {
+				if err == nil || fake.transacted != nil {
+					t.Errorf("got error %v, want the input rejected", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			update := fake.transacted.TransactItems[0].Update
+			if aws.StringValue(update.UpdateExpression) != tt.wantUpdate {
+				t.Errorf("got update expression %q, want %q", aws.StringValue(update.UpdateExpression), tt.wantUpdate)
+			}
+			names := map[string]string{}
+			for k, name := range update.ExpressionAttributeNames {
+				names[k] = aws.StringValue(name)
+			}
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("got names %v, want %v", names, tt.wantNames)
+			}
+			if len(update.ExpressionAttributeValues) != 2 {
+				t.Errorf("got values %v, want the name and visits", update.ExpressionAttributeValues)
+			}
+		})
+	}
+}
+func TestNewCellLevels(t *testing.T) {
+	tests := []struct {
+		config           DynGeoConfig
+		wantMin, wantMax int
+		wantErr          bool
+	}{
+		{config: DynGeoConfig{}, wantMin: 10, wantMax: 10},
+		{config: DynGeoConfig{MinCellLevel: 0, MaxCellLevel: 12}, wantMin: 0, wantMax: 12},
+		{config: DynGeoConfig{MaxCellLevel: 5}, wantMin: 0, wantMax: 5},
+		{config: DynGeoConfig{MinCellLevel: 12}, wantMin: 12, wantMax: 12},
+		{config: DynGeoConfig{MinCellLevel: -1}, wantErr: true},
+		{config: DynGeoConfig{MaxCells: -1}, wantErr: true},
+	}
+	for _, test := range tests {
+		test.config.TableName = "test"
+		test.config.DynamoDBClient = &fakeDynamoDB{}
+		dg, err := New(test.config)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%+v: got no error", test.config)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dg.Config.MinCellLevel != test.wantMin || dg.Config.MaxCellLevel != test.wantMax ||
+			dg.Config.s2RegionCoverer.MinLevel != test.wantMin || dg.Config.s2RegionCoverer.MaxLevel != test.wantMax {
+			t.Errorf("got levels %d to %d, want %d to %d", dg.Config.MinCellLevel, dg.Config.MaxCellLevel, test.wantMin, test.wantMax)
+		}
+	}
+
+	// hash keys of level 0 are the cube faces
+	dg, _ := newFakeDynGeo(t, DynGeoConfig{HashKeyScheme: ParentCellHashKey, HashKeyCellLevel: aws.Int(0)})
+	if _, hashKey := dg.db.codec.Hasher.Hashes(s2.LatLngFromDegrees(40.7, -74)); s2.CellID(hashKey).Level() != 0 {
+		t.Errorf("got hash key of level %d, want 0", s2.CellID(hashKey).Level())
+	}
+	if _, err := New(DynGeoConfig{TableName: "test", DynamoDBClient: &fakeDynamoDB{}, HashKeyScheme: ParentCellHashKey, HashKeyCellLevel: aws.Int(-1)}); err == nil || !strings.Contains(err.Error(), "HashKeyCellLevel") {
+		t.Errorf("got error %v, want HashKeyCellLevel rejected", err)
+	}
+	if _, err := New(DynGeoConfig{TableName: "test", DynamoDBClient: &fakeDynamoDB{}, HashKeyScheme: ParentCellHashKey}); err == nil || !strings.Contains(err.Error(), "HashKeyCellLevel") {
+		t.Errorf("got error %v, want an unset HashKeyCellLevel rejected", err)
+	}
+}
+
+func TestNumberRangeKeyFakeClient(t *testing.T) {
+	dg, fake := newFakeDynGeo(t, DynGeoConfig{RangeKeyType: NumberRangeKey})
+
+	for _, attr := range GetCreateTableRequest(dg.Config).AttributeDefinitions {
+		if *attr.AttributeName == dg.Config.RangeKeyAttributeName && *attr.AttributeType != "N" {
+			t.Errorf("got range key type %s, want N", *attr.AttributeType)
+		}
+	}
+
+	input := PointInput{RangeKeyValue: RangeKeyFromInt(1700000000), GeoPoint: GeoPoint{Latitude: 40.7128, Longitude: -74.006}}
+	if _, err := dg.PutPoint(PutPointInput{PointInput: input}); err != nil {
+		t.Fatal(err)
+	}
+	if rangeKey := fake.items[0][dg.Config.RangeKeyAttributeName]; rangeKey.S != nil || aws.StringValue(rangeKey.N) != "1700000000" {
+		t.Errorf("got range key %v, want the number 1700000000", rangeKey)
+	}
+
+	items, _, err := dg.db.batchGetPoints(aws.BackgroundContext(), []PointInput{input})
+	if err != nil || len(items) != 1 {
+		t.Errorf("got %d items and error %v, want the point", len(items), err)
+	}
+}
+
+func TestValidationFakeClient(t *testing.T) {
+	dg, fake := newFakeDynGeo(t, DynGeoConfig{})
+	center := GeoPoint{Latitude: 40.7769099, Longitude: -73.9822532}
+	out := &[]map[string]interface{}{}
+	point := func(p GeoPoint) PointInput {
+		return PointInput{RangeKeyValue: RangeKeyFromString("point"), GeoPoint: p}
+	}
+
+	// every entry point taking a location, called with p in its place
+	entryPoints := map[string]func(p GeoPoint) error{
+		"PutPoint": func(p GeoPoint) error {
+			_, err := dg.PutPoint(PutPointInput{PointInput: point(p)})
+			return err
+		},
+		"GetPoint": func(p GeoPoint) error {
+			_, err := dg.GetPoint(GetPointInput{PointInput: point(p)})
+			return err
+		},
+		"UpdatePoint": func(p GeoPoint) error {
+			_, err := dg.UpdatePoint(UpdatePointInput{PointInput: point(p)})
+			return err
+		},
+		"DeletePoint": func(p GeoPoint) error {
+			_, err := dg.DeletePoint(DeletePointInput{PointInput: point(p)})
+			return err
+		},
+		"BatchWritePoints": func(p GeoPoint) error {
+			_, err := dg.BatchWritePoints([]PutPointInput{{PointInput: point(center)}, {PointInput: point(p)}})
+			return err
+		},
+		"BatchGetPoints": func(p GeoPoint) error {
+			_, err := dg.BatchGetPoints([]PointInput{point(p)}, out)
+			return err
+		},
+		"BatchDeletePoints": func(p GeoPoint) error {
+			_, err := dg.BatchDeletePoints([]PointInput{point(p)})
+			return err
+		},
+		"TransactWritePoints": func(p GeoPoint) error {
+			_, err := dg.TransactWritePoints([]TransactWritePointInput{{Put: &PutPointInput{PointInput: point(p)}}})
+			return err
+		},
+		"MovePoint from": func(p GeoPoint) error {
+			_, err := dg.MovePoint(MovePointInput{PointInput: point(p), NewGeoPoint: center})
+			return err
+		},
+		"MovePoint to": func(p GeoPoint) error {
+			_, err := dg.MovePoint(MovePointInput{PointInput: point(center), NewGeoPoint: p})
+			return err
+		},
+		"QueryRadius": func(p GeoPoint) error {
+			return dg.QueryRadius(QueryRadiusInput{CenterPoint: p, RadiusInMeter: 100}, out)
+		},
+		"QueryRadiusPage": func(p GeoPoint) error {
+			_, err := dg.QueryRadiusPage(QueryRadiusInput{CenterPoint: p, RadiusInMeter: 100}, out)
+			return err
+		},
+		"QueryRadiusIter": func(p GeoPoint) error {
+			return dg.QueryRadiusIter(QueryRadiusInput{CenterPoint: p, RadiusInMeter: 100}).Err()
+		},
+		"CountRadius": func(p GeoPoint) error {
+			_, err := dg.CountRadius(QueryRadiusInput{CenterPoint: p, RadiusInMeter: 100})
+			return err
+		},
+		"QueryRectangle": func(p GeoPoint) error {
+			return dg.QueryRectangle(QueryRectangleInput{MinPoint: &p, MaxPoint: &center}, out)
+		},
+		"QueryRectanglePage": func(p GeoPoint) error {
+			_, err := dg.QueryRectanglePage(QueryRectangleInput{MinPoint: &center, MaxPoint: &p}, out)
+			return err
+		},
+		"QueryRectangleIter": func(p GeoPoint) error {
+			return dg.QueryRectangleIter(QueryRectangleInput{MinPoint: &p, MaxPoint: &center}).Err()
+		},
+		"CountRectangle": func(p GeoPoint) error {
+			_, err := dg.CountRectangle(QueryRectangleInput{MinPoint: &center, MaxPoint: &p})
+			return err
+		},
+		"QuerySector": func(p GeoPoint) error {
+			return dg.QuerySector(QuerySectorInput{CenterPoint: p, RadiusInMeter: 100, WidthInDegree: 90}, out)
+		},
+		"QueryCorridor": func(p GeoPoint) error {
+			_, err := dg.QueryCorridor(QueryCorridorInput{Polyline: []GeoPoint{center, p}}, out)
+			return err
+		},
+		"QueryNearest": func(p GeoPoint) error {
+			return dg.QueryNearest(QueryNearestInput{CenterPoint: p, K: 1}, out)
+		},
+	}
+	invalid := map[string]GeoPoint{
+		"NaN latitude":         {Latitude: math.NaN()},
+		"NaN longitude":        {Longitude: math.NaN()},
+		"infinite latitude":    {Latitude: math.Inf(1)},
+		"latitude above 90":    {Latitude: 91},
+		"latitude below -90":   {Latitude: -90.5},
+		"longitude above 180":  {Longitude: 181},
+		"longitude below -180": {Longitude: -180.5},
+		"infinite longitude":   {Longitude: math.Inf(-1)},
+	}
+	for name, entryPoint := range entryPoints {
+		for invalidName, p := range invalid {
+			if err := entryPoint(p); !errors.Is(err, ErrInvalidCoordinate) {
+				t.Errorf("%s with %s: got error %v, want ErrInvalidCoordinate", name, invalidName, err)
+			}
+		}
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{
+			name: "polygon with latitude out of range",
+			err:  dg.QueryPolygon(QueryPolygonInput{GeoJSON: []byte(`{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,95],[0,0]]]}`)}, out),
+			want: ErrInvalidCoordinate,
+		},
+		{
+			name: "count polygon with longitude out of range",
+			err: func() error {
+				_, err := dg.CountPolygon(QueryPolygonInput{GeoJSON: []byte(`{"type":"Polygon","coordinates":[[[0,0],[181,0],[1,1],[0,0]]]}`)})
+				return err
+			}(),
+			want: ErrInvalidCoordinate,
+		},
+		{
+			name: "empty range key",
+			err: func() error {
+				_, err := dg.PutPoint(PutPointInput{PointInput: PointInput{GeoPoint: center}})
+				return err
+			}(),
+			want: ErrInvalidRangeKey,
+		},
+		{
+			name: "number range key in a string table",
+			err: func() error {
+				_, err := dg.GetPoint(GetPointInput{PointInput: PointInput{RangeKeyValue: RangeKeyFromInt(1), GeoPoint: center}})
+				return err
+			}(),
+			want: ErrInvalidRangeKey,
+		},
+		{
+			name: "empty range key in a batch",
+			err: func() error {
+				_, err := dg.BatchWritePoints([]PutPointInput{{PointInput: point(center)}, {PointInput: PointInput{GeoPoint: center}}})
+				return err
+			}(),
+			want: ErrInvalidRangeKey,
+		},
+		{
+			name: "sector with NaN heading",
+			err:  dg.QuerySector(QuerySectorInput{CenterPoint: center, RadiusInMeter: 100, HeadingInDegree: math.NaN(), WidthInDegree: 90}, out),
+			want: ErrInvalidShape,
+		},
+		{
+			name: "sector with infinite heading",
+			err:  dg.QuerySector(QuerySectorInput{CenterPoint: center, RadiusInMeter: 100, HeadingInDegree: math.Inf(-1), WidthInDegree: 90}, out),
+			want: ErrInvalidShape,
+		},
+		{
+			name: "sector with NaN width",
+			err:  dg.QuerySector(QuerySectorInput{CenterPoint: center, RadiusInMeter: 100, WidthInDegree: math.NaN()}, out),
+			want: ErrInvalidShape,
+		},
+		{
+			name: "sector with infinite width",
+			err:  dg.QuerySector(QuerySectorInput{CenterPoint: center, RadiusInMeter: 100, WidthInDegree: math.Inf(1)}, out),
+			want: ErrInvalidShape,
+		},
+		{
+			name: "sector without width",
+			err:  dg.QuerySector(QuerySectorInput{CenterPoint: center, RadiusInMeter: 100}, out),
+			want: ErrInvalidShape,
+		},
+		{
+			name: "polygon with two vertices",
+			err: dg.QueryPolygon(QueryPolygonInput{Polygon: s2.PolygonFromLoops([]*s2.Loop{s2.LoopFromPoints([]s2.Point{
+				s2.PointFromLatLng(center.LatLng()),
+				s2.PointFromLatLng(s2.LatLngFromDegrees(40.8, -73.9)),
+			})})}, out),
+			want: ErrInvalidShape,
+		},
+		{
+			name: "count polygon with a vertex off the sphere",
+			err: func() error {
+				_, err := dg.CountPolygon(QueryPolygonInput{Polygon: s2.PolygonFromLoops([]*s2.Loop{s2.LoopFromPoints([]s2.Point{
+					s2.PointFromLatLng(center.LatLng()),
+					s2.PointFromLatLng(s2.LatLngFromDegrees(40.8, -73.9)),
+					{Vector: r3.Vector{X: 2, Y: 2, Z: 2}},
+				})})})
+				return err
+			}(),
+			want: ErrInvalidShape,
+		},
+		{
+			name: "rectangle without MaxPoint",
+			err:  dg.QueryRectangle(QueryRectangleInput{MinPoint: &center}, out),
+			want: ErrMissingBounds,
+		},
+		{
+			name: "rectangle page without MinPoint",
+			err: func() error {
+				_, err := dg.QueryRectanglePage(QueryRectangleInput{MaxPoint: &center}, out)
+				return err
+			}(),
+			want: ErrMissingBounds,
+		},
+		{
+			name: "rectangle iterator without MinPoint",
+			err:  dg.QueryRectangleIter(QueryRectangleInput{MaxPoint: &center}).Err(),
+			want: ErrMissingBounds,
+		},
+		{
+			name: "count rectangle without bounds",
+			err: func() error {
+				_, err := dg.CountRectangle(QueryRectangleInput{})
+				return err
+			}(),
+			want: ErrMissingBounds,
+		},
+		{
+			name: "polygon without shape",
+			err:  dg.QueryPolygon(QueryPolygonInput{}, out),
+			want: ErrMissingBounds,
+		},
+		{
+			name: "count polygon without shape",
+			err: func() error {
+				_, err := dg.CountPolygon(QueryPolygonInput{})
+				return err
+			}(),
+			want: ErrMissingBounds,
+		},
+		{
+			name: "corridor with a single point",
+			err: func() error {
+				_, err := dg.QueryCorridor(QueryCorridorInput{Polyline: []GeoPoint{center}}, out)
+				return err
+			}(),
+			want: ErrMissingBounds,
+		},
+		{
+			name: "corridor without points",
+			err: func() error {
+				_, err := dg.QueryCorridor(QueryCorridorInput{}, out)
+				return err
+			}(),
+			want: ErrMissingBounds,
+		},
+		{
+			name: "radius 0",
+			err:  dg.QueryRadius(QueryRadiusInput{CenterPoint: center}, out),
+			want: ErrInvalidRadius,
+		},
+		{
+			name: "minimum radius beyond radius",
+			err:  dg.QueryRadius(QueryRadiusInput{CenterPoint: center, RadiusInMeter: 100, MinRadiusInMeter: 200}, out),
+			want: ErrInvalidRadius,
+		},
+		{
+			name: "count with negative radius",
+			err: func() error {
+				_, err := dg.CountRadius(QueryRadiusInput{CenterPoint: center, RadiusInMeter: -1})
+				return err
+			}(),
+			want: ErrInvalidRadius,
+		},
+		{
+			name: "radius with a limit",
+			err:  dg.QueryRadius(QueryRadiusInput{CenterPoint: center, RadiusInMeter: 100, PageInput: PageInput{Limit: 10}}, out),
+			want: ErrPageInput,
+		},
+		{
+			name: "rectangle iterator with a continuation token",
+			err:  dg.QueryRectangleIter(QueryRectangleInput{MinPoint: &center, MaxPoint: &center, PageInput: PageInput{ContinuationToken: "token"}}).Err(),
+			want: ErrPageInput,
+		},
+		{
+			name: "count with a limit",
+			err: func() error {
+				_, err := dg.CountRectangle(QueryRectangleInput{MinPoint: &center, MaxPoint: &center, PageInput: PageInput{Limit: 10}})
+				return err
+			}(),
+			want: ErrPageInput,
+		},
+	}
+	for _, tt := range tests {
+		if !errors.Is(tt.err, tt.want) {
+			t.Errorf("%s: got error %v, want %v", tt.name, tt.err, tt.want)
+		}
+	}
+	if len(fake.items) != 0 || fake.itemQueries+fake.countQueries != 0 {
+		t.Errorf("got %d items and %d queries, want nothing sent", len(fake.items), fake.itemQueries+fake.countQueries)
+	}
+
+	dg, fake = newFakeDynGeo(t, DynGeoConfig{NormalizeLongitude: true})
+	if _, err := dg.PutPoint(PutPointInput{PointInput: point(GeoPoint{Latitude: 10, Longitude: 190})}); err != nil {
+		t.Fatal(err)
+	}
+	latLng, err := dg.db.codec.LatLng(fake.items[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lng := latLng.Lng.Degrees(); math.Abs(lng+170) > 1e-9 {
+		t.Errorf("got longitude %v, want -170", lng)
+	}
+}
+
+func TestStorageFormatsFakeClient(t *testing.T) {
+	center := GeoPoint{Latitude: 40.7769099, Longitude: -73.9822532}
+	points := map[string]GeoPoint{
+		"central park": {Latitude: 40.7812, Longitude: -73.9665},
+		"times square": {Latitude: 40.7580, Longitude: -73.9855},
+		"philadelphia": {Latitude: 39.9526, Longitude: -75.1652},
+	}
+	// philadelphia is written in the legacy GeoJSON string format, which
+	// every format reads
+	const legacyName = "philadelphia"
+
+	tests := []struct {
+		name   string
+		format StorageFormat
+	}{
+		{name: "GeoJSON string", format: GeoJSONStringStorage},
+		{name: "GeoJSON map", format: GeoJSONMapStorage},
+		{name: "lat/lng", format: LatLngStorage},
+		{name: "GeoJSON map and lat/lng", format: GeoJSONMapStorage | LatLngStorage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			legacy, fake := newFakeDynGeo(t, DynGeoConfig{})
+			dg, err := New(DynGeoConfig{TableName: "test", DynamoDBClient: fake, StorageFormat: tt.format})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for name, p := range points {
+				input := PutPointInput{PointInput: PointInput{RangeKeyValue: RangeKeyFromString(name), GeoPoint: p}}
+				input.PutItemInput.Item = map[string]*dynamodb.AttributeValue{"name": {S: aws.String(name)}}
+				writer := dg
+				if name == legacyName {
+					writer = legacy
+				}
+				if _, err := writer.PutPoint(input); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// checkFormat checks the location attributes of the item
+			checkFormat := func(item map[string]*dynamodb.AttributeValue, format StorageFormat) {
+				t.Helper()
+				name := *item["name"].S
+				geoJSON := item[dg.Config.GeoJSONAttributeName]
+				if got := geoJSON != nil && geoJSON.S != nil; got != format.GeoJSONString() {
+					t.Errorf("%s: got GeoJSON string %v, want %v", name, got, format.GeoJSONString())
+				}
+				if got := geoJSON != nil && geoJSON.M != nil; got != format.GeoJSONMap() {
+					t.Errorf("%s: got GeoJSON map %v, want %v", name, got, format.GeoJSONMap())
+				}
+				if got := item[dg.Config.LatitudeAttributeName] != nil && item[dg.Config.LongitudeAttributeName] != nil; got != format.LatLng() {
+					t.Errorf("%s: got lat/lng attributes %v, want %v", name, got, format.LatLng())
+				}
+			}
+			for _, item := range fake.items {
+				name := *item["name"].S
+				format := tt.format
+				if name == legacyName {
+					format = GeoJSONStringStorage
+				}
+				checkFormat(item, format)
+
+				lat, lng, err := dg.db.codec.Degrees(item)
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				if p := points[name]; lat != p.Latitude || lng != p.Longitude {
+					t.Errorf("%s: got %v, %v, want %v", name, lat, lng, p)
+				}
+			}
+
+			items, err := queryRadius(dg, QueryRadiusInput{CenterPoint: center, RadiusInMeter: 200000})
+			if err != nil {
+				t.Fatal(err)
+			}
+			found := []string{}
+			for _, item := range items {
+				found = append(found, *item["name"].S)
+			}
+			sort.Strings(found)
+			if want := []string{"central park", legacyName, "times square"}; !reflect.DeepEqual(found, want) {
+				t.Errorf("found %v, want %v", found, want)
+			}
+
+			// moving a point writes it in the configured format
+			for _, name := range []string{"times square", legacyName} {
+				from := points[name]
+				to := GeoPoint{Latitude: from.Latitude + 0.0001, Longitude: from.Longitude - 0.0001}
+				if _, err := dg.MovePoint(MovePointInput{PointInput: PointInput{RangeKeyValue: RangeKeyFromString(name), GeoPoint: from}, NewGeoPoint: to}); err != nil {
+					t.Fatal(err)
+				}
+				item := fake.items[fake.find(dg.db.codec.Key(PointInput{RangeKeyValue: RangeKeyFromString(name), GeoPoint: to}))]
+				checkFormat(item, tt.format)
+				lat, lng, err := dg.db.codec.Degrees(item)
+				if err != nil || lat != to.Latitude || lng != to.Longitude {
+					t.Errorf("%s: got %v, %v, error %v after moving to %v", name, lat, lng, err, to)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package dyngeov2
+
+import (
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/crolly/dyngeo/internal/geo"
+)
+
+// attributes adapts types.AttributeValue to geo.Attributes.
+type attributes struct{}
+
+var _ geo.Attributes[types.AttributeValue] = attributes{}
+
+func (attributes) String(v types.AttributeValue) (string, bool) {
+	s, ok := v.(*types.AttributeValueMemberS)
+	if !ok {
+		return "", false
+	}
+
+	return s.Value, true
+}
+
+func (attributes) Number(v types.AttributeValue) (string, bool) {
+	n, ok := v.(*types.AttributeValueMemberN)
+	if !ok {
+		return "", false
+	}
+
+	return n.Value, true
+}
+
+func (attributes) Binary(v types.AttributeValue) ([]byte, bool) {
+	b, ok := v.(*types.AttributeValueMemberB)
+	if !ok {
+		return nil, false
+	}
+
+	return b.Value, true
+}
+
+func (attributes) Map(v types.AttributeValue) (map[string]types.AttributeValue, bool) {
+	m, ok := v.(*types.AttributeValueMemberM)
+	if !ok {
+		return nil, false
+	}
+
+	return m.Value, true
+}
+
+func (attributes) List(v types.AttributeValue) ([]types.AttributeValue, bool) {
+	l, ok := v.(*types.AttributeValueMemberL)
+	if !ok {
+		return nil, false
+	}
+
+	return l.Value, true
+}
+
+func (attributes) NewString(s string) types.AttributeValue {
+	return &types.AttributeValueMemberS{Value: s}
+}
+
+func (attributes) NewNumber(n string) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: n}
+}
+
+func (attributes) NewBinary(b []byte) types.AttributeValue {
+	return &types.AttributeValueMemberB{Value: b}
+}
+
+func (attributes) NewMap(m map[string]types.AttributeValue) types.AttributeValue {
+	return &types.AttributeValueMemberM{Value: m}
+}
+
+func (attributes) NewList(l []types.AttributeValue) types.AttributeValue {
+	return &types.AttributeValueMemberL{Value: l}
+}
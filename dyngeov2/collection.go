@@ -0,0 +1,328 @@
+package dyngeov2
+
+import (
+	"context"
+	"errors"
+	"reflect"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/crolly/dyngeo/internal/geo"
+)
+
+// Collection stores values of the struct type T as points. The fields tagged
+// with `dyngeo:"lat"`, `dyngeo:"lng"` and `dyngeo:"id"` hold the location and
+// the range key of a point, all other fields are marshalled with
+// attributevalue. On results, the geo fields are filled from the stored
+// point, and a float field tagged with `dyngeo:"distance"` with the distance
+// in meters from the query center.
+//
+//	type Store struct {
+//		ID       string  `dyngeo:"id" dynamodbav:"-"`
+//		Lat      float64 `dyngeo:"lat"`
+//		Lng      float64 `dyngeo:"lng"`
+//		Distance float64 `dyngeo:"distance" dynamodbav:"distance"`
+//		Name     string  `dynamodbav:"name"`
+//	}
+type Collection[T any] struct {
+	dg     *DynGeo
+	fields geo.PointFields
+}
+
+// NewCollection returns the Collection of T stored by dg. T has to be a struct
+// with fields tagged lat, lng and id.
+func NewCollection[T any](dg *DynGeo) (*Collection[T], error) {
+	fields, err := geo.NewPointFields(reflect.TypeOf((*T)(nil)).Elem())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Collection[T]{dg: dg, fields: fields}, nil
+}
+
+// pointInput returns the range key and location of the item.
+func (c *Collection[T]) pointInput(item T) PointInput {
+	v := reflect.ValueOf(item)
+	lat, lng := c.fields.LatLng(v)
+
+	return PointInput{
+		RangeKeyValue: c.fields.RangeKey(v),
+		GeoPoint:      GeoPoint{Latitude: lat, Longitude: lng},
+	}
+}
+
+// marshal returns the attributes of the item, without its distance.
+func (c *Collection[T]) marshal(item T) (map[string]types.AttributeValue, error) {
+	av, err := attributevalue.MarshalMap(item)
+	if err != nil {
+		return nil, err
+	}
+	if c.fields.DistanceAttributeName != "" {
+		delete(av, c.fields.DistanceAttributeName)
+	}
+
+	return av, nil
+}
+
+// unmarshal unmarshals the item and fills the geo fields from its location
+// and range key and, if distanceAttributeName is set, its distance.
+func (c *Collection[T]) unmarshal(item map[string]types.AttributeValue, distanceAttributeName string) (T, error) {
+	var out T
+	if err := attributevalue.UnmarshalMap(item, &out); err != nil {
+		return out, err
+	}
+	v := reflect.ValueOf(&out)
+
+	codec := c.dg.Config.codec()
+	lat, lng, err := codec.Degrees(item)
+	if err != nil {
+		return out, err
+	}
+	c.fields.SetLatLng(v, lat, lng)
+
+	if rangeKey, ok := codec.RangeKey(item); ok {
+		if err := c.fields.SetRangeKey(v, rangeKey); err != nil {
+			return out, err
+		}
+	}
+
+	if distanceAttributeName != "" {
+		d, ok, err := codec.Float(item, distanceAttributeName)
+		if err != nil {
+			return out, err
+		}
+		if ok {
+			c.fields.SetDistance(v, d)
+		}
+	}
+
+	return out, nil
+}
+
+// results receives the items of a query in place of attributevalue, see
+// DynGeo.unmarshallOutput.
+type results[T any] struct {
+	collection            *Collection[T]
+	distanceAttributeName string
+	items                 []T
+}
+
+func (r *results[T]) receive(items []map[string]types.AttributeValue) error {
+	r.items = make([]T, len(items))
+	for i, item := range items {
+		out, err := r.collection.unmarshal(item, r.distanceAttributeName)
+		if err != nil {
+			return err
+		}
+		r.items[i] = out
+	}
+
+	return nil
+}
+
+// CollectionIterator is a QueryIterator returning its items as T, with the
+// geo fields filled like for the other queries of a Collection.
+type CollectionIterator[T any] struct {
+	it      *QueryIterator
+	results *results[T]
+	item    T
+	err     error
+}
+
+// Next advances the iterator to the next item. It returns false once all
+// items have been read, an item could not be unmarshalled, an error occurred
+// or the iterator has been closed.
+func (it *CollectionIterator[T]) Next() bool {
+	if it.err != nil || !it.it.Next() {
+		return false
+	}
+
+	item, err := it.results.collection.unmarshal(it.it.Item(), it.results.distanceAttributeName)
+	if err != nil {
+		it.err = err
+		it.it.Close()
+		return false
+	}
+	it.item = item
+
+	return true
+}
+
+// Item returns the current item.
+func (it *CollectionIterator[T]) Item() T {
+	return it.item
+}
+
+// Err returns the error that stopped the iteration, if any, see
+// QueryIterator.Err.
+func (it *CollectionIterator[T]) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+
+	return it.it.Err()
+}
+
+// Close stops the iteration, see QueryIterator.Close.
+func (it *CollectionIterator[T]) Close() {
+	it.it.Close()
+}
+
+// results returns the receiver of the query results. If T has a distance
+// field, the distance is injected into the results.
+func (c *Collection[T]) results(input *GeoQueryInput) *results[T] {
+	if c.fields.DistanceAttributeName != "" && input.DistanceAttributeName == "" {
+		input.DistanceAttributeName = c.fields.DistanceAttributeName
+	}
+
+	return &results[T]{collection: c, distanceAttributeName: input.DistanceAttributeName}
+}
+
+// Put writes the item as a point.
+func (c *Collection[T]) Put(ctx context.Context, item T) error {
+	av, err := c.marshal(item)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.dg.PutPoint(ctx, PutPointInput{
+		PointInput:   c.pointInput(item),
+		PutItemInput: dynamodb.PutItemInput{Item: av},
+	})
+
+	return err
+}
+
+// Get reads the point with the location and id of key. It returns
+// ErrPointNotFound if there is none.
+func (c *Collection[T]) Get(ctx context.Context, key T) (T, error) {
+	var out T
+	output, err := c.dg.GetPoint(ctx, GetPointInput{PointInput: c.pointInput(key)})
+	if err != nil {
+		return out, err
+	}
+	if len(output.Item) == 0 {
+		return out, ErrPointNotFound
+	}
+
+	return c.unmarshal(output.Item, "")
+}
+
+// Update replaces the attributes of the existing point with the location and
+// id of the item. It returns ErrPointNotFound if there is none. Use MovePoint
+// to change the location.
+func (c *Collection[T]) Update(ctx context.Context, item T) error {
+	av, err := c.marshal(item)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.dg.PutPoint(ctx, PutPointInput{
+		PointInput: c.pointInput(item),
+		PutItemInput: dynamodb.PutItemInput{
+			Item:                av,
+			ConditionExpression: aws.String("attribute_exists(#rangeKey)"),
+			ExpressionAttributeNames: map[string]string{
+				"#rangeKey": c.dg.Config.RangeKeyAttributeName,
+			},
+		},
+	})
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		return ErrPointNotFound
+	}
+
+	return err
+}
+
+// Delete deletes the point with the location and id of key.
+func (c *Collection[T]) Delete(ctx context.Context, key T) error {
+	_, err := c.dg.DeletePoint(ctx, DeletePointInput{PointInput: c.pointInput(key)})
+
+	return err
+}
+
+// QueryRadius is like DynGeo.QueryRadius, but returns the results as T.
+func (c *Collection[T]) QueryRadius(ctx context.Context, input QueryRadiusInput) ([]T, error) {
+	out := c.results(&input.GeoQueryInput)
+	err := c.dg.QueryRadius(ctx, input, out)
+
+	return out.items, err
+}
+
+// QueryRadiusPage is like DynGeo.QueryRadiusPage, but returns the results as
+// T.
+func (c *Collection[T]) QueryRadiusPage(ctx context.Context, input QueryRadiusInput) ([]T, *QueryRadiusOutput, error) {
+	out := c.results(&input.GeoQueryInput)
+	output, err := c.dg.QueryRadiusPage(ctx, input, out)
+
+	return out.items, output, err
+}
+
+// QueryRadiusIter is like DynGeo.QueryRadiusIter, but streams the results as
+// T.
+func (c *Collection[T]) QueryRadiusIter(ctx context.Context, input QueryRadiusInput) *CollectionIterator[T] {
+	out := c.results(&input.GeoQueryInput)
+
+	return &CollectionIterator[T]{it: c.dg.QueryRadiusIter(ctx, input), results: out}
+}
+
+// QueryRectangle is like DynGeo.QueryRectangle, but returns the results as T.
+func (c *Collection[T]) QueryRectangle(ctx context.Context, input QueryRectangleInput) ([]T, error) {
+	out := c.results(&input.GeoQueryInput)
+	err := c.dg.QueryRectangle(ctx, input, out)
+
+	return out.items, err
+}
+
+// QueryRectanglePage is like DynGeo.QueryRectanglePage, but returns the
+// results as T.
+func (c *Collection[T]) QueryRectanglePage(ctx context.Context, input QueryRectangleInput) ([]T, *QueryRectangleOutput, error) {
+	out := c.results(&input.GeoQueryInput)
+	output, err := c.dg.QueryRectanglePage(ctx, input, out)
+
+	return out.items, output, err
+}
+
+// QueryRectangleIter is like DynGeo.QueryRectangleIter, but streams the
+// results as T.
+func (c *Collection[T]) QueryRectangleIter(ctx context.Context, input QueryRectangleInput) *CollectionIterator[T] {
+	out := c.results(&input.GeoQueryInput)
+
+	return &CollectionIterator[T]{it: c.dg.QueryRectangleIter(ctx, input), results: out}
+}
+
+// QuerySector is like DynGeo.QuerySector, but returns the results as T.
+func (c *Collection[T]) QuerySector(ctx context.Context, input QuerySectorInput) ([]T, error) {
+	out := c.results(&input.GeoQueryInput)
+	err := c.dg.QuerySector(ctx, input, out)
+
+	return out.items, err
+}
+
+// QueryPolygon is like DynGeo.QueryPolygon, but returns the results as T.
+func (c *Collection[T]) QueryPolygon(ctx context.Context, input QueryPolygonInput) ([]T, error) {
+	out := c.results(&input.GeoQueryInput)
+	err := c.dg.QueryPolygon(ctx, input, out)
+
+	return out.items, err
+}
+
+// QueryCorridor is like DynGeo.QueryCorridor, but returns the results as T.
+// The distance field holds the distance from the start of the route.
+func (c *Collection[T]) QueryCorridor(ctx context.Context, input QueryCorridorInput) ([]T, *QueryCorridorOutput, error) {
+	out := c.results(&input.GeoQueryInput)
+	output, err := c.dg.QueryCorridor(ctx, input, out)
+
+	return out.items, output, err
+}
+
+// QueryNearest is like DynGeo.QueryNearest, but returns the results as T.
+func (c *Collection[T]) QueryNearest(ctx context.Context, input QueryNearestInput) ([]T, error) {
+	out := c.results(&input.GeoQueryInput)
+	err := c.dg.QueryNearest(ctx, input, out)
+
+	return out.items, err
+}
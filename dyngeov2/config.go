@@ -0,0 +1,193 @@
+package dyngeov2
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/crolly/dyngeo/internal/geo"
+	"github.com/golang/geo/s2"
+)
+
+// MERGE_THRESHOLD ...
+const MERGE_THRESHOLD = geo.MERGE_THRESHOLD
+
+// MAX_NEAREST_QUERIES bounds the DynamoDB queries of a QueryNearest.
+const MAX_NEAREST_QUERIES = geo.MAX_NEAREST_QUERIES
+
+// DynamoDBAPI lists the DynamoDB operations DynG(e)o uses. It is implemented
+// by *dynamodb.Client as well as by clients wrapped for tracing or mocks.
+type DynamoDBAPI interface {
+	Query(context.Context, *dynamodb.QueryInput, ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	GetItem(context.Context, *dynamodb.GetItemInput, ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(context.Context, *dynamodb.PutItemInput, ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	UpdateItem(context.Context, *dynamodb.UpdateItemInput, ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	DeleteItem(context.Context, *dynamodb.DeleteItemInput, ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+	BatchWriteItem(context.Context, *dynamodb.BatchWriteItemInput, ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error)
+	BatchGetItem(context.Context, *dynamodb.BatchGetItemInput, ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error)
+	TransactWriteItems(context.Context, *dynamodb.TransactWriteItemsInput, ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error)
+}
+
+var _ DynamoDBAPI = (*dynamodb.Client)(nil)
+
+// HashKeyScheme defines how the hash key of an item is derived from its geohash.
+type HashKeyScheme = geo.HashKeyScheme
+
+const (
+	// DecimalPrefixHashKey uses the first HashKeyLength decimal digits of the
+	// geohash as hash key.
+	DecimalPrefixHashKey = geo.DecimalPrefixHashKey
+	// ParentCellHashKey uses the ID of the geohash's ancestor cell at
+	// HashKeyCellLevel as hash key, so that every hash key covers exactly one
+	// S2 cell.
+	ParentCellHashKey = geo.ParentCellHashKey
+)
+
+// RangeKeyType is the attribute type of the range key in the table.
+type RangeKeyType = geo.RangeKeyType
+
+const (
+	// StringRangeKey stores range keys as strings, e.g. UUIDs or composite
+	// "tenant#id" keys.
+	StringRangeKey = geo.StringRangeKey
+	// NumberRangeKey stores range keys as numbers, e.g. timestamps.
+	NumberRangeKey = geo.NumberRangeKey
+)
+
+// StorageFormat selects the attributes the location of a point is stored in.
+type StorageFormat = geo.StorageFormat
+
+const (
+	// GeoJSONStringStorage stores a GeoJSON point encoded as JSON string in
+	// GeoJSONAttributeName, the format of earlier versions.
+	GeoJSONStringStorage = geo.GeoJSONStringStorage
+	// GeoJSONMapStorage stores a GeoJSON point as Map attribute in
+	// GeoJSONAttributeName.
+	GeoJSONMapStorage = geo.GeoJSONMapStorage
+	// LatLngStorage stores latitude and longitude as Number attributes in
+	// LatitudeAttributeName and LongitudeAttributeName.
+	LatLngStorage = geo.LatLngStorage
+)
+
+// DynGeoConfig holds the same options as its AWS SDK v1 counterpart, so a
+// table written with one flavour can be read with the other.
+//
+// MinCellLevel, MaxCellLevel and MaxCells configure the S2 region coverer
+// that turns query regions into cells, which defaults to exactly level 10.
+// If only MaxCellLevel is set, MinCellLevel is 0. If only MinCellLevel is
+// set, MaxCellLevel is 10 or MinCellLevel, whichever is higher.
+// With AdaptiveCovering the cell level is instead picked per query from the
+// size of the region and the hash key partitions, aiming for about MaxCells
+// cells. Regions spanning more than MAX_ADAPTIVE_CELLS hash keys then fail
+// with ErrRegionTooLarge.
+//
+// RangeKeyType is the attribute type of the range key in the table created
+// by GetCreateTableRequest. It has to match the type of the RangeKeyValue of
+// every point, other points are rejected with ErrInvalidRangeKey.
+//
+// StorageFormat selects how the location of a point is stored. It defaults to
+// GeoJSONStringStorage, use GeoJSONMapStorage|LatLngStorage to store both a
+// GeoJSON Map and Number attributes. Items are read in every format, so
+// tables written in another format can still be queried.
+//
+// NormalizeLongitude wraps longitudes outside ±180 into that range, e.g. 190
+// becomes -170. Otherwise they are rejected with ErrInvalidCoordinate, like
+// latitudes outside ±90.
+//
+// HashKeyScheme selects how hash keys are derived from geohashes. Tables
+// written with one scheme cannot be queried with the other.
+// HashKeyCellLevel is the level of the cells ParentCellHashKey uses as hash
+// keys. It has no default, as 0 is a valid level whose six cells are the cube
+// faces, so New fails if it isn't set with that scheme, e.g. to aws.Int(10).
+//
+// MaxConcurrency limits the number of DynamoDB queries in flight across all
+// geo queries of the DynGeo instance. 0 means no limit.
+// It also limits the number of concurrent requests of a batch write, which
+// defaults to 4.
+type DynGeoConfig struct {
+	TableName              string
+	ConsistentRead         bool
+	HashKeyAttributeName   string
+	RangeKeyAttributeName  string
+	RangeKeyType           RangeKeyType
+	GeoHashAttributeName   string
+	GeoJSONAttributeName   string
+	LatitudeAttributeName  string
+	LongitudeAttributeName string
+	StorageFormat          StorageFormat
+	GeoHashIndexName       string
+	HashKeyLength          int8
+	HashKeyScheme          HashKeyScheme
+	HashKeyCellLevel       *int
+	LongitudeFirst         bool
+	NormalizeLongitude     bool
+	MinCellLevel           int
+	MaxCellLevel           int
+	MaxCells               int
+	AdaptiveCovering       bool
+	MaxConcurrency         int
+
+	DynamoDBClient  DynamoDBAPI
+	s2RegionCoverer s2.RegionCoverer
+}
+
+// codec returns the geo.Codec reading and writing the geo attributes of
+// items as configured.
+func (config DynGeoConfig) codec() geo.Codec[types.AttributeValue] {
+	return geo.Codec[types.AttributeValue]{
+		Attributes: attributes{},
+		Schema: geo.Schema{
+			HashKeyAttributeName:   config.HashKeyAttributeName,
+			RangeKeyAttributeName:  config.RangeKeyAttributeName,
+			GeoHashAttributeName:   config.GeoHashAttributeName,
+			GeoJSONAttributeName:   config.GeoJSONAttributeName,
+			LatitudeAttributeName:  config.LatitudeAttributeName,
+			LongitudeAttributeName: config.LongitudeAttributeName,
+			StorageFormat:          config.StorageFormat,
+			LongitudeFirst:         config.LongitudeFirst,
+			NormalizeLongitude:     config.NormalizeLongitude,
+			Hasher: geo.Hasher{
+				Scheme:    config.HashKeyScheme,
+				Length:    config.HashKeyLength,
+				CellLevel: aws.ToInt(config.HashKeyCellLevel),
+			},
+		},
+	}
+}
+
+// engine returns the geo.Engine running the geo queries as configured.
+func (config DynGeoConfig) engine() geo.Engine[types.AttributeValue] {
+	return geo.Engine[types.AttributeValue]{
+		Codec:            config.codec(),
+		Coverer:          config.s2RegionCoverer,
+		AdaptiveCovering: config.AdaptiveCovering,
+		MaxConcurrency:   config.MaxConcurrency,
+	}
+}
+
+// func NewConfig(dynamoClient *dynamodb.DynamoDB, tableName string) DynGeoConfig {
+// 	return DynGeoConfig{
+// 		tableName:             tableName,
+// 		consistentRead:        false,
+// 		hashKeyAttributeName:  "hashKey",
+// 		rangeKeyAttributeName: "rangeKey",
+// 		geohashAttributeName:  "geohash",
+// 		geoJSONAttributeName:  "geoJson",
+// 		geohashIndexName:      "geohash-index",
+// 		hashKeyLength:         2,
+// 		longitudeFirst:        true,
+
+// 		dynamodbClient: dynamoClient,
+// 		s2RegionCoverer: s2.RegionCoverer{
+// 			MinLevel: 10,
+// 			MaxLevel: 10,
+// 			MaxCells: 10,
+// 		},
+// 	}
+// }
+
+// // SetHashKeyLength ...
+// func (config *DynGeoConfig) SetHashKeyLength(length int8) {
+// 	config.hashKeyLength = length
+// }
@@ -0,0 +1,53 @@
+package dyngeov2
+
+import (
+	"context"
+
+	"github.com/crolly/dyngeo/internal/geo"
+)
+
+// CountRadius counts the points within the radius around the center point
+// without returning them.
+func (dg DynGeo) CountRadius(ctx context.Context, input QueryRadiusInput) (*CountOutput, error) {
+	q, err := input.query(dg.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	return dg.count(ctx, q, input.GeoQueryInput)
+}
+
+// CountRectangle counts the points within the rectangle without returning
+// them.
+func (dg DynGeo) CountRectangle(ctx context.Context, input QueryRectangleInput) (*CountOutput, error) {
+	q, err := input.query(dg.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	return dg.count(ctx, q, input.GeoQueryInput)
+}
+
+// CountPolygon counts the points within the polygon without returning them.
+func (dg DynGeo) CountPolygon(ctx context.Context, input QueryPolygonInput) (*CountOutput, error) {
+	q, err := input.query()
+	if err != nil {
+		return nil, err
+	}
+
+	return dg.count(ctx, q, input.GeoQueryInput)
+}
+
+// count counts the points within the shape, see geo.Engine.Count.
+func (dg DynGeo) count(ctx context.Context, q geo.Shape, input GeoQueryInput) (*CountOutput, error) {
+	req := dg.db.request(input)
+	result, queryErr := dg.Config.engine().Count(ctx, q, req)
+	if req.Failed(queryErr) {
+		return nil, queryErr
+	}
+
+	return &CountOutput{
+		Count:            result.Count,
+		ConsumedCapacity: dg.consumedCapacity(result.CapacityUnits),
+	}, queryErr
+}
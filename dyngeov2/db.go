@@ -0,0 +1,763 @@
+package dyngeov2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/crolly/dyngeo/internal/geo"
+)
+
+type db struct {
+	config DynGeoConfig
+	codec  geo.Codec[types.AttributeValue]
+	pool   *geo.WorkerPool
+}
+
+func newDB(config DynGeoConfig) db {
+	return db{
+		config: config,
+		codec:  config.codec(),
+		pool:   geo.NewWorkerPool(config.MaxConcurrency),
+	}
+}
+
+// batchConcurrency returns the number of batch requests sent at the same
+// time.
+func (db db) batchConcurrency() int {
+	if db.config.MaxConcurrency > 0 {
+		return db.config.MaxConcurrency
+	}
+
+	return geo.BATCH_CONCURRENCY
+}
+
+// query issues a single Query request once the worker pool has a free slot.
+func (db db) query(ctx context.Context, queryInput *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	if err := db.pool.Acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer db.pool.Release()
+
+	return db.config.DynamoDBClient.Query(ctx, queryInput)
+}
+
+// request returns the geo.Request reading the geohash ranges with the
+// QueryInput of the query.
+func (db db) request(input GeoQueryInput) geo.Request[types.AttributeValue] {
+	return geo.Request[types.AttributeValue]{
+		Options: input.options(),
+		Query: func(ctx context.Context, hashKey uint64, ghr geo.Range, startKey map[string]types.AttributeValue, limit int) (geo.Page[types.AttributeValue], error) {
+			queryInput := db.geoHashQueryInput(input.QueryInput, hashKey, ghr)
+			queryInput.ExclusiveStartKey = startKey
+			if limit > 0 {
+				queryInput.Limit = aws.Int32(int32(limit))
+			}
+
+			return db.queryPage(ctx, &queryInput)
+		},
+		Count: func(ctx context.Context, hashKey uint64, ghr geo.Range, startKey map[string]types.AttributeValue, limit int) (geo.Page[types.AttributeValue], error) {
+			queryInput := db.geoHashQueryInput(input.QueryInput, hashKey, ghr)
+			queryInput.ExclusiveStartKey = startKey
+			queryInput.Select = types.SelectCount
+			queryInput.AttributesToGet = nil
+			queryInput.ProjectionExpression = nil
+
+			return db.queryPage(ctx, &queryInput)
+		},
+	}
+}
+
+// queryPage issues the Query request and returns its page.
+func (db db) queryPage(ctx context.Context, queryInput *dynamodb.QueryInput) (geo.Page[types.AttributeValue], error) {
+	output, err := db.query(ctx, queryInput)
+	if err != nil {
+		return geo.Page[types.AttributeValue]{}, err
+	}
+
+	page := geo.Page[types.AttributeValue]{
+		Items:            output.Items,
+		LastEvaluatedKey: output.LastEvaluatedKey,
+		Count:            int64(output.Count),
+		ScannedCount:     int64(output.ScannedCount),
+	}
+	if output.ConsumedCapacity != nil {
+		page.CapacityUnits = aws.ToFloat64(output.ConsumedCapacity.CapacityUnits)
+	}
+
+	return page, nil
+}
+
+// geoHashQueryInput restricts the query to the geohash range. Like the v1
+// flavour, it only fills in the options left unset in queryInput.
+func (db db) geoHashQueryInput(queryInput dynamodb.QueryInput, hashKey uint64, ghr geo.Range) dynamodb.QueryInput {
+	keyConditions := map[string]types.Condition{
+		db.config.HashKeyAttributeName: {
+			ComparisonOperator: types.ComparisonOperatorEq,
+			AttributeValueList: []types.AttributeValue{db.codec.NewUint(hashKey)},
+		},
+		db.config.GeoHashAttributeName: {
+			ComparisonOperator: types.ComparisonOperatorBetween,
+			AttributeValueList: []types.AttributeValue{db.codec.NewUint(ghr.Min), db.codec.NewUint(ghr.Max)},
+		},
+	}
+	for k, v := range queryInput.KeyConditions {
+		if _, ok := keyConditions[k]; !ok {
+			keyConditions[k] = v
+		}
+	}
+	queryInput.KeyConditions = keyConditions
+
+	if queryInput.TableName == nil {
+		queryInput.TableName = aws.String(db.config.TableName)
+	}
+	if queryInput.IndexName == nil {
+		queryInput.IndexName = aws.String(db.config.GeoHashIndexName)
+	}
+	if queryInput.ConsistentRead == nil {
+		queryInput.ConsistentRead = aws.Bool(db.config.ConsistentRead)
+	}
+	if queryInput.ReturnConsumedCapacity == "" {
+		queryInput.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	}
+
+	return queryInput
+}
+
+func (db db) getPoint(ctx context.Context, input GetPointInput) (*GetPointOutput, error) {
+	pointInput, err := geo.ValidPointInput(input.PointInput, db.config.RangeKeyType, db.config.NormalizeLongitude)
+	if err != nil {
+		return nil, err
+	}
+
+	getItemInput := input.GetItemInput
+	getItemInput.TableName = aws.String(db.config.TableName)
+	getItemInput.Key = db.codec.Key(pointInput)
+
+	out, err := db.config.DynamoDBClient.GetItem(ctx, &getItemInput)
+
+	return &GetPointOutput{out}, err
+}
+
+func (db db) putPoint(ctx context.Context, input PutPointInput) (*PutPointOutput, error) {
+	pointInput, err := geo.ValidPointInput(input.PointInput, db.config.RangeKeyType, db.config.NormalizeLongitude)
+	if err != nil {
+		return nil, err
+	}
+
+	putItemInput := input.PutItemInput
+	putItemInput.TableName = aws.String(db.config.TableName)
+
+	item, err := db.codec.PointItem(putItemInput.Item, pointInput)
+	if err != nil {
+		return nil, err
+	}
+	putItemInput.Item = item
+
+	out, err := db.config.DynamoDBClient.PutItem(ctx, &putItemInput)
+
+	return &PutPointOutput{out}, err
+}
+
+// batchWrite sends the write requests in chunks of MAX_BATCH_WRITE_ITEMS,
+// which are sent concurrently. Unprocessed items are retried with exponential
+// backoff. It returns the indices of the requests that could not be written.
+// Requests for the same point fail with ErrDuplicateKey before any is sent.
+func (db db) batchWrite(ctx context.Context, writeInputs []types.WriteRequest) ([]geo.BatchFailure[int], error) {
+	items := make([]map[string]types.AttributeValue, len(writeInputs))
+	for i, w := range writeInputs {
+		items[i] = writeRequestItem(w)
+	}
+	index, err := db.codec.NewBatchIndex(items)
+	if err != nil {
+		return nil, err
+	}
+
+	send := func(pending []int) ([]int, error) {
+		writeRequests := make([]types.WriteRequest, len(pending))
+		for i, p := range pending {
+			writeRequests[i] = writeInputs[p]
+		}
+
+		out, err := db.config.DynamoDBClient.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]types.WriteRequest{
+				db.config.TableName: writeRequests,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		unprocessed := []map[string]types.AttributeValue{}
+		for _, w := range out.UnprocessedItems[db.config.TableName] {
+			unprocessed = append(unprocessed, writeRequestItem(w))
+		}
+
+		return db.codec.Positions(index, unprocessed), nil
+	}
+
+	return geo.RunBatches(ctx, len(writeInputs), MAX_BATCH_WRITE_ITEMS, db.batchConcurrency(), send)
+}
+
+// writeRequestItem returns the item of a put or the key of a delete request.
+func writeRequestItem(w types.WriteRequest) map[string]types.AttributeValue {
+	if w.PutRequest != nil {
+		return w.PutRequest.Item
+	}
+
+	return w.DeleteRequest.Key
+}
+
+// batchWritePoints writes the points with batchWrite. The inputs that could
+// not be written are reported in the output and by a *BatchWriteError.
+func (db db) batchWritePoints(ctx context.Context, inputs []PutPointInput) (*BatchWritePointOutput, error) {
+	writeInputs := make([]types.WriteRequest, len(inputs))
+	for i, input := range inputs {
+		pointInput, err := geo.ValidPointInput(input.PointInput, db.config.RangeKeyType, db.config.NormalizeLongitude)
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
+
+		item, err := db.codec.PointItem(input.PutItemInput.Item, pointInput)
+		if err != nil {
+			return nil, err
+		}
+
+		writeInputs[i] = types.WriteRequest{PutRequest: &types.PutRequest{Item: item}}
+	}
+
+	failures, err := db.batchWrite(ctx, writeInputs)
+	if err != nil {
+		return nil, err
+	}
+
+	output := &BatchWritePointOutput{BatchWriteItemOutput: &dynamodb.BatchWriteItemOutput{}}
+	if len(failures) == 0 {
+		return output, nil
+	}
+
+	output.Failures = geo.InputFailures(inputs, failures)
+	unprocessed := []types.WriteRequest{}
+	for _, f := range failures {
+		unprocessed = append(unprocessed, writeInputs[f.Item])
+	}
+	output.UnprocessedItems = map[string][]types.WriteRequest{
+		db.config.TableName: unprocessed,
+	}
+
+	return output, &BatchWriteError{Failures: output.Failures}
+}
+
+// batchDeletePoints deletes the points with batchWrite. The inputs that could
+// not be deleted are reported in the output and by a *BatchPointError.
+func (db db) batchDeletePoints(ctx context.Context, inputs []PointInput) (*BatchDeletePointOutput, error) {
+	writeInputs := make([]types.WriteRequest, len(inputs))
+	for i, input := range inputs {
+		pointInput, err := geo.ValidPointInput(input, db.config.RangeKeyType, db.config.NormalizeLongitude)
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
+
+		writeInputs[i] = types.WriteRequest{DeleteRequest: &types.DeleteRequest{Key: db.codec.Key(pointInput)}}
+	}
+
+	failures, err := db.batchWrite(ctx, writeInputs)
+	if err != nil {
+		return nil, err
+	}
+
+	output := &BatchDeletePointOutput{BatchWriteItemOutput: &dynamodb.BatchWriteItemOutput{}}
+	if len(failures) == 0 {
+		return output, nil
+	}
+
+	output.Failures = geo.InputFailures(inputs, failures)
+	unprocessed := []types.WriteRequest{}
+	for _, f := range failures {
+		unprocessed = append(unprocessed, writeInputs[f.Item])
+	}
+	output.UnprocessedItems = map[string][]types.WriteRequest{
+		db.config.TableName: unprocessed,
+	}
+
+	return output, &BatchPointError{Failures: output.Failures}
+}
+
+// batchGetPoints reads the points in chunks of MAX_BATCH_GET_ITEMS keys, which
+// are sent concurrently. Unprocessed keys are retried with exponential
+// backoff. It returns the items found, in no particular order, and reports
+// the inputs that could not be read in the output and by a *BatchPointError.
+// Keys of the same point fail with ErrDuplicateKey before any is sent.
+func (db db) batchGetPoints(ctx context.Context, inputs []PointInput) ([]map[string]types.AttributeValue, *BatchGetPointOutput, error) {
+	keys := make([]map[string]types.AttributeValue, len(inputs))
+	for i, input := range inputs {
+		pointInput, err := geo.ValidPointInput(input, db.config.RangeKeyType, db.config.NormalizeLongitude)
+		if err != nil {
+			return nil, nil, fmt.Errorf("input %d: %w", i, err)
+		}
+
+		keys[i] = db.codec.Key(pointInput)
+	}
+	index, err := db.codec.NewBatchIndex(keys)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	items := []map[string]types.AttributeValue{}
+	mtx := &sync.Mutex{}
+
+	send := func(pending []int) ([]int, error) {
+		requestKeys := make([]map[string]types.AttributeValue, len(pending))
+		for i, p := range pending {
+			requestKeys[i] = keys[p]
+		}
+
+		out, err := db.config.DynamoDBClient.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{
+			RequestItems: map[string]types.KeysAndAttributes{
+				db.config.TableName: {
+					Keys:           requestKeys,
+					ConsistentRead: aws.Bool(db.config.ConsistentRead),
+				},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		mtx.Lock()
+		items = append(items, out.Responses[db.config.TableName]...)
+		mtx.Unlock()
+
+		if keysAndAttributes, ok := out.UnprocessedKeys[db.config.TableName]; ok {
+			return db.codec.Positions(index, keysAndAttributes.Keys), nil
+		}
+
+		return nil, nil
+	}
+
+	failures, err := geo.RunBatches(ctx, len(inputs), MAX_BATCH_GET_ITEMS, db.batchConcurrency(), send)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	output := &BatchGetPointOutput{}
+	if len(failures) == 0 {
+		return items, output, nil
+	}
+
+	output.Failures = geo.InputFailures(inputs, failures)
+
+	return items, output, &BatchPointError{Failures: output.Failures}
+}
+
+func (db db) updatePoint(ctx context.Context, input UpdatePointInput) (*UpdatePointOutput, error) {
+	pointInput, err := geo.ValidPointInput(input.PointInput, db.config.RangeKeyType, db.config.NormalizeLongitude)
+	if err != nil {
+		return nil, err
+	}
+
+	input.UpdateItemInput.TableName = aws.String(db.config.TableName)
+	if input.UpdateItemInput.Key == nil {
+		input.UpdateItemInput.Key = db.codec.Key(pointInput)
+	}
+
+	// geoHash and the location attributes cannot be updated
+	if input.UpdateItemInput.AttributeUpdates != nil {
+		delete(input.UpdateItemInput.AttributeUpdates, db.config.GeoHashAttributeName)
+		for _, name := range db.codec.LocationAttributeNames() {
+			delete(input.UpdateItemInput.AttributeUpdates, name)
+		}
+	}
+
+	out, err := db.config.DynamoDBClient.UpdateItem(ctx, &input.UpdateItemInput)
+
+	return &UpdatePointOutput{out}, err
+}
+
+// movePoint moves the point to its new location. The item is read first, to
+// find the location attributes of other storage formats it has been written
+// with, which are removed. Within the same hash key only the geohash and
+// location attributes are updated. Otherwise the item is deleted and written
+// with its new key, geohash and location in a single transaction, keeping all
+// other attributes. The write is conditioned on the geohash read, so it fails
+// with ErrPointModified if the point has been moved or deleted in the
+// meantime. The transaction is also canceled if an item already exists at the
+// new key.
+func (db db) movePoint(ctx context.Context, input MovePointInput) (*MovePointOutput, error) {
+	pointInput, err := geo.ValidPointInput(input.PointInput, db.config.RangeKeyType, db.config.NormalizeLongitude)
+	if err != nil {
+		return nil, err
+	}
+	input.PointInput = pointInput
+	if input.NewGeoPoint, err = geo.ValidGeoPoint("NewGeoPoint", input.NewGeoPoint, db.config.NormalizeLongitude); err != nil {
+		return nil, err
+	}
+
+	newInput := PointInput{RangeKeyValue: input.RangeKeyValue, GeoPoint: input.NewGeoPoint}
+	_, oldHashKey := db.codec.Hasher.Hashes(input.GeoPoint.LatLng())
+	_, newHashKey := db.codec.Hasher.Hashes(input.NewGeoPoint.LatLng())
+
+	oldItem, err := db.config.DynamoDBClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(db.config.TableName),
+		Key:            db.codec.Key(input.PointInput),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(oldItem.Item) == 0 {
+		return nil, ErrPointNotFound
+	}
+
+	// the condition has to be built before the item is changed in place
+	unmoved, readNames, values := db.codec.MoveCondition(oldItem.Item)
+
+	if oldHashKey == newHashKey {
+		item, err := db.codec.PointItem(nil, newInput)
+		if err != nil {
+			return nil, err
+		}
+
+		update, locationNames, locationValues := db.codec.LocationUpdate(item, db.codec.StaleLocationAttributeNames(oldItem.Item))
+		for k, name := range locationNames {
+			readNames[k] = name
+		}
+		for k, v := range locationValues {
+			values[k] = v
+		}
+
+		out, err := db.config.DynamoDBClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:                 aws.String(db.config.TableName),
+			Key:                       db.codec.Key(input.PointInput),
+			UpdateExpression:          aws.String(update),
+			ConditionExpression:       aws.String(unmoved),
+			ExpressionAttributeNames:  readNames,
+			ExpressionAttributeValues: values,
+		})
+		var conditionErr *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionErr) {
+			return nil, ErrPointModified
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return &MovePointOutput{UpdateItemOutput: out}, nil
+	}
+
+	item, err := db.codec.MovedPointItem(oldItem.Item, newInput)
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]string{
+		"#hashKey":  db.config.HashKeyAttributeName,
+		"#rangeKey": db.config.RangeKeyAttributeName,
+	}
+	out, err := db.config.DynamoDBClient.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Delete: &types.Delete{
+					TableName:                 aws.String(db.config.TableName),
+					Key:                       db.codec.Key(input.PointInput),
+					ConditionExpression:       aws.String(unmoved),
+					ExpressionAttributeNames:  readNames,
+					ExpressionAttributeValues: values,
+				},
+			},
+			{
+				Put: &types.Put{
+					TableName:                aws.String(db.config.TableName),
+					Item:                     item,
+					ConditionExpression:      aws.String("attribute_not_exists(#hashKey) AND attribute_not_exists(#rangeKey)"),
+					ExpressionAttributeNames: names,
+				},
+			},
+		},
+	})
+	if reasons, ok := cancellationReasons(err); ok && len(reasons) > 0 && reasons[0].Code == "ConditionalCheckFailed" {
+		return nil, ErrPointModified
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &MovePointOutput{TransactWriteItemsOutput: out}, nil
+}
+
+// transactItem returns the transaction item of the operation, with the key
+// and geo attributes computed like for putPoint, updatePoint and deletePoint.
+func (db db) transactItem(input TransactWritePointInput) (types.TransactWriteItem, error) {
+	ops := 0
+	for _, set := range []bool{input.Put != nil, input.Update != nil, input.Delete != nil, input.ConditionCheck != nil} {
+		if set {
+			ops++
+		}
+	}
+	if ops != 1 {
+		return types.TransactWriteItem{}, errors.New("exactly one of Put, Update, Delete and ConditionCheck is required")
+	}
+
+	var pointInput PointInput
+	switch {
+	case input.Put != nil:
+		pointInput = input.Put.PointInput
+	case input.Update != nil:
+		pointInput = input.Update.PointInput
+	case input.Delete != nil:
+		pointInput = input.Delete.PointInput
+	default:
+		pointInput = input.ConditionCheck.PointInput
+	}
+	pointInput, err := geo.ValidPointInput(pointInput, db.config.RangeKeyType, db.config.NormalizeLongitude)
+	if err != nil {
+		return types.TransactWriteItem{}, err
+	}
+
+	switch {
+	case input.Put != nil:
+		if input.Put.PutItemInput.Expected != nil || input.Put.PutItemInput.ConditionalOperator != "" {
+			return types.TransactWriteItem{}, errors.New("transactions don't support Expected and ConditionalOperator, use ConditionExpression")
+		}
+		item, err := db.codec.PointItem(input.Put.PutItemInput.Item, pointInput)
+		if err != nil {
+			return types.TransactWriteItem{}, err
+		}
+
+		return types.TransactWriteItem{Put: &types.Put{
+			TableName:                 aws.String(db.config.TableName),
+			Item:                      item,
+			ConditionExpression:       input.Put.PutItemInput.ConditionExpression,
+			ExpressionAttributeNames:  input.Put.PutItemInput.ExpressionAttributeNames,
+			ExpressionAttributeValues: input.Put.PutItemInput.ExpressionAttributeValues,
+		}}, nil
+	case input.Update != nil:
+		updateInput := input.Update.UpdateItemInput
+		if updateInput.Expected != nil || updateInput.ConditionalOperator != "" {
+			return types.TransactWriteItem{}, errors.New("transactions don't support Expected and ConditionalOperator, use ConditionExpression")
+		}
+		key := updateInput.Key
+		if key == nil {
+			key = db.codec.Key(pointInput)
+		}
+
+		update := &types.Update{
+			TableName:                 aws.String(db.config.TableName),
+			Key:                       key,
+			UpdateExpression:          updateInput.UpdateExpression,
+			ConditionExpression:       updateInput.ConditionExpression,
+			ExpressionAttributeNames:  updateInput.ExpressionAttributeNames,
+			ExpressionAttributeValues: updateInput.ExpressionAttributeValues,
+		}
+		if updateInput.AttributeUpdates != nil {
+			if updateInput.UpdateExpression != nil {
+				return types.TransactWriteItem{}, errors.New("AttributeUpdates and UpdateExpression cannot be used together")
+			}
+			if err := db.translateAttributeUpdates(update, updateInput.AttributeUpdates); err != nil {
+				return types.TransactWriteItem{}, err
+			}
+		}
+
+		return types.TransactWriteItem{Update: update}, nil
+	case input.Delete != nil:
+		if input.Delete.DeleteItemInput.Expected != nil || input.Delete.DeleteItemInput.ConditionalOperator != "" {
+			return types.TransactWriteItem{}, errors.New("transactions don't support Expected and ConditionalOperator, use ConditionExpression")
+		}
+
+		return types.TransactWriteItem{Delete: &types.Delete{
+			TableName:                 aws.String(db.config.TableName),
+			Key:                       db.codec.Key(pointInput),
+			ConditionExpression:       input.Delete.DeleteItemInput.ConditionExpression,
+			ExpressionAttributeNames:  input.Delete.DeleteItemInput.ExpressionAttributeNames,
+			ExpressionAttributeValues: input.Delete.DeleteItemInput.ExpressionAttributeValues,
+		}}, nil
+	default:
+		conditionCheck := input.ConditionCheck.ConditionCheck
+		conditionCheck.TableName = aws.String(db.config.TableName)
+		conditionCheck.Key = db.codec.Key(pointInput)
+
+		return types.TransactWriteItem{ConditionCheck: &conditionCheck}, nil
+	}
+}
+
+// translateAttributeUpdates sets the update expression of update to the
+// legacy AttributeUpdates, which transactions don't support. Like for
+// updatePoint, the geohash and location attributes are left out.
+func (db db) translateAttributeUpdates(update *types.Update, attributeUpdates map[string]types.AttributeValueUpdate) error {
+	protected := map[string]bool{db.config.GeoHashAttributeName: true}
+	for _, name := range db.codec.LocationAttributeNames() {
+		protected[name] = true
+	}
+
+	attributeNames := make([]string, 0, len(attributeUpdates))
+	for name := range attributeUpdates {
+		if !protected[name] {
+			attributeNames = append(attributeNames, name)
+		}
+	}
+	if len(attributeNames) == 0 {
+		return errors.New("AttributeUpdates only updates the geohash and location attributes")
+	}
+	sort.Strings(attributeNames)
+
+	names := map[string]string{}
+	for k, name := range update.ExpressionAttributeNames {
+		names[k] = name
+	}
+	values := map[string]types.AttributeValue{}
+	for k, value := range update.ExpressionAttributeValues {
+		values[k] = value
+	}
+
+	clauses := map[string][]string{}
+	for i, name := range attributeNames {
+		attributeUpdate := attributeUpdates[name]
+		nameKey, valueKey := fmt.Sprintf("#update%d", i), fmt.Sprintf(":update%d", i)
+		names[nameKey] = name
+		if attributeUpdate.Value != nil {
+			values[valueKey] = attributeUpdate.Value
+		}
+
+		switch action := attributeUpdate.Action; {
+		case action == "" || action == types.AttributeActionPut:
+			clauses["SET"] = append(clauses["SET"], nameKey+" = "+valueKey)
+		case action == types.AttributeActionAdd:
+			clauses["ADD"] = append(clauses["ADD"], nameKey+" "+valueKey)
+		case action == types.AttributeActionDelete && attributeUpdate.Value != nil:
+			clauses["DELETE"] = append(clauses["DELETE"], nameKey+" "+valueKey)
+		case action == types.AttributeActionDelete:
+			clauses["REMOVE"] = append(clauses["REMOVE"], nameKey)
+		default:
+			return fmt.Errorf("unknown action %s of attribute %s", action, name)
+		}
+	}
+
+	expression := []string{}
+	for _, clause := range []string{"SET", "REMOVE", "ADD", "DELETE"} {
+		if len(clauses[clause]) > 0 {
+			expression = append(expression, clause+" "+strings.Join(clauses[clause], ", "))
+		}
+	}
+	update.UpdateExpression = aws.String(strings.Join(expression, " "))
+	update.ExpressionAttributeNames = names
+	if len(values) > 0 {
+		update.ExpressionAttributeValues = values
+	}
+
+	return nil
+}
+
+// transactWritePoints runs all operations in a single transaction. If
+// DynamoDB cancels it, the cancellation reasons are mapped back to the inputs
+// in a *TransactWriteError.
+func (db db) transactWritePoints(ctx context.Context, inputs []TransactWritePointInput) (*TransactWritePointOutput, error) {
+	transactItems := make([]types.TransactWriteItem, len(inputs))
+	for i, input := range inputs {
+		item, err := db.transactItem(input)
+		if err != nil {
+			return nil, err
+		}
+		transactItems[i] = item
+	}
+
+	out, err := db.config.DynamoDBClient.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: transactItems,
+	})
+	if reasons, ok := cancellationReasons(err); ok {
+		return nil, geo.NewTransactError(inputs, reasons, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &TransactWritePointOutput{out}, nil
+}
+
+// cancellationReasons returns the reasons DynamoDB canceled a transaction
+// for, if err is a *types.TransactionCanceledException.
+func cancellationReasons(err error) ([]geo.CancellationReason, bool) {
+	var canceled *types.TransactionCanceledException
+	if !errors.As(err, &canceled) {
+		return nil, false
+	}
+
+	reasons := make([]geo.CancellationReason, len(canceled.CancellationReasons))
+	for i, reason := range canceled.CancellationReasons {
+		reasons[i] = geo.CancellationReason{Code: aws.ToString(reason.Code), Message: aws.ToString(reason.Message)}
+	}
+
+	return reasons, true
+}
+
+func (db db) deletePoint(ctx context.Context, input DeletePointInput) (*DeletePointOutput, error) {
+	pointInput, err := geo.ValidPointInput(input.PointInput, db.config.RangeKeyType, db.config.NormalizeLongitude)
+	if err != nil {
+		return nil, err
+	}
+
+	deleteItemInput := input.DeleteItemInput
+	deleteItemInput.TableName = aws.String(db.config.TableName)
+	deleteItemInput.Key = db.codec.Key(pointInput)
+
+	out, err := db.config.DynamoDBClient.DeleteItem(ctx, &deleteItemInput)
+
+	return &DeletePointOutput{out}, err
+}
+
+func GetCreateTableRequest(config DynGeoConfig) *dynamodb.CreateTableInput {
+	return &dynamodb.CreateTableInput{
+		TableName: aws.String(config.TableName),
+		ProvisionedThroughput: &types.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(10),
+			WriteCapacityUnits: aws.Int64(5),
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				KeyType:       types.KeyTypeHash,
+				AttributeName: aws.String(config.HashKeyAttributeName),
+			},
+			{
+				KeyType:       types.KeyTypeRange,
+				AttributeName: aws.String(config.RangeKeyAttributeName),
+			},
+		},
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String(config.HashKeyAttributeName),
+				AttributeType: types.ScalarAttributeTypeN,
+			},
+			{
+				AttributeName: aws.String(config.RangeKeyAttributeName),
+				AttributeType: types.ScalarAttributeType(config.RangeKeyType.AttributeType()),
+			},
+			{
+				AttributeName: aws.String(config.GeoHashAttributeName),
+				AttributeType: types.ScalarAttributeTypeN,
+			},
+		},
+		LocalSecondaryIndexes: []types.LocalSecondaryIndex{
+			{
+				IndexName: aws.String(config.GeoHashIndexName),
+				KeySchema: []types.KeySchemaElement{
+					{
+						KeyType:       types.KeyTypeHash,
+						AttributeName: aws.String(config.HashKeyAttributeName),
+					},
+					{
+						KeyType:       types.KeyTypeRange,
+						AttributeName: aws.String(config.GeoHashAttributeName),
+					},
+				},
+				Projection: &types.Projection{
+					ProjectionType: types.ProjectionTypeAll,
+				},
+			},
+		},
+	}
+}
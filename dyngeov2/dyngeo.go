@@ -0,0 +1,352 @@
+// Package dyngeov2 is the AWS SDK for Go v2 flavour of DynG(e)o. It offers
+// the same geo operations as package dyngeo, built on the types of
+// github.com/aws/aws-sdk-go-v2/service/dynamodb, and shares the geo hashing
+// and covering logic with it, so both flavours read and write the same
+// tables. Following the v2 SDK, every operation takes a context.
+package dyngeov2
+
+import (
+	"context"
+	"errors"
+
+	"github.com/imdario/mergo"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/crolly/dyngeo/internal/geo"
+	"github.com/golang/geo/s2"
+)
+
+type DynGeo struct {
+	Config DynGeoConfig
+	db     db
+}
+
+// New returns a DynGeo for the configured table, filling in the same
+// defaults as the v1 flavour.
+func New(config DynGeoConfig) (*DynGeo, error) {
+	if config.DynamoDBClient == nil {
+		return nil, errors.New("DynamoDBClient is required")
+	}
+
+	if config.TableName == "" {
+		return nil, errors.New("TableName is required")
+	}
+
+	defaultConfig := DynGeoConfig{
+		TableName:              config.TableName,
+		ConsistentRead:         false,
+		HashKeyAttributeName:   "hashKey",
+		RangeKeyAttributeName:  "rangeKey",
+		GeoHashAttributeName:   "geohash",
+		GeoJSONAttributeName:   "geoJson",
+		LatitudeAttributeName:  "lat",
+		LongitudeAttributeName: "lng",
+		GeoHashIndexName:       "geohash-index",
+		HashKeyLength:          2,
+		LongitudeFirst:         true,
+		MaxCells:               10,
+
+		DynamoDBClient: config.DynamoDBClient,
+	}
+
+	// cell levels of 0 are valid, so mergo cannot fill them in
+	minCellLevel, maxCellLevel, err := geo.CoverLevels(config.MinCellLevel, config.MaxCellLevel)
+	if err != nil {
+		return nil, err
+	}
+	if config.HashKeyCellLevel != nil {
+		if err := geo.ValidateLevel("HashKeyCellLevel", *config.HashKeyCellLevel); err != nil {
+			return nil, err
+		}
+	} else if config.HashKeyScheme == ParentCellHashKey {
+		return nil, errors.New("HashKeyCellLevel needs to be set with ParentCellHashKey")
+	}
+	if config.MaxCells < 0 {
+		return nil, errors.New("MaxCells needs to be greater than 0")
+	}
+
+	err = mergo.Merge(&config, defaultConfig)
+	if err != nil {
+		return nil, err
+	}
+	config.MinCellLevel, config.MaxCellLevel = minCellLevel, maxCellLevel
+
+	config.s2RegionCoverer = s2.RegionCoverer{
+		MinLevel: config.MinCellLevel,
+		MaxLevel: config.MaxCellLevel,
+		MaxCells: config.MaxCells,
+		LevelMod: 0,
+	}
+
+	return &DynGeo{
+		Config: config,
+		db:     newDB(config),
+	}, nil
+}
+
+func (dg DynGeo) PutPoint(ctx context.Context, input PutPointInput) (*PutPointOutput, error) {
+	return dg.db.putPoint(ctx, input)
+}
+
+// BatchWritePoints writes the points in batches of MAX_BATCH_WRITE_ITEMS.
+// Points that could not be written are reported in the output and by a
+// *BatchWriteError. If two inputs are for the same point, nothing is written
+// and the error is ErrDuplicateKey.
+func (dg DynGeo) BatchWritePoints(ctx context.Context, inputs []PutPointInput) (*BatchWritePointOutput, error) {
+	return dg.db.batchWritePoints(ctx, inputs)
+}
+
+// BatchGetPoints reads the points in batches of MAX_BATCH_GET_ITEMS and
+// unmarshals the items found into out, in no particular order. Points that
+// don't exist are skipped. Points that could not be read are reported in the
+// output and by a *BatchPointError, after the other points have been
+// unmarshalled. If two inputs are for the same point, nothing is read and the
+// error is ErrDuplicateKey.
+func (dg DynGeo) BatchGetPoints(ctx context.Context, inputs []PointInput, out interface{}) (*BatchGetPointOutput, error) {
+	items, output, batchErr := dg.db.batchGetPoints(ctx, inputs)
+	if output == nil {
+		return nil, batchErr
+	}
+
+	if err := dg.unmarshallOutput(items, out); err != nil {
+		return nil, err
+	}
+
+	return output, batchErr
+}
+
+// BatchDeletePoints deletes the points in batches of MAX_BATCH_WRITE_ITEMS.
+// Points that could not be deleted are reported in the output and by a
+// *BatchPointError. If two inputs are for the same point, nothing is deleted
+// and the error is ErrDuplicateKey.
+func (dg DynGeo) BatchDeletePoints(ctx context.Context, inputs []PointInput) (*BatchDeletePointOutput, error) {
+	return dg.db.batchDeletePoints(ctx, inputs)
+}
+
+func (dg DynGeo) GetPoint(ctx context.Context, input GetPointInput) (*GetPointOutput, error) {
+	return dg.db.getPoint(ctx, input)
+}
+
+func (dg DynGeo) UpdatePoint(ctx context.Context, input UpdatePointInput) (*UpdatePointOutput, error) {
+	return dg.db.updatePoint(ctx, input)
+}
+
+// TransactWritePoints runs a mix of put, update, delete and condition check
+// operations on points as a single transaction, so either all of them are
+// applied or none. The keys and geo attributes are computed like for
+// PutPoint, UpdatePoint and DeletePoint. Legacy AttributeUpdates are
+// translated into an update expression, legacy Expected conditions are
+// rejected. If DynamoDB cancels the transaction, a *TransactWriteError lists
+// the operations that caused it.
+func (dg DynGeo) TransactWritePoints(ctx context.Context, inputs []TransactWritePointInput) (*TransactWritePointOutput, error) {
+	return dg.db.transactWritePoints(ctx, inputs)
+}
+
+// MovePoint moves a point to NewGeoPoint, recomputing its hash key, geohash
+// and location while keeping all other attributes. Location attributes the
+// point has been written with under other StorageFormats are removed, user
+// attributes of the same names are kept. If the hash key changes, the old
+// item is deleted and the new one written in a single transaction, otherwise
+// the item is updated in place. It returns ErrPointNotFound if there is no
+// point at the old location and ErrPointModified if the point has been moved
+// or deleted concurrently.
+func (dg DynGeo) MovePoint(ctx context.Context, input MovePointInput) (*MovePointOutput, error) {
+	return dg.db.movePoint(ctx, input)
+}
+
+func (dg DynGeo) DeletePoint(ctx context.Context, input DeletePointInput) (*DeletePointOutput, error) {
+	return dg.db.deletePoint(ctx, input)
+}
+
+func (dg DynGeo) QueryRadius(ctx context.Context, input QueryRadiusInput, out interface{}) error {
+	q, err := input.query(dg.Config)
+	if err != nil {
+		return err
+	}
+
+	return dg.query(ctx, q, input.GeoQueryInput, out)
+}
+
+func (dg DynGeo) QueryRectangle(ctx context.Context, input QueryRectangleInput, out interface{}) error {
+	q, err := input.query(dg.Config)
+	if err != nil {
+		return err
+	}
+
+	return dg.query(ctx, q, input.GeoQueryInput, out)
+}
+
+// QueryRadiusPage is like QueryRadius, but returns at most input.Limit
+// results and a token to continue with in the output.
+func (dg DynGeo) QueryRadiusPage(ctx context.Context, input QueryRadiusInput, out interface{}) (*QueryRadiusOutput, error) {
+	q, err := input.pageQuery(dg.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := dg.queryPage(ctx, q, input.GeoQueryInput, input.PageInput, out)
+	if output == nil {
+		return nil, err
+	}
+
+	return &QueryRadiusOutput{output}, err
+}
+
+// QueryRectanglePage is like QueryRectangle, but returns at most input.Limit
+// results and a token to continue with in the output.
+func (dg DynGeo) QueryRectanglePage(ctx context.Context, input QueryRectangleInput, out interface{}) (*QueryRectangleOutput, error) {
+	q, err := input.pageQuery(dg.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := dg.queryPage(ctx, q, input.GeoQueryInput, input.PageInput, out)
+	if output == nil {
+		return nil, err
+	}
+
+	return &QueryRectangleOutput{output}, err
+}
+
+// QueryRadiusIter streams the results of a radius query. Results are not
+// sorted across pages.
+func (dg DynGeo) QueryRadiusIter(ctx context.Context, input QueryRadiusInput) *QueryIterator {
+	q, err := input.query(dg.Config)
+	if err != nil {
+		return &QueryIterator{geo.FailedIterator[types.AttributeValue](err)}
+	}
+
+	return &QueryIterator{dg.Config.engine().Iterate(ctx, q, dg.db.request(input.GeoQueryInput))}
+}
+
+// QueryRectangleIter streams the results of a rectangle query. Results are
+// not sorted across pages.
+func (dg DynGeo) QueryRectangleIter(ctx context.Context, input QueryRectangleInput) *QueryIterator {
+	q, err := input.query(dg.Config)
+	if err != nil {
+		return &QueryIterator{geo.FailedIterator[types.AttributeValue](err)}
+	}
+
+	return &QueryIterator{dg.Config.engine().Iterate(ctx, q, dg.db.request(input.GeoQueryInput))}
+}
+
+func (dg DynGeo) QuerySector(ctx context.Context, input QuerySectorInput, out interface{}) error {
+	q, err := input.query(dg.Config)
+	if err != nil {
+		return err
+	}
+
+	return dg.query(ctx, q, input.GeoQueryInput, out)
+}
+
+func (dg DynGeo) QueryPolygon(ctx context.Context, input QueryPolygonInput, out interface{}) error {
+	q, err := input.query()
+	if err != nil {
+		return err
+	}
+
+	return dg.query(ctx, q, input.GeoQueryInput, out)
+}
+
+func (dg DynGeo) QueryCorridor(ctx context.Context, input QueryCorridorInput, out interface{}) (*QueryCorridorOutput, error) {
+	q, err := input.query(dg.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	req := dg.db.request(input.GeoQueryInput)
+	items, distances, queryErr := dg.Config.engine().Corridor(ctx, q, req)
+	if req.Failed(queryErr) {
+		return nil, queryErr
+	}
+
+	if err := dg.unmarshallOutput(items, out); err != nil {
+		return nil, err
+	}
+
+	return &QueryCorridorOutput{RouteDistances: distances}, queryErr
+}
+
+func (dg DynGeo) QueryNearest(ctx context.Context, input QueryNearestInput, out interface{}) error {
+	q, err := input.query(dg.Config)
+	if err != nil {
+		return err
+	}
+
+	req := dg.db.request(input.GeoQueryInput)
+	items, queryErr := dg.Config.engine().Nearest(ctx, q, req)
+	if req.Failed(queryErr) {
+		return queryErr
+	}
+
+	if err := dg.unmarshallOutput(items, out); err != nil {
+		return err
+	}
+
+	return queryErr
+}
+
+// query runs the query of the shape and unmarshals its results into out.
+// With AllowPartialResults, failed ranges are reported by a *QueryError after
+// the other results have been unmarshalled.
+func (dg DynGeo) query(ctx context.Context, q geo.Shape, input GeoQueryInput, out interface{}) error {
+	req := dg.db.request(input)
+	items, queryErr := dg.Config.engine().Query(ctx, q, req)
+	if req.Failed(queryErr) {
+		return queryErr
+	}
+
+	if err := dg.unmarshallOutput(items, out); err != nil {
+		return err
+	}
+
+	return queryErr
+}
+
+// queryPage reads a page of the results of the query and unmarshals them into
+// out.
+func (dg DynGeo) queryPage(ctx context.Context, q geo.Shape, input GeoQueryInput, page PageInput, out interface{}) (*GeoQueryOutput, error) {
+	req := dg.db.request(input)
+	items, summary, queryErr := dg.Config.engine().QueryPage(ctx, q, req, page)
+	if req.Failed(queryErr) {
+		return nil, queryErr
+	}
+
+	if err := dg.unmarshallOutput(items, out); err != nil {
+		return nil, err
+	}
+
+	return &GeoQueryOutput{
+		QueryOutput: &dynamodb.QueryOutput{
+			Count:            int32(summary.Count),
+			ScannedCount:     int32(summary.ScannedCount),
+			ConsumedCapacity: dg.consumedCapacity(summary.CapacityUnits),
+		},
+		ContinuationToken: summary.ContinuationToken,
+	}, queryErr
+}
+
+// consumedCapacity returns the capacity units a query consumed on the table.
+func (dg DynGeo) consumedCapacity(capacityUnits float64) *types.ConsumedCapacity {
+	return &types.ConsumedCapacity{
+		TableName:     aws.String(dg.Config.TableName),
+		CapacityUnits: aws.Float64(capacityUnits),
+	}
+}
+
+// itemReceiver is implemented by query outputs that unmarshal the items
+// themselves, like the results of a Collection.
+type itemReceiver interface {
+	receive(items []map[string]types.AttributeValue) error
+}
+
+func (dg DynGeo) unmarshallOutput(output []map[string]types.AttributeValue, out interface{}) error {
+	if receiver, ok := out.(itemReceiver); ok {
+		return receiver.receive(output)
+	}
+
+	return attributevalue.UnmarshalListOfMaps(output, out)
+}
@@ -0,0 +1,489 @@
+package dyngeov2
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/golang/geo/s2"
+)
+
+// fakeDynamoDB keeps items in memory and answers queries on the geohash index.
+// Queries of hash keys in failHashKeys fail.
+type fakeDynamoDB struct {
+	DynamoDBAPI
+
+	config       DynGeoConfig
+	mtx          sync.Mutex
+	items        []map[string]types.AttributeValue
+	failHashKeys map[uint64]bool
+}
+
+func (f *fakeDynamoDB) PutItem(ctx context.Context, input *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	f.items = append(f.items, input.Item)
+
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func numberOf(av types.AttributeValue) string {
+	return av.(*types.AttributeValueMemberN).Value
+}
+
+// keyOf identifies the item by its hash and string or number range key.
+func (f *fakeDynamoDB) keyOf(item map[string]types.AttributeValue) string {
+	switch rangeKey := item[f.config.RangeKeyAttributeName].(type) {
+	case *types.AttributeValueMemberS:
+		return numberOf(item[f.config.HashKeyAttributeName]) + "/" + rangeKey.Value
+	case *types.AttributeValueMemberN:
+		return numberOf(item[f.config.HashKeyAttributeName]) + "/" + rangeKey.Value
+	}
+
+	return ""
+}
+
+func (f *fakeDynamoDB) GetItem(ctx context.Context, input *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	for _, item := range f.items {
+		if f.keyOf(item) == f.keyOf(input.Key) {
+			return &dynamodb.GetItemOutput{Item: item}, nil
+		}
+	}
+
+	return &dynamodb.GetItemOutput{}, nil
+}
+
+func (f *fakeDynamoDB) Query(ctx context.Context, input *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	hashKey := numberOf(input.KeyConditions[f.config.HashKeyAttributeName].AttributeValueList[0])
+	between := input.KeyConditions[f.config.GeoHashAttributeName].AttributeValueList
+	min, _ := strconv.ParseUint(numberOf(between[0]), 10, 64)
+	max, _ := strconv.ParseUint(numberOf(between[1]), 10, 64)
+
+	if h, _ := strconv.ParseUint(hashKey, 10, 64); f.failHashKeys[h] {
+		return nil, errors.New("throttled")
+	}
+
+	output := &dynamodb.QueryOutput{}
+	for _, item := range f.items {
+		geoHash, _ := strconv.ParseUint(numberOf(item[f.config.GeoHashAttributeName]), 10, 64)
+		if numberOf(item[f.config.HashKeyAttributeName]) == hashKey && geoHash >= min && geoHash <= max {
+			// like DynamoDB, every query returns new items
+			found := map[string]types.AttributeValue{}
+			for name, value := range item {
+				found[name] = value
+			}
+			output.Items = append(output.Items, found)
+		}
+	}
+	output.Count = int32(len(output.Items))
+
+	return output, nil
+}
+
+func newFakeDynGeo(t *testing.T, config DynGeoConfig) (*DynGeo, *fakeDynamoDB) {
+	fake := &fakeDynamoDB{failHashKeys: map[uint64]bool{}}
+	config.DynamoDBClient = fake
+	config.TableName = "test"
+
+	dg, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.config = dg.Config
+
+	return dg, fake
+}
+
+func TestNewLevels(t *testing.T) {
+	dg, _ := newFakeDynGeo(t, DynGeoConfig{HashKeyScheme: ParentCellHashKey, HashKeyCellLevel: aws.Int(0), MaxCellLevel: 5})
+	if *dg.Config.HashKeyCellLevel != 0 || dg.db.codec.Hasher.CellLevel != 0 {
+		t.Errorf("got hash key cell level %d, want 0", dg.db.codec.Hasher.CellLevel)
+	}
+	if dg.Config.MinCellLevel != 0 || dg.Config.MaxCellLevel != 5 {
+		t.Errorf("got cell levels %d to %d, want 0 to 5", dg.Config.MinCellLevel, dg.Config.MaxCellLevel)
+	}
+
+	for _, config := range []DynGeoConfig{{HashKeyCellLevel: aws.Int(-1)}, {HashKeyScheme: ParentCellHashKey}, {MinCellLevel: -1}, {MinCellLevel: 12, MaxCellLevel: 11}} {
+		config.TableName = "test"
+		config.DynamoDBClient = &fakeDynamoDB{}
+		if _, err := New(config); err == nil {
+			t.Errorf("%+v: got no error", config)
+		}
+	}
+}
+
+func TestQueryRadiusFakeClient(t *testing.T) {
+	dg, fake := newFakeDynGeo(t, DynGeoConfig{HashKeyScheme: ParentCellHashKey, HashKeyCellLevel: aws.Int(14)})
+	ctx := context.Background()
+
+	center := GeoPoint{Latitude: 40.7769099, Longitude: -73.9822532}
+	points := map[string]GeoPoint{
+		"central park":  {Latitude: 40.7812, Longitude: -73.9665},
+		"times square":  {Latitude: 40.7580, Longitude: -73.9855},
+		"brooklyn":      {Latitude: 40.6782, Longitude: -73.9442},
+		"jersey city":   {Latitude: 40.7178, Longitude: -74.0431},
+		"philadelphia":  {Latitude: 39.9526, Longitude: -75.1652},
+		"lincoln plaza": {Latitude: 40.7725, Longitude: -73.9835},
+	}
+	for name, p := range points {
+		input := PutPointInput{PointInput: PointInput{RangeKeyValue: RangeKeyFromString(name), GeoPoint: p}}
+		input.PutItemInput.Item = map[string]types.AttributeValue{"name": &types.AttributeValueMemberS{Value: name}}
+		if _, err := dg.PutPoint(ctx, input); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type result struct {
+		Name     string  `dynamodbav:"name"`
+		Distance float64 `dynamodbav:"distance"`
+	}
+
+	input := QueryRadiusInput{CenterPoint: center, RadiusInMeter: 5000}
+	input.DistanceAttributeName = "distance"
+	input.SortByDistance = SortAscending
+	results := []result{}
+	if err := dg.QueryRadius(ctx, input, &results); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"lincoln plaza", "central park", "times square"}
+	if len(results) != len(want) {
+		t.Fatalf("found %v, want %v", results, want)
+	}
+	for i, r := range results {
+		if r.Name != want[i] {
+			t.Errorf("result %d: got %s, want %s", i, r.Name, want[i])
+		}
+		if r.Distance <= 0 || r.Distance > 5000 {
+			t.Errorf("%s: distance %f not within the radius", r.Name, r.Distance)
+		}
+	}
+
+	// fail the hash key of times square
+	_, hashKey := dg.db.codec.Hasher.Hashes(points["times square"].LatLng())
+	fake.failHashKeys[hashKey] = true
+
+	err := dg.QueryRadius(ctx, input, &results)
+	var queryErr *QueryError
+	if !errors.As(err, &queryErr) || len(queryErr.Failures) == 0 {
+		t.Fatalf("got error %v, want a *QueryError", err)
+	}
+
+	input.AllowPartialResults = true
+	results = []result{}
+	err = dg.QueryRadius(ctx, input, &results)
+	if !errors.As(err, &queryErr) {
+		t.Fatalf("got error %v, want a *QueryError", err)
+	}
+	for _, r := range results {
+		if r.Name == "times square" {
+			t.Errorf("%s found in a failed range", r.Name)
+		}
+	}
+	if len(results) == 0 {
+		t.Error("no partial results")
+	}
+}
+
+func TestCollectionFakeClient(t *testing.T) {
+	type store struct {
+		ID       int64   `dyngeo:"id"`
+		Lat      float64 `dyngeo:"lat"`
+		Lng      float64 `dyngeo:"lng"`
+		Distance float64 `dyngeo:"distance" dynamodbav:"distance"`
+		Name     string  `dynamodbav:"name"`
+	}
+
+	dg, fake := newFakeDynGeo(t, DynGeoConfig{RangeKeyType: NumberRangeKey})
+	ctx := context.Background()
+
+	stores, err := NewCollection[store](dg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []store{
+		{ID: 1, Lat: 40.7812, Lng: -73.9665, Name: "central park"},
+		{ID: 2, Lat: 40.7580, Lng: -73.9855, Name: "times square"},
+		{ID: 3, Lat: 39.9526, Lng: -75.1652, Name: "philadelphia"},
+	} {
+		if err := stores.Put(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if rangeKey := fake.items[0][dg.Config.RangeKeyAttributeName]; numberOf(rangeKey) != "1" {
+		t.Errorf("got range key %v, want the number 1", rangeKey)
+	}
+
+	got, err := stores.Get(ctx, store{ID: 2, Lat: 40.7580, Lng: -73.9855})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != (store{ID: 2, Lat: 40.7580, Lng: -73.9855, Name: "times square"}) {
+		t.Errorf("got %+v, want times square", got)
+	}
+	if _, err := stores.Get(ctx, store{ID: 4, Lat: 40.7580, Lng: -73.9855}); !errors.Is(err, ErrPointNotFound) {
+		t.Errorf("got error %v, want ErrPointNotFound", err)
+	}
+
+	input := QueryRadiusInput{CenterPoint: GeoPoint{Latitude: 40.7769099, Longitude: -73.9822532}, RadiusInMeter: 5000}
+	input.SortByDistance = SortAscending
+	results, err := stores.QueryRadius(ctx, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].ID != 1 || results[1].ID != 2 {
+		t.Fatalf("got %+v, want central park and times square", results)
+	}
+	for _, r := range results {
+		if r.Distance <= 0 || r.Distance > 5000 || r.Lat == 0 || r.Lng == 0 {
+			t.Errorf("%s: got distance %f at %f, %f", r.Name, r.Distance, r.Lat, r.Lng)
+		}
+	}
+
+	minPoint := GeoPoint{Latitude: 40.75, Longitude: -73.99}
+	maxPoint := GeoPoint{Latitude: 40.79, Longitude: -73.96}
+	iterators := map[string]*CollectionIterator[store]{
+		"radius":    stores.QueryRadiusIter(ctx, input),
+		"rectangle": stores.QueryRectangleIter(ctx, QueryRectangleInput{MinPoint: &minPoint, MaxPoint: &maxPoint}),
+	}
+	for name, it := range iterators {
+		streamed := map[int64]store{}
+		for it.Next() {
+			streamed[it.Item().ID] = it.Item()
+		}
+		it.Close()
+		if err := it.Err(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(streamed) != 2 || streamed[1].Name != "central park" || streamed[2].Name != "times square" {
+			t.Errorf("%s: got %+v, want central park and times square", name, streamed)
+		}
+		for _, r := range streamed {
+			if r.Distance <= 0 || r.Lat == 0 || r.Lng == 0 {
+				t.Errorf("%s: got distance %f at %f, %f for %s", name, r.Distance, r.Lat, r.Lng, r.Name)
+			}
+		}
+	}
+}
+
+func TestValidationFakeClient(t *testing.T) {
+	dg, fake := newFakeDynGeo(t, DynGeoConfig{})
+	ctx := context.Background()
+	center := GeoPoint{Latitude: 40.7769099, Longitude: -73.9822532}
+	out := &[]map[string]interface{}{}
+	point := func(p GeoPoint) PointInput {
+		return PointInput{RangeKeyValue: RangeKeyFromString("point"), GeoPoint: p}
+	}
+
+	// every entry point taking a location, called with p in its place
+	entryPoints := map[string]func(p GeoPoint) error{
+		"PutPoint": func(p GeoPoint) error {
+			_, err := dg.PutPoint(ctx, PutPointInput{PointInput: point(p)})
+			return err
+		},
+		"GetPoint": func(p GeoPoint) error {
+			_, err := dg.GetPoint(ctx, GetPointInput{PointInput: point(p)})
+			return err
+		},
+		"UpdatePoint": func(p GeoPoint) error {
+			_, err := dg.UpdatePoint(ctx, UpdatePointInput{PointInput: point(p)})
+			return err
+		},
+		"DeletePoint": func(p GeoPoint) error {
+			_, err := dg.DeletePoint(ctx, DeletePointInput{PointInput: point(p)})
+			return err
+		},
+		"BatchWritePoints": func(p GeoPoint) error {
+			_, err := dg.BatchWritePoints(ctx, []PutPointInput{{PointInput: point(center)}, {PointInput: point(p)}})
+			return err
+		},
+		"BatchGetPoints": func(p GeoPoint) error {
+			_, err := dg.BatchGetPoints(ctx, []PointInput{point(p)}, out)
+			return err
+		},
+		"BatchDeletePoints": func(p GeoPoint) error {
+			_, err := dg.BatchDeletePoints(ctx, []PointInput{point(p)})
+			return err
+		},
+		"TransactWritePoints": func(p GeoPoint) error {
+			_, err := dg.TransactWritePoints(ctx, []TransactWritePointInput{{Put: &PutPointInput{PointInput: point(p)}}})
+			return err
+		},
+		"MovePoint from": func(p GeoPoint) error {
+			_, err := dg.MovePoint(ctx, MovePointInput{PointInput: point(p), NewGeoPoint: center})
+			return err
+		},
+		"MovePoint to": func(p GeoPoint) error {
+			_, err := dg.MovePoint(ctx, MovePointInput{PointInput: point(center), NewGeoPoint: p})
+			return err
+		},
+		"QueryRadius": func(p GeoPoint) error {
+			return dg.QueryRadius(ctx, QueryRadiusInput{CenterPoint: p, RadiusInMeter: 100}, out)
+		},
+		"QueryRadiusPage": func(p GeoPoint) error {
+			_, err := dg.QueryRadiusPage(ctx, QueryRadiusInput{CenterPoint: p, RadiusInMeter: 100}, out)
+			return err
+		},
+		"QueryRadiusIter": func(p GeoPoint) error {
+			return dg.QueryRadiusIter(ctx, QueryRadiusInput{CenterPoint: p, RadiusInMeter: 100}).Err()
+		},
+		"CountRadius": func(p GeoPoint) error {
+			_, err := dg.CountRadius(ctx, QueryRadiusInput{CenterPoint: p, RadiusInMeter: 100})
+			return err
+		},
+		"QueryRectangle": func(p GeoPoint) error {
+			return dg.QueryRectangle(ctx, QueryRectangleInput{MinPoint: &p, MaxPoint: &center}, out)
+		},
+		"QueryRectanglePage": func(p GeoPoint) error {
+			_, err := dg.QueryRectanglePage(ctx, QueryRectangleInput{MinPoint: &center, MaxPoint: &p}, out)
+			return err
+		},
+		"QueryRectangleIter": func(p GeoPoint) error {
+			return dg.QueryRectangleIter(ctx, QueryRectangleInput{MinPoint: &p, MaxPoint: &center}).Err()
+		},
+		"CountRectangle": func(p GeoPoint) error {
+			_, err := dg.CountRectangle(ctx, QueryRectangleInput{MinPoint: &center, MaxPoint: &p})
+			return err
+		},
+		"QuerySector": func(p GeoPoint) error {
+			return dg.QuerySector(ctx, QuerySectorInput{CenterPoint: p, RadiusInMeter: 100, WidthInDegree: 90}, out)
+		},
+		"QueryCorridor": func(p GeoPoint) error {
+			_, err := dg.QueryCorridor(ctx, QueryCorridorInput{Polyline: []GeoPoint{center, p}}, out)
+			return err
+		},
+		"QueryNearest": func(p GeoPoint) error {
+			return dg.QueryNearest(ctx, QueryNearestInput{CenterPoint: p, K: 1}, out)
+		},
+	}
+	invalid := map[string]GeoPoint{
+		"NaN latitude":         {Latitude: math.NaN()},
+		"NaN longitude":        {Longitude: math.NaN()},
+		"latitude above 90":    {Latitude: 91},
+		"longitude below -180": {Longitude: -180.5},
+	}
+	for name, entryPoint := range entryPoints {
+		for invalidName, p := range invalid {
+			if err := entryPoint(p); !errors.Is(err, ErrInvalidCoordinate) {
+				t.Errorf("%s with %s: got error %v, want ErrInvalidCoordinate", name, invalidName, err)
+			}
+		}
+	}
+
+	invalidRangeKey := map[string]error{
+		"empty range key": func() error {
+			_, err := dg.PutPoint(ctx, PutPointInput{PointInput: PointInput{GeoPoint: center}})
+			return err
+		}(),
+		"number range key in a string table": func() error {
+			_, err := dg.DeletePoint(ctx, DeletePointInput{PointInput: PointInput{RangeKeyValue: RangeKeyFromFloat(1.5), GeoPoint: center}})
+			return err
+		}(),
+	}
+	for name, err := range invalidRangeKey {
+		if !errors.Is(err, ErrInvalidRangeKey) {
+			t.Errorf("%s: got error %v, want ErrInvalidRangeKey", name, err)
+		}
+	}
+
+	invalidShape := map[string]error{
+		"sector with infinite heading": dg.QuerySector(ctx, QuerySectorInput{CenterPoint: center, RadiusInMeter: 100, HeadingInDegree: math.Inf(1), WidthInDegree: 90}, out),
+		"sector with NaN width":        dg.QuerySector(ctx, QuerySectorInput{CenterPoint: center, RadiusInMeter: 100, WidthInDegree: math.NaN()}, out),
+		"polygon with two vertices": dg.QueryPolygon(ctx, QueryPolygonInput{Polygon: s2.PolygonFromLoops([]*s2.Loop{s2.LoopFromPoints([]s2.Point{
+			s2.PointFromLatLng(center.LatLng()),
+			s2.PointFromLatLng(s2.LatLngFromDegrees(40.8, -73.9)),
+		})})}, out),
+	}
+	for name, err := range invalidShape {
+		if !errors.Is(err, ErrInvalidShape) {
+			t.Errorf("%s: got error %v, want ErrInvalidShape", name, err)
+		}
+	}
+
+	missingBounds := map[string]error{
+		"rectangle without MaxPoint":          dg.QueryRectangle(ctx, QueryRectangleInput{MinPoint: &center}, out),
+		"rectangle iterator without MinPoint": dg.QueryRectangleIter(ctx, QueryRectangleInput{MaxPoint: &center}).Err(),
+		"polygon without shape":               dg.QueryPolygon(ctx, QueryPolygonInput{}, out),
+		"count polygon without shape": func() error {
+			_, err := dg.CountPolygon(ctx, QueryPolygonInput{})
+			return err
+		}(),
+		"corridor with a single point": func() error {
+			_, err := dg.QueryCorridor(ctx, QueryCorridorInput{Polyline: []GeoPoint{center}}, out)
+			return err
+		}(),
+	}
+	for name, err := range missingBounds {
+		if !errors.Is(err, ErrMissingBounds) {
+			t.Errorf("%s: got error %v, want ErrMissingBounds", name, err)
+		}
+	}
+	if len(fake.items) != 0 {
+		t.Errorf("got %d items, want none written", len(fake.items))
+	}
+}
+
+func TestStorageFormatsFakeClient(t *testing.T) {
+	type store struct {
+		ID  string  `dyngeo:"id"`
+		Lat float64 `dyngeo:"lat"`
+		Lng float64 `dyngeo:"lng"`
+	}
+
+	legacy, fake := newFakeDynGeo(t, DynGeoConfig{})
+	dg, err := New(DynGeoConfig{TableName: "test", DynamoDBClient: fake, StorageFormat: GeoJSONMapStorage | LatLngStorage})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	input := PutPointInput{PointInput: PointInput{RangeKeyValue: RangeKeyFromString("times square"), GeoPoint: GeoPoint{Latitude: 40.7580, Longitude: -73.9855}}}
+	if _, err := legacy.PutPoint(ctx, input); err != nil {
+		t.Fatal(err)
+	}
+	stores, err := NewCollection[store](dg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	central := store{ID: "central park", Lat: 40.7812, Lng: -73.9665}
+	if err := stores.Put(ctx, central); err != nil {
+		t.Fatal(err)
+	}
+
+	item := fake.items[1]
+	if _, ok := item[dg.Config.GeoJSONAttributeName].(*types.AttributeValueMemberM); !ok {
+		t.Errorf("got GeoJSON %T, want a map", item[dg.Config.GeoJSONAttributeName])
+	}
+	if numberOf(item[dg.Config.LatitudeAttributeName]) != "40.7812" || numberOf(item[dg.Config.LongitudeAttributeName]) != "-73.9665" {
+		t.Errorf("got lat/lng attributes %v, %v", item[dg.Config.LatitudeAttributeName], item[dg.Config.LongitudeAttributeName])
+	}
+
+	got, err := stores.Get(ctx, central)
+	if err != nil || got != central {
+		t.Errorf("got %+v, error %v, want %+v", got, err, central)
+	}
+
+	results, err := stores.QueryRadius(ctx, QueryRadiusInput{CenterPoint: GeoPoint{Latitude: 40.7769099, Longitude: -73.9822532}, RadiusInMeter: 5000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[store]bool{}
+	for _, r := range results {
+		found[r] = true
+	}
+	if len(found) != 2 || !found[central] || !found[store{ID: "times square", Lat: 40.7580, Lng: -73.9855}] {
+		t.Errorf("got %+v, want central park and the legacy times square", results)
+	}
+}
@@ -0,0 +1,94 @@
+package dyngeov2
+
+import "github.com/crolly/dyngeo/internal/geo"
+
+// RangeError is the error of querying a single geohash range.
+type RangeError = geo.RangeError
+
+// QueryError is returned by geo queries when querying some of their geohash
+// ranges failed, e.g. because DynamoDB throttled the requests. Failures lists
+// every failed range.
+//
+// With GeoQueryInput.AllowPartialResults the query still returns the results
+// of all other ranges together with the QueryError.
+type QueryError = geo.QueryError
+
+// ErrPointNotFound is returned by MovePoint if there is no point at the old
+// location and by the Get and Update of a Collection if the point doesn't
+// exist.
+var ErrPointNotFound = geo.ErrPointNotFound
+
+// ErrPointModified is returned by MovePoint if the point has been moved or
+// deleted while it was moved. Nothing has been written, the move can be
+// retried.
+var ErrPointModified = geo.ErrPointModified
+
+// ErrInvalidCoordinate is returned when a latitude is outside ±90, a
+// longitude outside ±180 or a coordinate is NaN or infinite. With
+// NormalizeLongitude, longitudes outside ±180 are wrapped instead.
+var ErrInvalidCoordinate = geo.ErrInvalidCoordinate
+
+// ErrInvalidRangeKey is returned for points whose RangeKeyValue is empty or
+// doesn't match the configured RangeKeyType.
+var ErrInvalidRangeKey = geo.ErrInvalidRangeKey
+
+// ErrInvalidRadius is returned by queries whose radius, minimum radius, buffer
+// or maximum distance is negative or, for radiuses, not greater than 0.
+var ErrInvalidRadius = geo.ErrInvalidRadius
+
+// ErrInvalidShape is returned by sector queries whose HeadingInDegree or
+// WidthInDegree is NaN or infinite or whose width is not greater than 0, and
+// by polygon queries whose GeoJSON or Polygon is not a valid polygon.
+var ErrInvalidShape = geo.ErrInvalidShape
+
+// ErrMissingBounds is returned by rectangle queries without MinPoint or
+// MaxPoint, polygon queries without GeoJSON or Polygon and corridor queries
+// with less than 2 points.
+var ErrMissingBounds = geo.ErrMissingBounds
+
+// ErrPageInput is returned by the queries reading all results at once, e.g.
+// QueryRadius, QueryRadiusIter or CountRadius, if the input sets Limit or
+// ContinuationToken. Use QueryRadiusPage or QueryRectanglePage to read the
+// results page by page.
+var ErrPageInput = geo.ErrPageInput
+
+// ErrRegionTooLarge is returned by queries with AdaptiveCovering whose region
+// spans more than MAX_ADAPTIVE_CELLS hash keys, each of which would need a
+// query.
+var ErrRegionTooLarge = geo.ErrRegionTooLarge
+
+// ErrUnprocessed is the error of points DynamoDB still left unprocessed after
+// MAX_BATCH_RETRIES retries of a batch request, usually because the table is
+// throttled.
+var ErrUnprocessed = geo.ErrUnprocessed
+
+// ErrDuplicateKey is returned by batch operations whose inputs contain the
+// same point twice, which DynamoDB rejects for the whole request. The error
+// names the positions of both inputs.
+var ErrDuplicateKey = geo.ErrDuplicateKey
+
+// BatchWriteFailure is a point BatchWritePoints could not write.
+type BatchWriteFailure = geo.InputFailure[PutPointInput]
+
+// BatchWriteError is returned by BatchWritePoints when some points could not
+// be written. All other points have been written.
+type BatchWriteError = geo.BatchError[PutPointInput]
+
+// BatchPointFailure is a point BatchGetPoints or BatchDeletePoints could not
+// read or delete.
+type BatchPointFailure = geo.InputFailure[PointInput]
+
+// BatchPointError is returned by BatchGetPoints and BatchDeletePoints when
+// some points could not be read or deleted. All other points have been
+// processed.
+type BatchPointError = geo.BatchError[PointInput]
+
+// TransactWriteFailure is an operation that caused TransactWritePoints to be
+// canceled. Index is its position in the inputs, Code and Message hold the
+// cancellation reason reported by DynamoDB, e.g. ConditionalCheckFailed.
+type TransactWriteFailure = geo.TransactFailure[TransactWritePointInput]
+
+// TransactWriteError is returned by TransactWritePoints when DynamoDB canceled
+// the transaction. None of the operations have been applied. Err is the
+// original error of the AWS SDK.
+type TransactWriteError = geo.TransactError[TransactWritePointInput]
@@ -0,0 +1,348 @@
+package dyngeov2
+
+import (
+	"encoding/json"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/crolly/dyngeo/internal/geo"
+	"github.com/golang/geo/s2"
+)
+
+// GeoPoint is a location in degrees.
+type GeoPoint = geo.GeoPoint
+
+// GeoJSONAttribute is the GeoJSON Point stored with every item.
+type GeoJSONAttribute = geo.GeoJSONAttribute
+
+// RangeKey is the value of a range key, either a string or a number. Use
+// RangeKeyFromString, RangeKeyFromInt, RangeKeyFromFloat or RangeKeyFromUUID
+// to create one.
+type RangeKey = geo.RangeKey
+
+// RangeKeyFromString returns a string range key, e.g. a business ID or a
+// composite "tenant#id" key.
+func RangeKeyFromString(s string) RangeKey {
+	return geo.RangeKeyFromString(s)
+}
+
+// RangeKeyFromInt returns a number range key, e.g. a timestamp.
+func RangeKeyFromInt(n int64) RangeKey {
+	return geo.RangeKeyFromInt(n)
+}
+
+// RangeKeyFromFloat returns a number range key.
+func RangeKeyFromFloat(f float64) RangeKey {
+	return geo.RangeKeyFromFloat(f)
+}
+
+// RangeKeyFromUUID returns a string range key holding the UUID, the range key
+// of points written by earlier versions.
+func RangeKeyFromUUID(u uuid.UUID) RangeKey {
+	return geo.RangeKeyFromUUID(u)
+}
+
+// PointInput identifies a point by its range key and location.
+type PointInput = geo.PointInput
+
+// SortOrder defines how query results are ordered by their distance to the
+// query center.
+type SortOrder = geo.SortOrder
+
+const (
+	// SortNone keeps the order the query found the results in.
+	SortNone       = geo.SortNone
+	SortAscending  = geo.SortAscending
+	SortDescending = geo.SortDescending
+)
+
+// GeoQueryInput holds the options shared by all queries.
+// DistanceAttributeName and BearingAttributeName optionally name the
+// attributes the distance in meters and the initial bearing in degrees from
+// the query center are injected into on every result, so they can be
+// unmarshalled along with the item. SortByDistance orders the results by that
+// distance. The query center is the center point for radius and nearest
+// queries, the center of the bounding rectangle for rectangle and polygon
+// queries and the start of the route for corridor queries.
+type GeoQueryInput struct {
+	QueryInput            dynamodb.QueryInput
+	DistanceAttributeName string
+	BearingAttributeName  string
+	SortByDistance        SortOrder
+	Stats                 *QueryStats
+	AllowPartialResults   bool
+	MaxConcurrency        int
+}
+
+// options returns the options of the query that don't depend on the SDK.
+func (input GeoQueryInput) options() geo.Options {
+	return geo.Options{
+		DistanceAttributeName: input.DistanceAttributeName,
+		BearingAttributeName:  input.BearingAttributeName,
+		SortByDistance:        input.SortByDistance,
+		Stats:                 input.Stats,
+		AllowPartialResults:   input.AllowPartialResults,
+		MaxConcurrency:        input.MaxConcurrency,
+	}
+}
+
+// QueryStats reports how many DynamoDB queries a geo query issued for its
+// geohash ranges and how many were saved by merging adjacent ranges.
+type QueryStats = geo.QueryStats
+
+// GeoQueryOutput summarizes a query page. ContinuationToken is empty once
+// all results have been read.
+type GeoQueryOutput struct {
+	*dynamodb.QueryOutput
+	ContinuationToken string
+}
+
+// PageInput limits paged queries to Limit results per page.
+// ContinuationToken resumes the query where the previous page ended.
+type PageInput = geo.PageInput
+
+// BatchWritePointOutput reports the points BatchWritePoints could not write
+// in Failures, in the order of the inputs. UnprocessedItems holds their write
+// requests, so they can be retried later.
+type BatchWritePointOutput struct {
+	*dynamodb.BatchWriteItemOutput
+	Failures []BatchWriteFailure
+}
+
+// BatchGetPointOutput reports the points BatchGetPoints could not read in
+// Failures, in the order of the inputs.
+type BatchGetPointOutput struct {
+	Failures []BatchPointFailure
+}
+
+// BatchDeletePointOutput reports the points BatchDeletePoints could not delete
+// in Failures, in the order of the inputs. UnprocessedItems holds their delete
+// requests, so they can be retried later.
+type BatchDeletePointOutput struct {
+	*dynamodb.BatchWriteItemOutput
+	Failures []BatchPointFailure
+}
+
+type DeletePointInput struct {
+	PointInput
+	DeleteItemInput dynamodb.DeleteItemInput
+}
+
+type DeletePointOutput struct {
+	*dynamodb.DeleteItemOutput
+}
+
+type GetPointInput struct {
+	PointInput
+	GetItemInput dynamodb.GetItemInput
+}
+
+type GetPointOutput struct {
+	*dynamodb.GetItemOutput
+}
+
+type PutPointInput struct {
+	PointInput
+	PutItemInput dynamodb.PutItemInput
+}
+
+type PutPointOutput struct {
+	*dynamodb.PutItemOutput
+}
+
+type UpdatePointInput struct {
+	PointInput
+	UpdateItemInput dynamodb.UpdateItemInput
+}
+
+type UpdatePointOutput struct {
+	*dynamodb.UpdateItemOutput
+}
+
+// ConditionCheckPointInput checks the condition of ConditionCheck on the point
+// within a transaction, without changing the point.
+type ConditionCheckPointInput struct {
+	PointInput
+	ConditionCheck types.ConditionCheck
+}
+
+// TransactWritePointInput is a single operation of TransactWritePoints.
+// Exactly one of Put, Update, Delete and ConditionCheck has to be set.
+type TransactWritePointInput struct {
+	Put            *PutPointInput
+	Update         *UpdatePointInput
+	Delete         *DeletePointInput
+	ConditionCheck *ConditionCheckPointInput
+}
+
+type TransactWritePointOutput struct {
+	*dynamodb.TransactWriteItemsOutput
+}
+
+// MovePointInput moves the point identified by RangeKeyValue from GeoPoint to
+// NewGeoPoint.
+type MovePointInput struct {
+	PointInput
+	NewGeoPoint GeoPoint
+}
+
+// MovePointOutput holds the output of the transaction moving the point to
+// another hash key, or of the update if it stays within its hash key.
+type MovePointOutput struct {
+	TransactWriteItemsOutput *dynamodb.TransactWriteItemsOutput
+	UpdateItemOutput         *dynamodb.UpdateItemOutput
+}
+
+// QueryRadiusInput defines a query for all points within RadiusInMeter of
+// CenterPoint. Setting MinRadiusInMeter turns the circle into a ring and
+// excludes points closer than that.
+type QueryRadiusInput struct {
+	GeoQueryInput
+	PageInput
+	CenterPoint      GeoPoint
+	RadiusInMeter    int
+	MinRadiusInMeter int
+}
+
+type QueryRadiusOutput struct {
+	*GeoQueryOutput
+}
+
+// QuerySectorInput defines a query for all points within RadiusInMeter of
+// CenterPoint whose bearing from the center lies within WidthInDegree/2 of
+// HeadingInDegree, e.g. a heading of 90 and a width of 90 covers everything
+// between north east and south east.
+type QuerySectorInput struct {
+	GeoQueryInput
+	CenterPoint     GeoPoint
+	RadiusInMeter   int
+	HeadingInDegree float64
+	WidthInDegree   float64
+}
+
+type QueryRectangleInput struct {
+	GeoQueryInput
+	PageInput
+	MinPoint *GeoPoint
+	MaxPoint *GeoPoint
+}
+
+type QueryRectangleOutput struct {
+	*GeoQueryOutput
+}
+
+// QueryPolygonInput defines a query for all points within a polygon. Either
+// GeoJSON, holding a GeoJSON Polygon or MultiPolygon geometry, or Polygon has
+// to be set. Holes are excluded from the queried area.
+type QueryPolygonInput struct {
+	GeoQueryInput
+	GeoJSON json.RawMessage
+	Polygon *s2.Polygon
+}
+
+// CountOutput holds the number of points found by a count query and the
+// capacity consumed to count them.
+type CountOutput struct {
+	Count            int64
+	ConsumedCapacity *types.ConsumedCapacity
+}
+
+// QueryNearestInput defines a k-nearest-neighbour query around CenterPoint.
+// K is the number of points to return, MaxDistanceInMeter optionally caps the
+// search radius (0 means no cap). Either way, the search sends at most
+// MAX_NEAREST_QUERIES queries and returns fewer than K points if there are
+// fewer within the radius covered by them.
+type QueryNearestInput struct {
+	GeoQueryInput
+	CenterPoint        GeoPoint
+	K                  int
+	MaxDistanceInMeter int
+}
+
+// QueryCorridorInput defines a query for all points within BufferInMeter of
+// the route described by Polyline.
+type QueryCorridorInput struct {
+	GeoQueryInput
+	Polyline      []GeoPoint
+	BufferInMeter int
+}
+
+// QueryCorridorOutput holds the route distances of every result, in the same
+// order as the items unmarshalled into out, which is the driving order.
+type QueryCorridorOutput struct {
+	RouteDistances []RouteDistance
+}
+
+// RouteDistance describes where a result lies relative to the route.
+// AlongRouteInMeter is measured from the start of the route to the closest
+// point on the route, FromRouteInMeter from that point to the result.
+type RouteDistance = geo.RouteDistance
+
+const EARTH_RADIUS_METERS = geo.EARTH_RADIUS_METERS
+
+// MAX_CELL_LEVEL is the level of S2 leaf cells.
+const MAX_CELL_LEVEL = geo.MAX_CELL_LEVEL
+
+// MAX_ADAPTIVE_CELLS limits the cells of an adaptive covering.
+const MAX_ADAPTIVE_CELLS = geo.MAX_ADAPTIVE_CELLS
+
+// MAX_BATCH_WRITE_ITEMS is the largest number of items DynamoDB accepts in a
+// single BatchWriteItem request.
+const MAX_BATCH_WRITE_ITEMS = geo.MAX_BATCH_WRITE_ITEMS
+
+// MAX_BATCH_GET_ITEMS is the largest number of keys DynamoDB accepts in a
+// single BatchGetItem request.
+const MAX_BATCH_GET_ITEMS = geo.MAX_BATCH_GET_ITEMS
+
+// MAX_BATCH_RETRIES is how often unprocessed items of a batch request are
+// retried before they are reported as failed.
+const MAX_BATCH_RETRIES = geo.MAX_BATCH_RETRIES
+
+// query validates the input and returns the query it describes. The query
+// methods of the other inputs are alike. The variants reading all results at
+// once use it and reject the PageInput, the page variants use pageQuery.
+func (input QueryRadiusInput) query(config DynGeoConfig) (geo.RadiusQuery, error) {
+	if err := geo.ValidateUnpaged(input.PageInput); err != nil {
+		return geo.RadiusQuery{}, err
+	}
+
+	return input.pageQuery(config)
+}
+
+// pageQuery returns the query of QueryRadiusPage.
+func (input QueryRadiusInput) pageQuery(config DynGeoConfig) (geo.RadiusQuery, error) {
+	return geo.NewRadiusQuery(input.CenterPoint, input.RadiusInMeter, input.MinRadiusInMeter, config.NormalizeLongitude)
+}
+
+// query returns the query of the variants reading all results at once, which
+// reject the PageInput.
+func (input QueryRectangleInput) query(config DynGeoConfig) (geo.RectangleQuery, error) {
+	if err := geo.ValidateUnpaged(input.PageInput); err != nil {
+		return geo.RectangleQuery{}, err
+	}
+
+	return input.pageQuery(config)
+}
+
+// pageQuery returns the query of QueryRectanglePage.
+func (input QueryRectangleInput) pageQuery(config DynGeoConfig) (geo.RectangleQuery, error) {
+	return geo.NewRectangleQuery(input.MinPoint, input.MaxPoint, config.NormalizeLongitude)
+}
+
+func (input QuerySectorInput) query(config DynGeoConfig) (geo.SectorQuery, error) {
+	return geo.NewSectorQuery(input.CenterPoint, input.RadiusInMeter, input.HeadingInDegree, input.WidthInDegree, config.NormalizeLongitude)
+}
+
+func (input QueryPolygonInput) query() (geo.PolygonQuery, error) {
+	return geo.NewPolygonQuery(input.GeoJSON, input.Polygon)
+}
+
+func (input QueryCorridorInput) query(config DynGeoConfig) (geo.CorridorQuery, error) {
+	return geo.NewCorridorQuery(input.Polyline, input.BufferInMeter, config.NormalizeLongitude)
+}
+
+func (input QueryNearestInput) query(config DynGeoConfig) (geo.NearestQuery, error) {
+	return geo.NewNearestQuery(input.CenterPoint, input.K, input.MaxDistanceInMeter, config.NormalizeLongitude)
+}
@@ -0,0 +1,343 @@
+package geo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/golang/geo/s2"
+)
+
+// Attributes converts between the attribute values of one AWS SDK flavour,
+// *dynamodb.AttributeValue of v1 or types.AttributeValue of v2, and plain Go
+// values, so that the items of both are handled by the same code. The getters
+// report false for missing values and values of another type.
+type Attributes[V any] interface {
+	String(v V) (string, bool)
+	Number(v V) (string, bool)
+	Binary(v V) ([]byte, bool)
+	Map(v V) (map[string]V, bool)
+	List(v V) ([]V, bool)
+
+	NewString(s string) V
+	NewNumber(n string) V
+	NewBinary(b []byte) V
+	NewMap(m map[string]V) V
+	NewList(l []V) V
+}
+
+// Schema describes how points are stored in the table, see DynGeoConfig.
+type Schema struct {
+	HashKeyAttributeName   string
+	RangeKeyAttributeName  string
+	GeoHashAttributeName   string
+	GeoJSONAttributeName   string
+	LatitudeAttributeName  string
+	LongitudeAttributeName string
+	StorageFormat          StorageFormat
+	LongitudeFirst         bool
+	NormalizeLongitude     bool
+	Hasher                 Hasher
+}
+
+// LocationAttributeNames returns the names of the attributes the location of
+// a point is stored in according to the StorageFormat, besides the geohash.
+func (s Schema) LocationAttributeNames() []string {
+	names := []string{}
+	if s.StorageFormat.GeoJSONString() || s.StorageFormat.GeoJSONMap() {
+		names = append(names, s.GeoJSONAttributeName)
+	}
+	if s.StorageFormat.LatLng() {
+		names = append(names, s.LatitudeAttributeName, s.LongitudeAttributeName)
+	}
+
+	return names
+}
+
+// Codec reads and writes the key, geohash and location attributes of the
+// items of one SDK flavour.
+type Codec[V any] struct {
+	Attributes[V]
+	Schema
+}
+
+// NewUint returns the Number attribute value of n.
+func (c Codec[V]) NewUint(n uint64) V {
+	return c.NewNumber(strconv.FormatUint(n, 10))
+}
+
+// NewFloat returns the Number attribute value of f.
+func (c Codec[V]) NewFloat(f float64) V {
+	return c.NewNumber(strconv.FormatFloat(f, 'f', -1, 64))
+}
+
+// RangeKeyValue returns the attribute value of the range key.
+func (c Codec[V]) RangeKeyValue(rangeKey RangeKey) V {
+	if rangeKey.Type() == NumberRangeKey {
+		return c.NewNumber(rangeKey.String())
+	}
+
+	return c.NewString(rangeKey.String())
+}
+
+// Key returns the primary key of the point.
+func (c Codec[V]) Key(input PointInput) map[string]V {
+	_, hashKey := c.Hasher.Hashes(input.GeoPoint.LatLng())
+
+	return map[string]V{
+		c.HashKeyAttributeName:  c.NewUint(hashKey),
+		c.RangeKeyAttributeName: c.RangeKeyValue(input.RangeKeyValue),
+	}
+}
+
+// PointItem adds the key, geohash and location attributes of the point to
+// item.
+func (c Codec[V]) PointItem(item map[string]V, input PointInput) (map[string]V, error) {
+	if item == nil {
+		item = map[string]V{}
+	}
+
+	geoHash, _ := c.Hasher.Hashes(input.GeoPoint.LatLng())
+	for k, v := range c.Key(input) {
+		item[k] = v
+	}
+	item[c.GeoHashAttributeName] = c.NewUint(geoHash)
+
+	geoJSON := NewGeoJSONAttribute(input.GeoPoint.Latitude, input.GeoPoint.Longitude, c.LongitudeFirst)
+	if c.StorageFormat.GeoJSONString() {
+		jsonAttr, err := json.Marshal(geoJSON)
+		if err != nil {
+			return nil, err
+		}
+		item[c.GeoJSONAttributeName] = c.NewString(string(jsonAttr))
+	}
+	if c.StorageFormat.GeoJSONMap() {
+		coordinates := make([]V, len(geoJSON.Coordinates))
+		for i, coordinate := range geoJSON.Coordinates {
+			coordinates[i] = c.NewFloat(coordinate)
+		}
+		item[c.GeoJSONAttributeName] = c.NewMap(map[string]V{
+			"type":        c.NewString(geoJSON.Type),
+			"coordinates": c.NewList(coordinates),
+		})
+	}
+	if c.StorageFormat.LatLng() {
+		item[c.LatitudeAttributeName] = c.NewFloat(input.GeoPoint.Latitude)
+		item[c.LongitudeAttributeName] = c.NewFloat(input.GeoPoint.Longitude)
+	}
+
+	return item, nil
+}
+
+// StaleLocationAttributeNames returns the names of the location attributes
+// of other StorageFormats that item has been written with, e.g. before the
+// StorageFormat was changed. Attributes merely sharing such a name are user
+// data and left alone: the GeoJSON attribute only counts if it holds a
+// GeoJSON point and the latitude and longitude attributes only if both are
+// Numbers of the location of item.
+func (c Codec[V]) StaleLocationAttributeNames(item map[string]V) []string {
+	current := map[string]bool{c.GeoHashAttributeName: true}
+	for _, name := range c.LocationAttributeNames() {
+		current[name] = true
+	}
+
+	names := []string{}
+	geoJSONName := c.GeoJSONAttributeName
+	if geoJSONName != "" && !current[geoJSONName] && c.geoJSONPoint(item[geoJSONName]) {
+		names = append(names, geoJSONName)
+	}
+
+	latName, lngName := c.LatitudeAttributeName, c.LongitudeAttributeName
+	if latName == "" || lngName == "" || latName == lngName || current[latName] || current[lngName] {
+		return names
+	}
+	lat, latOK := c.Number(item[latName])
+	lng, lngOK := c.Number(item[lngName])
+	if !latOK || !lngOK {
+		return names
+	}
+	numberLat, numberLng, err := degreesFromNumbers(lat, lng)
+	if err != nil {
+		return names
+	}
+	// without a GeoJSON point the numbers are the only location of the item
+	if geoJSONLat, geoJSONLng, err := c.geoJSONDegrees(item[geoJSONName]); err == nil && (geoJSONLat != numberLat || geoJSONLng != numberLng) {
+		return names
+	}
+
+	return append(names, latName, lngName)
+}
+
+// MovedPointItem is like PointItem, but also removes the stale location
+// attributes of item, which would otherwise keep the old location.
+func (c Codec[V]) MovedPointItem(item map[string]V, input PointInput) (map[string]V, error) {
+	stale := c.StaleLocationAttributeNames(item)
+	item, err := c.PointItem(item, input)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range stale {
+		delete(item, name)
+	}
+
+	return item, nil
+}
+
+// LocationUpdate returns the update expression setting the geohash and
+// location attributes to those of item and removing the stale attributes,
+// with the names and values it refers to.
+func (c Codec[V]) LocationUpdate(item map[string]V, stale []string) (string, map[string]string, map[string]V) {
+	names := map[string]string{"#geohash": c.GeoHashAttributeName}
+	values := map[string]V{":geohash": item[c.GeoHashAttributeName]}
+	sets := []string{"#geohash = :geohash"}
+	for i, name := range c.LocationAttributeNames() {
+		names[fmt.Sprintf("#location%d", i)] = name
+		values[fmt.Sprintf(":location%d", i)] = item[name]
+		sets = append(sets, fmt.Sprintf("#location%d = :location%d", i, i))
+	}
+	update := "SET " + strings.Join(sets, ", ")
+
+	removes := []string{}
+	for i, name := range stale {
+		names[fmt.Sprintf("#stale%d", i)] = name
+		removes = append(removes, fmt.Sprintf("#stale%d", i))
+	}
+	if len(removes) > 0 {
+		update += " REMOVE " + strings.Join(removes, ", ")
+	}
+
+	return update, names, values
+}
+
+// MoveCondition returns the condition expression that holds as long as the
+// point read as item hasn't been moved or deleted, i.e. its geohash and
+// stale location attributes keep their values, with the names and values it
+// refers to. It stays small for items of any size.
+func (c Codec[V]) MoveCondition(item map[string]V) (string, map[string]string, map[string]V) {
+	names := map[string]string{}
+	values := map[string]V{}
+	conditions := []string{}
+	for i, name := range append([]string{c.GeoHashAttributeName}, c.StaleLocationAttributeNames(item)...) {
+		names[fmt.Sprintf("#read%d", i)] = name
+		values[fmt.Sprintf(":read%d", i)] = item[name]
+		conditions = append(conditions, fmt.Sprintf("#read%d = :read%d", i, i))
+	}
+
+	return strings.Join(conditions, " AND "), names, values
+}
+
+// Degrees decodes the location of the item exactly as it has been stored.
+// The attributes of the configured StorageFormat are preferred, but every
+// format is understood, so items written in another one, e.g. the GeoJSON
+// strings of earlier versions, can still be read.
+func (c Codec[V]) Degrees(item map[string]V) (float64, float64, error) {
+	lat, latOK := c.Number(item[c.LatitudeAttributeName])
+	lng, lngOK := c.Number(item[c.LongitudeAttributeName])
+	numbers := latOK && lngOK
+	if numbers && c.StorageFormat.LatLng() {
+		return degreesFromNumbers(lat, lng)
+	}
+
+	geoJSON := item[c.GeoJSONAttributeName]
+	_, isMap := c.Map(geoJSON)
+	_, isString := c.String(geoJSON)
+	if isMap || isString {
+		return c.geoJSONDegrees(geoJSON)
+	}
+
+	if numbers {
+		return degreesFromNumbers(lat, lng)
+	}
+
+	return 0, 0, errors.New("item has no location attributes")
+}
+
+// geoJSONDegrees decodes a GeoJSON point stored as a map or as a string.
+func (c Codec[V]) geoJSONDegrees(geoJSON V) (float64, float64, error) {
+	if m, ok := c.Map(geoJSON); ok {
+		values := []string{}
+		list, _ := c.List(m["coordinates"])
+		for _, v := range list {
+			n, _ := c.Number(v)
+			values = append(values, n)
+		}
+		coordinates, err := ParseNumbers(values...)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		return DegreesFromCoordinates(coordinates, c.LongitudeFirst)
+	}
+	if s, ok := c.String(geoJSON); ok {
+		return DegreesFromGeoJSON([]byte(s), c.LongitudeFirst)
+	}
+
+	return 0, 0, errors.New("no GeoJSON point")
+}
+
+// geoJSONPoint reports whether geoJSON is a GeoJSON point as written by
+// PointItem.
+func (c Codec[V]) geoJSONPoint(geoJSON V) bool {
+	if _, _, err := c.geoJSONDegrees(geoJSON); err != nil {
+		return false
+	}
+	if m, ok := c.Map(geoJSON); ok {
+		t, _ := c.String(m["type"])
+		return t == "Point"
+	}
+	s, _ := c.String(geoJSON)
+	attr := GeoJSONAttribute{}
+
+	return json.Unmarshal([]byte(s), &attr) == nil && attr.Type == "Point"
+}
+
+func degreesFromNumbers(lat string, lng string) (float64, float64, error) {
+	degrees, err := ParseNumbers(lat, lng)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return degrees[0], degrees[1], nil
+}
+
+// LatLng is like Degrees, but returns the location as s2.LatLng.
+func (c Codec[V]) LatLng(item map[string]V) (s2.LatLng, error) {
+	lat, lng, err := c.Degrees(item)
+	if err != nil {
+		return s2.LatLng{}, err
+	}
+
+	return s2.LatLngFromDegrees(lat, lng), nil
+}
+
+// RangeKey returns the value of the string or number range key of the item.
+func (c Codec[V]) RangeKey(item map[string]V) (string, bool) {
+	v := item[c.RangeKeyAttributeName]
+	if s, ok := c.String(v); ok {
+		return s, true
+	}
+
+	return c.Number(v)
+}
+
+// Float returns the value of the Number attribute name of the item, if it
+// has one.
+func (c Codec[V]) Float(item map[string]V, name string) (float64, bool, error) {
+	n, ok := c.Number(item[name])
+	if !ok {
+		return 0, false, nil
+	}
+	f, err := strconv.ParseFloat(n, 64)
+
+	return f, err == nil, err
+}
+
+// ItemKey identifies an item of a batch request by its primary key.
+func (c Codec[V]) ItemKey(item map[string]V) string {
+	hashKey, _ := c.Number(item[c.HashKeyAttributeName])
+	rangeKey, _ := c.RangeKey(item)
+
+	return hashKey + "/" + rangeKey
+}
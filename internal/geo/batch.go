@@ -0,0 +1,202 @@
+package geo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MAX_BATCH_WRITE_ITEMS is the largest number of items DynamoDB accepts in a
+// single BatchWriteItem request.
+const MAX_BATCH_WRITE_ITEMS = 25
+
+// MAX_BATCH_GET_ITEMS is the largest number of keys DynamoDB accepts in a
+// single BatchGetItem request.
+const MAX_BATCH_GET_ITEMS = 100
+
+// BATCH_CONCURRENCY is the number of batch requests sent at the same time
+// unless MaxConcurrency is configured.
+const BATCH_CONCURRENCY = 4
+
+// MAX_BATCH_RETRIES is how often unprocessed items of a batch request are
+// retried before they are reported as failed.
+const MAX_BATCH_RETRIES = 8
+
+// BATCH_RETRY_BASE_DELAY and BATCH_RETRY_MAX_DELAY bound the exponential
+// backoff between retries.
+const (
+	BATCH_RETRY_BASE_DELAY = 50 * time.Millisecond
+	BATCH_RETRY_MAX_DELAY  = 5 * time.Second
+)
+
+// retryBaseDelay is BATCH_RETRY_BASE_DELAY, shortened by tests.
+var retryBaseDelay = BATCH_RETRY_BASE_DELAY
+
+// SetRetryBaseDelay replaces the base delay of the backoff and returns the
+// previous one, so that tests of the SDK packages can exhaust the retries
+// quickly.
+func SetRetryBaseDelay(d time.Duration) time.Duration {
+	previous := retryBaseDelay
+	retryBaseDelay = d
+
+	return previous
+}
+
+// ErrUnprocessed is the error of items DynamoDB still left unprocessed after
+// MAX_BATCH_RETRIES retries, usually because the table is throttled.
+var ErrUnprocessed = errors.New("item still unprocessed after retries")
+
+// ErrDuplicateKey is the error of batch requests with several items or keys
+// of the same point, which DynamoDB rejects.
+var ErrDuplicateKey = errors.New("duplicate key in batch")
+
+// BatchFailure is an item of a batch request that ultimately failed.
+type BatchFailure[T any] struct {
+	Item T
+	Err  error
+}
+
+// Chunks splits n items into consecutive chunks of at most size items and
+// returns the start and end index of every chunk.
+func Chunks(n int, size int) [][2]int {
+	chunks := [][2]int{}
+	for start := 0; start < n; start += size {
+		end := start + size
+		if end > n {
+			end = n
+		}
+		chunks = append(chunks, [2]int{start, end})
+	}
+
+	return chunks
+}
+
+// Backoff returns the delay before the given retry, starting at 0. The delay
+// grows exponentially up to BATCH_RETRY_MAX_DELAY and is fully jittered, so
+// concurrent requests throttled together don't retry in lockstep.
+func Backoff(retry int) time.Duration {
+	delay := BATCH_RETRY_MAX_DELAY
+	if retry < 16 {
+		if d := retryBaseDelay << uint(retry); d < delay {
+			delay = d
+		}
+	}
+
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+// RetryUnprocessed sends the items with send, which returns the items
+// DynamoDB left unprocessed, and retries those with exponential backoff. It
+// returns the items that failed, either because send returned an error or
+// because they were still unprocessed after MAX_BATCH_RETRIES retries.
+func RetryUnprocessed[T any](ctx context.Context, items []T, send func([]T) ([]T, error)) []BatchFailure[T] {
+	fail := func(items []T, err error) []BatchFailure[T] {
+		failures := make([]BatchFailure[T], len(items))
+		for i, item := range items {
+			failures[i] = BatchFailure[T]{Item: item, Err: err}
+		}
+
+		return failures
+	}
+
+	pending := items
+	for retry := 0; ; retry++ {
+		unprocessed, err := send(pending)
+		if err != nil {
+			return fail(pending, err)
+		}
+		if len(unprocessed) == 0 {
+			return nil
+		}
+		if retry == MAX_BATCH_RETRIES {
+			return fail(unprocessed, ErrUnprocessed)
+		}
+		pending = unprocessed
+
+		timer := time.NewTimer(Backoff(retry))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return fail(pending, ctx.Err())
+		}
+	}
+}
+
+// RunBatches splits the indices [0, n) into chunks of at most size and sends
+// them with send, at most limit chunks at the same time. Unprocessed indices
+// are retried as in RetryUnprocessed. It returns the failed indices in
+// ascending order, or the context's error if it is done.
+func RunBatches(ctx context.Context, n int, size int, limit int, send func([]int) ([]int, error)) ([]BatchFailure[int], error) {
+	failures := []BatchFailure[int]{}
+	mtx := &sync.Mutex{}
+
+	chunks := Chunks(n, size)
+	RunTasks(ctx, len(chunks), limit, func(c int) {
+		chunk := []int{}
+		for i := chunks[c][0]; i < chunks[c][1]; i++ {
+			chunk = append(chunk, i)
+		}
+
+		failed := RetryUnprocessed(ctx, chunk, send)
+		mtx.Lock()
+		failures = append(failures, failed...)
+		mtx.Unlock()
+	})
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].Item < failures[j].Item
+	})
+
+	return failures, nil
+}
+
+// InputFailures maps the failed indices of a batch back to the inputs.
+func InputFailures[T any](inputs []T, failures []BatchFailure[int]) []InputFailure[T] {
+	inputFailures := make([]InputFailure[T], len(failures))
+	for i, f := range failures {
+		inputFailures[i] = InputFailure[T]{Input: inputs[f.Item], Err: f.Err}
+	}
+
+	return inputFailures
+}
+
+// BatchIndex maps the primary keys of the items of a batch request back to
+// their position in the request.
+type BatchIndex map[string]int
+
+// NewBatchIndex returns the index of the keys, which are items or keys of
+// the request. It fails with ErrDuplicateKey, naming both positions, if two
+// of them have the same primary key.
+func (c Codec[V]) NewBatchIndex(keys []map[string]V) (BatchIndex, error) {
+	index := BatchIndex{}
+	for i, key := range keys {
+		itemKey := c.ItemKey(key)
+		if first, ok := index[itemKey]; ok {
+			return nil, fmt.Errorf("inputs %d and %d: %w", first, i, ErrDuplicateKey)
+		}
+		index[itemKey] = i
+	}
+
+	return index, nil
+}
+
+// Positions returns the positions of the keys DynamoDB left unprocessed.
+func (c Codec[V]) Positions(index BatchIndex, keys []map[string]V) []int {
+	positions := []int{}
+	for _, key := range keys {
+		if i, ok := index[c.ItemKey(key)]; ok {
+			positions = append(positions, i)
+		}
+	}
+
+	return positions
+}
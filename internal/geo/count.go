@@ -0,0 +1,117 @@
+package geo
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/golang/geo/s2"
+)
+
+// CountResult is the number of points found by a count query and the
+// capacity units consumed to count them.
+type CountResult struct {
+	Count         int64
+	CapacityUnits float64
+}
+
+// Count splits the covering of the shape into cells lying completely inside
+// its region and cells on its boundary. Points in interior cells are counted
+// by DynamoDB with req.Count, only the items of boundary cells are read with
+// req.Query and filtered.
+func (e Engine[V]) Count(ctx context.Context, q Shape, req Request[V]) (CountResult, error) {
+	cells, err := e.cover(q)
+	if err != nil {
+		return CountResult{}, err
+	}
+
+	region := q.Region()
+	interior := []s2.CellID{}
+	boundary := []s2.CellID{}
+	for _, cellID := range cells {
+		if region.ContainsCell(s2.CellFromCellID(cellID)) {
+			interior = append(interior, cellID)
+		} else {
+			boundary = append(boundary, cellID)
+		}
+	}
+
+	result := CountResult{}
+	var firstErr error
+	failures := []RangeError{}
+	mtx := &sync.Mutex{}
+
+	add := func(pages []Page[V], count int64, failure *RangeError, err error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		for _, page := range pages {
+			result.CapacityUnits += page.CapacityUnits
+		}
+		result.Count += count
+		if failure != nil {
+			failures = append(failures, *failure)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	interiorRanges := e.ranges(interior, req.Options)
+	boundaryRanges := e.ranges(boundary, req.Options)
+
+	RunTasks(ctx, len(interiorRanges)+len(boundaryRanges), e.concurrency(req.Options), func(i int) {
+		if i < len(interiorRanges) {
+			g := interiorRanges[i]
+			hashKey := e.Hasher.HashKey(g.Min)
+
+			pages, err := readRange(ctx, req.Count, hashKey, g)
+			if err != nil {
+				failure := NewRangeError(hashKey, g, err)
+				add(pages, 0, &failure, nil)
+				return
+			}
+
+			var count int64
+			for _, page := range pages {
+				count += page.Count
+			}
+			add(pages, count, nil, nil)
+			return
+		}
+
+		g := boundaryRanges[i-len(interiorRanges)]
+		hashKey := e.Hasher.HashKey(g.Min)
+
+		pages, err := readRange(ctx, req.Query, hashKey, g)
+		if err != nil {
+			failure := NewRangeError(hashKey, g, err)
+			add(pages, 0, &failure, nil)
+			return
+		}
+
+		var items []map[string]V
+		for _, page := range pages {
+			items = append(items, page.Items...)
+		}
+		found, err := e.Filter(q, items)
+		add(pages, int64(len(found)), nil, err)
+	})
+
+	if err := ctx.Err(); err != nil {
+		return CountResult{}, err
+	}
+	if firstErr != nil {
+		return CountResult{}, firstErr
+	}
+
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].RangeMin < failures[j].RangeMin
+	})
+	queryErr := NewQueryError(failures)
+	if req.Failed(queryErr) {
+		return CountResult{}, queryErr
+	}
+
+	return result, queryErr
+}
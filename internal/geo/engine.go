@@ -0,0 +1,285 @@
+package geo
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/golang/geo/s2"
+)
+
+// Page is a page of the items of a geohash range, as returned by a single
+// DynamoDB Query request.
+type Page[V any] struct {
+	Items            []map[string]V
+	LastEvaluatedKey map[string]V
+	Count            int64
+	ScannedCount     int64
+	CapacityUnits    float64
+}
+
+// PageFunc reads a single page of the geohash range of the hash key,
+// starting after startKey. If limit is greater than 0, at most limit items
+// are evaluated.
+type PageFunc[V any] func(ctx context.Context, hashKey uint64, r Range, startKey map[string]V, limit int) (Page[V], error)
+
+// Request is a single geo query. Query reads the items of a geohash range,
+// Count only counts them.
+type Request[V any] struct {
+	Options
+	Query PageFunc[V]
+	Count PageFunc[V]
+}
+
+// DistanceItem is a result of a query together with its location and
+// distance to the query center in meters.
+type DistanceItem[V any] struct {
+	Item     map[string]V
+	LatLng   s2.LatLng
+	Distance float64
+}
+
+// Engine runs the geo queries of both SDK flavours. The flavours only read
+// single pages of geohash ranges, see PageFunc.
+type Engine[V any] struct {
+	Codec[V]
+	Coverer          s2.RegionCoverer
+	AdaptiveCovering bool
+	MaxConcurrency   int
+}
+
+// Cover returns the cells to query for the region. With AdaptiveCovering the
+// cell level is picked per region, see AdaptiveCoverer.
+func (e Engine[V]) Cover(region s2.Region) (s2.CellUnion, error) {
+	if e.AdaptiveCovering {
+		coverer, err := AdaptiveCoverer(region, e.Hasher.Level(), e.Coverer.MaxCells)
+		if err != nil {
+			return nil, err
+		}
+		return coverer.Covering(region), nil
+	}
+
+	return e.Coverer.Covering(region), nil
+}
+
+// cover returns the cells to query for the shape. The covering of a route is
+// grown by the buffer around it.
+func (e Engine[V]) cover(q Shape) (s2.CellUnion, error) {
+	cells, err := e.Cover(q.Region())
+	if err != nil {
+		return nil, err
+	}
+	if corridor, ok := q.(CorridorQuery); ok {
+		cells.ExpandByRadius(corridor.Buffer(), 0)
+	}
+
+	return cells, nil
+}
+
+// ranges returns the geohash ranges to query for the cells and records them
+// in the stats.
+func (e Engine[V]) ranges(cells s2.CellUnion, o Options) []Range {
+	hashRanges, saved := e.Hasher.Ranges(cells)
+	o.Stats.Record(len(hashRanges), saved)
+
+	return hashRanges
+}
+
+// concurrency returns the number of geohash ranges a query reads at the same
+// time, which defaults to the configured MaxConcurrency.
+func (e Engine[V]) concurrency(o Options) int {
+	if o.MaxConcurrency > 0 {
+		return o.MaxConcurrency
+	}
+
+	return e.MaxConcurrency
+}
+
+// readRange reads all pages of the geohash range. If a page fails, it returns
+// the pages read so far together with the error.
+func readRange[V any](ctx context.Context, read PageFunc[V], hashKey uint64, r Range) ([]Page[V], error) {
+	pages := []Page[V]{}
+	var startKey map[string]V
+	for {
+		page, err := read(ctx, hashKey, r, startKey, 0)
+		if err != nil {
+			return pages, err
+		}
+		pages = append(pages, page)
+
+		if len(page.LastEvaluatedKey) == 0 {
+			return pages, nil
+		}
+		startKey = page.LastEvaluatedKey
+	}
+}
+
+// Dispatch queries the geohash ranges of the cells concurrently, at most
+// MaxConcurrency at the same time. If some ranges fail, it returns the items
+// of the other ranges together with a *QueryError listing the failed ones.
+// The pages a failed range read before failing are dropped, so a range is
+// either returned completely or not at all.
+func (e Engine[V]) Dispatch(ctx context.Context, cells s2.CellUnion, req Request[V]) ([]map[string]V, error) {
+	results := [][]Page[V]{}
+	failures := []RangeError{}
+	mtx := &sync.Mutex{}
+
+	hashRanges := e.ranges(cells, req.Options)
+	RunTasks(ctx, len(hashRanges), e.concurrency(req.Options), func(i int) {
+		g := hashRanges[i]
+		hashKey := e.Hasher.HashKey(g.Min)
+		pages, err := readRange(ctx, req.Query, hashKey, g)
+		mtx.Lock()
+		if err != nil {
+			failures = append(failures, NewRangeError(hashKey, g, err))
+		} else {
+			results = append(results, pages)
+		}
+		mtx.Unlock()
+	})
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var items []map[string]V
+	for _, pages := range results {
+		for _, page := range pages {
+			items = append(items, page.Items...)
+		}
+	}
+
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].RangeMin < failures[j].RangeMin
+	})
+
+	return items, NewQueryError(failures)
+}
+
+// Filter returns the items that are results of the query, with their
+// distance to its center.
+func (e Engine[V]) Filter(q Shape, items []map[string]V) ([]DistanceItem[V], error) {
+	var filtered []DistanceItem[V]
+	center := q.Center()
+
+	for _, item := range items {
+		latLng, err := e.LatLng(item)
+		if err != nil {
+			return nil, err
+		}
+
+		if q.Contains(latLng) {
+			filtered = append(filtered, DistanceItem[V]{Item: item, LatLng: latLng, Distance: EarthDistance(center, latLng)})
+		}
+	}
+
+	return filtered, nil
+}
+
+// Find queries the covering of the shape and returns its results in the
+// order they were found. If some geohash ranges fail with partial results
+// allowed, the results of the others are returned with the *QueryError.
+func (e Engine[V]) Find(ctx context.Context, q Shape, req Request[V]) ([]DistanceItem[V], error) {
+	cells, err := e.cover(q)
+	if err != nil {
+		return nil, err
+	}
+	items, queryErr := e.Dispatch(ctx, cells, req)
+	if req.Failed(queryErr) {
+		return nil, queryErr
+	}
+
+	found, err := e.Filter(q, items)
+	if err != nil {
+		return nil, err
+	}
+
+	return found, queryErr
+}
+
+// Query is like Find, but returns the annotated items, see Annotate.
+func (e Engine[V]) Query(ctx context.Context, q Shape, req Request[V]) ([]map[string]V, error) {
+	found, queryErr := e.Find(ctx, q, req)
+	if req.Failed(queryErr) {
+		return nil, queryErr
+	}
+
+	return e.Annotate(found, q.Center(), req.Options), queryErr
+}
+
+// Annotate injects the requested distance and bearing attributes into every
+// item, orders the items as requested and returns them.
+func (e Engine[V]) Annotate(items []DistanceItem[V], center s2.LatLng, o Options) []map[string]V {
+	if o.SortByDistance != SortNone {
+		sort.SliceStable(items, func(i, j int) bool {
+			return lessByDistance(o.SortByDistance, items[i].Distance, items[j].Distance)
+		})
+	}
+
+	annotated := make([]map[string]V, len(items))
+	for i, item := range items {
+		e.inject(item, center, o)
+		annotated[i] = item.Item
+	}
+
+	return annotated
+}
+
+func (e Engine[V]) inject(item DistanceItem[V], center s2.LatLng, o Options) {
+	if o.DistanceAttributeName != "" {
+		item.Item[o.DistanceAttributeName] = e.NewFloat(item.Distance)
+	}
+	if o.BearingAttributeName != "" {
+		item.Item[o.BearingAttributeName] = e.NewFloat(InitialBearing(center, item.LatLng))
+	}
+}
+
+// annotator returns the filter of paged and streamed queries, which filters
+// and annotates every page on its own.
+func (e Engine[V]) annotator(q Shape, o Options) func([]map[string]V) ([]map[string]V, error) {
+	return func(items []map[string]V) ([]map[string]V, error) {
+		found, err := e.Filter(q, items)
+		if err != nil {
+			return nil, err
+		}
+
+		return e.Annotate(found, q.Center(), o), nil
+	}
+}
+
+// Corridor returns the results of the corridor query in driving order,
+// together with their distances to the route. Distances and bearings are
+// annotated from the start of the route.
+func (e Engine[V]) Corridor(ctx context.Context, q CorridorQuery, req Request[V]) ([]map[string]V, []RouteDistance, error) {
+	found, queryErr := e.Find(ctx, q, req)
+	if req.Failed(queryErr) {
+		return nil, nil, queryErr
+	}
+
+	distances := make([]RouteDistance, len(found))
+	for i, item := range found {
+		distances[i] = q.RouteDistance(item.LatLng)
+	}
+	order := make([]int, len(found))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return distances[order[i]].AlongRouteInMeter < distances[order[j]].AlongRouteInMeter
+	})
+	if req.SortByDistance != SortNone {
+		sort.SliceStable(order, func(i, j int) bool {
+			return lessByDistance(req.SortByDistance, found[order[i]].Distance, found[order[j]].Distance)
+		})
+	}
+
+	items := make([]map[string]V, len(found))
+	routeDistances := make([]RouteDistance, len(found))
+	for i, o := range order {
+		e.inject(found[o], q.Center(), req.Options)
+		items[i] = found[o].Item
+		routeDistances[i] = distances[o]
+	}
+
+	return items, routeDistances, queryErr
+}
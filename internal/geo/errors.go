@@ -0,0 +1,176 @@
+package geo
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrPointNotFound is the error of operations on points that don't exist.
+var ErrPointNotFound = errors.New("point not found")
+
+// ErrRegionTooLarge is the error of queries whose adaptive covering would
+// span more hash keys than MAX_ADAPTIVE_CELLS.
+var ErrRegionTooLarge = errors.New("region too large for an adaptive covering")
+
+// ErrPointModified is the error of moving a point that has been moved or
+// deleted after it was read.
+var ErrPointModified = errors.New("point modified concurrently")
+
+// RangeError is the error of querying a single geohash range.
+type RangeError struct {
+	HashKey  uint64
+	RangeMin uint64
+	RangeMax uint64
+	Err      error
+}
+
+func NewRangeError(hashKey uint64, r Range, err error) RangeError {
+	return RangeError{
+		HashKey:  hashKey,
+		RangeMin: r.Min,
+		RangeMax: r.Max,
+		Err:      err,
+	}
+}
+
+func (e RangeError) Error() string {
+	return fmt.Sprintf("hash key %d, geohashes %d-%d: %v", e.HashKey, e.RangeMin, e.RangeMax, e.Err)
+}
+
+func (e RangeError) Unwrap() error {
+	return e.Err
+}
+
+// QueryError is returned by geo queries when querying some of their geohash
+// ranges failed, e.g. because DynamoDB throttled the requests. Failures lists
+// every failed range.
+//
+// With GeoQueryInput.AllowPartialResults the query still returns the results
+// of all other ranges together with the QueryError.
+type QueryError struct {
+	Failures []RangeError
+}
+
+func (e *QueryError) Error() string {
+	msgs := make([]string, len(e.Failures))
+	for i, f := range e.Failures {
+		msgs[i] = f.Error()
+	}
+
+	return fmt.Sprintf("querying %d geohash ranges failed: %s", len(e.Failures), strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the error of the first failed range, so that errors.Is and
+// errors.As can inspect the underlying DynamoDB error.
+func (e *QueryError) Unwrap() error {
+	if len(e.Failures) == 0 {
+		return nil
+	}
+
+	return e.Failures[0].Err
+}
+
+// NewQueryError returns a *QueryError for the failures, or nil if there are
+// none.
+func NewQueryError(failures []RangeError) error {
+	if len(failures) == 0 {
+		return nil
+	}
+
+	return &QueryError{Failures: failures}
+}
+
+// MergeQueryErrors combines the failures of two query errors, either of which
+// may be nil.
+func MergeQueryErrors(a, b error) error {
+	failures := []RangeError{}
+	for _, err := range []error{a, b} {
+		var queryErr *QueryError
+		if errors.As(err, &queryErr) {
+			failures = append(failures, queryErr.Failures...)
+		}
+	}
+
+	return NewQueryError(failures)
+}
+
+// InputFailure is an input of a batch operation that failed.
+type InputFailure[T any] struct {
+	Input T
+	Err   error
+}
+
+// BatchError is returned by batch operations when some of their inputs
+// failed. All other inputs have been processed.
+type BatchError[T any] struct {
+	Failures []InputFailure[T]
+}
+
+func (e *BatchError[T]) Error() string {
+	return fmt.Sprintf("%d points failed, first error: %v", len(e.Failures), e.Unwrap())
+}
+
+// Unwrap returns the error of the first failed input.
+func (e *BatchError[T]) Unwrap() error {
+	if len(e.Failures) == 0 {
+		return nil
+	}
+
+	return e.Failures[0].Err
+}
+
+// TransactFailure is an operation that caused a transaction to be canceled.
+// Index is its position in the inputs, Code and Message hold the cancellation
+// reason reported by DynamoDB, e.g. ConditionalCheckFailed.
+type TransactFailure[T any] struct {
+	Index   int
+	Input   T
+	Code    string
+	Message string
+}
+
+// TransactError is returned when DynamoDB canceled a transaction. None of the
+// operations have been applied. Err is the original error of the AWS SDK.
+type TransactError[T any] struct {
+	Failures []TransactFailure[T]
+	Err      error
+}
+
+func (e *TransactError[T]) Error() string {
+	if len(e.Failures) == 0 {
+		return e.Err.Error()
+	}
+
+	return fmt.Sprintf("transaction canceled, %d operations failed, first reason: %s", len(e.Failures), e.Failures[0].Code)
+}
+
+func (e *TransactError[T]) Unwrap() error {
+	return e.Err
+}
+
+// CancellationReason is the reason DynamoDB reports for an operation of a
+// canceled transaction.
+type CancellationReason struct {
+	Code    string
+	Message string
+}
+
+// NewTransactError maps the cancellation reasons, which are in the order of
+// the operations, back to the inputs. Operations without a reason or with
+// reason None did not cause the cancellation.
+func NewTransactError[T any](inputs []T, reasons []CancellationReason, err error) *TransactError[T] {
+	transactErr := &TransactError[T]{Err: err}
+	for i, reason := range reasons {
+		if i < len(inputs) && reason.Code != "" && reason.Code != "None" {
+			transactErr.Failures = append(transactErr.Failures, TransactFailure[T]{
+				Index:   i,
+				Input:   inputs[i],
+				Code:    reason.Code,
+				Message: reason.Message,
+			})
+		}
+	}
+
+	return transactErr
+}
@@ -0,0 +1,183 @@
+package geo
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gofrs/uuid"
+)
+
+// FIELD_TAG is the struct tag marking the geo fields of collection types.
+const FIELD_TAG = "dyngeo"
+
+var uuidType = reflect.TypeOf(uuid.UUID{})
+
+// PointFields locates the fields of a struct type tagged with
+// `dyngeo:"lat"`, `dyngeo:"lng"` and `dyngeo:"id"` and, optionally,
+// `dyngeo:"distance"`.
+type PointFields struct {
+	lat      []int
+	lng      []int
+	id       []int
+	distance []int
+
+	// DistanceAttributeName is the attribute name of the distance field, as
+	// used by the AWS SDK marshallers, or empty if there is none.
+	DistanceAttributeName string
+}
+
+// NewPointFields returns the geo fields of the struct type t. Latitude,
+// longitude and distance have to be floats, the id a string, an integer, a
+// float or a uuid.UUID.
+func NewPointFields(t reflect.Type) (PointFields, error) {
+	if t.Kind() != reflect.Struct {
+		return PointFields{}, fmt.Errorf("%s is not a struct", t)
+	}
+
+	fields := PointFields{}
+	for _, f := range reflect.VisibleFields(t) {
+		tag := f.Tag.Get(FIELD_TAG)
+		if tag == "" || !f.IsExported() {
+			continue
+		}
+
+		switch tag {
+		case "lat", "lng", "distance":
+			if k := f.Type.Kind(); k != reflect.Float64 && k != reflect.Float32 {
+				return PointFields{}, fmt.Errorf("field %s tagged %s needs to be a float", f.Name, tag)
+			}
+		case "id":
+			if !validIDType(f.Type) {
+				return PointFields{}, fmt.Errorf("field %s tagged id needs to be a string, a number or a uuid.UUID", f.Name)
+			}
+		default:
+			return PointFields{}, fmt.Errorf("field %s has the unknown tag %s", f.Name, tag)
+		}
+
+		switch tag {
+		case "lat":
+			fields.lat = f.Index
+		case "lng":
+			fields.lng = f.Index
+		case "id":
+			fields.id = f.Index
+		case "distance":
+			fields.distance = f.Index
+			fields.DistanceAttributeName = attributeName(f)
+		}
+	}
+
+	for tag, index := range map[string][]int{"lat": fields.lat, "lng": fields.lng, "id": fields.id} {
+		if index == nil {
+			return PointFields{}, fmt.Errorf("%s has no field tagged %s:%q", t, FIELD_TAG, tag)
+		}
+	}
+
+	return fields, nil
+}
+
+func validIDType(t reflect.Type) bool {
+	if t == uuidType {
+		return true
+	}
+
+	switch t.Kind() {
+	case reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+
+	return false
+}
+
+// attributeName returns the attribute name the AWS SDK marshallers use for
+// the field.
+func attributeName(f reflect.StructField) string {
+	if name := strings.Split(f.Tag.Get("dynamodbav"), ",")[0]; name != "" {
+		return name
+	}
+
+	return f.Name
+}
+
+// LatLng returns the latitude and longitude of v, a value of the struct type.
+func (fields PointFields) LatLng(v reflect.Value) (float64, float64) {
+	return v.FieldByIndex(fields.lat).Float(), v.FieldByIndex(fields.lng).Float()
+}
+
+// SetLatLng sets the latitude and longitude of v, a pointer to the struct.
+func (fields PointFields) SetLatLng(v reflect.Value, lat float64, lng float64) {
+	v.Elem().FieldByIndex(fields.lat).SetFloat(lat)
+	v.Elem().FieldByIndex(fields.lng).SetFloat(lng)
+}
+
+// RangeKey returns the range key held by the id field of v.
+func (fields PointFields) RangeKey(v reflect.Value) RangeKey {
+	id := v.FieldByIndex(fields.id)
+	if id.Type() == uuidType {
+		return RangeKeyFromUUID(id.Interface().(uuid.UUID))
+	}
+
+	switch id.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return RangeKeyFromInt(id.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return RangeKey{value: strconv.FormatUint(id.Uint(), 10), typ: NumberRangeKey}
+	case reflect.Float32, reflect.Float64:
+		return RangeKeyFromFloat(id.Float())
+	default:
+		return RangeKeyFromString(id.String())
+	}
+}
+
+// SetRangeKey parses the range key value into the id field of v, a pointer to
+// the struct.
+func (fields PointFields) SetRangeKey(v reflect.Value, value string) error {
+	id := v.Elem().FieldByIndex(fields.id)
+	if id.Type() == uuidType {
+		u, err := uuid.FromString(value)
+		if err != nil {
+			return err
+		}
+		id.Set(reflect.ValueOf(u))
+
+		return nil
+	}
+
+	switch id.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		id.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		id.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		id.SetFloat(f)
+	default:
+		id.SetString(value)
+	}
+
+	return nil
+}
+
+// SetDistance sets the distance field of v, a pointer to the struct, if it
+// has one.
+func (fields PointFields) SetDistance(v reflect.Value, distance float64) {
+	if fields.distance != nil {
+		v.Elem().FieldByIndex(fields.distance).SetFloat(distance)
+	}
+}
@@ -0,0 +1,218 @@
+// Package geo holds the geo hashing and covering logic shared by the AWS SDK
+// v1 and v2 flavours of DynG(e)o. It does not depend on either SDK.
+package geo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/golang/geo/r1"
+	"github.com/golang/geo/s1"
+	"github.com/golang/geo/s2"
+)
+
+const EARTH_RADIUS_METERS = 6367000.0
+
+// GeoPoint is a location in degrees.
+type GeoPoint struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// LatLng returns the point as s2.LatLng.
+func (p GeoPoint) LatLng() s2.LatLng {
+	return s2.LatLngFromDegrees(p.Latitude, p.Longitude)
+}
+
+// PointInput identifies a point by its range key and location.
+type PointInput struct {
+	RangeKeyValue RangeKey
+	GeoPoint      GeoPoint
+}
+
+// GeoJSONAttribute is the GeoJSON Point stored with every item.
+type GeoJSONAttribute struct {
+	Type        string
+	Coordinates []float64
+}
+
+func NewGeoJSONAttribute(latitude float64, longitude float64, lonFirst bool) GeoJSONAttribute {
+	var coordinates []float64
+	if lonFirst {
+		coordinates = []float64{longitude, latitude}
+	} else {
+		coordinates = []float64{latitude, longitude}
+	}
+
+	return GeoJSONAttribute{
+		Type:        "Point",
+		Coordinates: coordinates,
+	}
+}
+
+// DegreesFromGeoJSON decodes the latitude and longitude of a
+// GeoJSONAttribute exactly as they have been stored.
+func DegreesFromGeoJSON(data []byte, lonFirst bool) (float64, float64, error) {
+	attr := GeoJSONAttribute{}
+	if err := json.Unmarshal(data, &attr); err != nil {
+		return 0, 0, err
+	}
+
+	return DegreesFromCoordinates(attr.Coordinates, lonFirst)
+}
+
+// DegreesFromCoordinates returns the latitude and longitude of the
+// coordinates of a GeoJSON point.
+func DegreesFromCoordinates(coordinates []float64, lonFirst bool) (float64, float64, error) {
+	if len(coordinates) < 2 {
+		return 0, 0, errors.New("GeoJSON point needs longitude and latitude")
+	}
+
+	if lonFirst {
+		return coordinates[1], coordinates[0], nil
+	}
+
+	return coordinates[0], coordinates[1], nil
+}
+
+type geoJSONGeometry struct {
+	Type        string
+	Coordinates json.RawMessage
+}
+
+// PolygonFromGeoJSON converts a GeoJSON Polygon or MultiPolygon geometry into
+// a single s2.Polygon. GeoJSON positions are always longitude first.
+func PolygonFromGeoJSON(data []byte) (*s2.Polygon, error) {
+	geometry := geoJSONGeometry{}
+	if err := json.Unmarshal(data, &geometry); err != nil {
+		return nil, err
+	}
+
+	var polygons [][][][]float64
+	switch geometry.Type {
+	case "Polygon":
+		var rings [][][]float64
+		if err := json.Unmarshal(geometry.Coordinates, &rings); err != nil {
+			return nil, err
+		}
+		polygons = append(polygons, rings)
+	case "MultiPolygon":
+		if err := json.Unmarshal(geometry.Coordinates, &polygons); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported GeoJSON geometry type %q", geometry.Type)
+	}
+
+	loops := []*s2.Loop{}
+	for _, rings := range polygons {
+		for _, ring := range rings {
+			loop, err := loopFromGeoJSONRing(ring)
+			if err != nil {
+				return nil, err
+			}
+			loops = append(loops, loop)
+		}
+	}
+
+	polygon := s2.PolygonFromLoops(loops)
+	if err := polygon.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidShape, err)
+	}
+
+	return polygon, nil
+}
+
+func loopFromGeoJSONRing(ring [][]float64) (*s2.Loop, error) {
+	for _, position := range ring {
+		if len(position) < 2 {
+			return nil, errors.New("GeoJSON position needs longitude and latitude")
+		}
+		if _, _, err := ValidateLatLng(position[1], position[0], false); err != nil {
+			return nil, err
+		}
+	}
+
+	// rings are closed, the last position repeats the first one
+	if len(ring) > 1 && ring[0][0] == ring[len(ring)-1][0] && ring[0][1] == ring[len(ring)-1][1] {
+		ring = ring[:len(ring)-1]
+	}
+	if len(ring) < 3 {
+		return nil, errors.New("GeoJSON linear ring needs at least 3 distinct positions")
+	}
+
+	points := make([]s2.Point, len(ring))
+	for i, position := range ring {
+		points[i] = s2.PointFromLatLng(s2.LatLngFromDegrees(position[1], position[0]))
+	}
+
+	// holes and shells are told apart by nesting, so orientation does not matter
+	loop := s2.LoopFromPoints(points)
+	loop.Normalize()
+
+	return loop, nil
+}
+
+// Angle converts a distance on the earth's surface in meters into an angle.
+func Angle(meters float64) s1.Angle {
+	return s1.Angle(meters / EARTH_RADIUS_METERS)
+}
+
+// RouteDistance describes where a point lies relative to a route.
+// AlongRouteInMeter is measured from the start of the route to the closest
+// point on the route, FromRouteInMeter from that point to the point.
+type RouteDistance struct {
+	AlongRouteInMeter float64
+	FromRouteInMeter  float64
+}
+
+// DistanceToRoute returns the distance along the polyline up to the point
+// closest to p and the distance of p to that point.
+func DistanceToRoute(polyline *s2.Polyline, p s2.Point) RouteDistance {
+	var along, from float64
+	var travelled s1.Angle
+	minDistance := s1.InfAngle()
+
+	for i := 0; i < polyline.NumEdges(); i++ {
+		edge := polyline.Edge(i)
+		distance := s2.DistanceFromSegment(p, edge.V0, edge.V1)
+		if distance < minDistance {
+			minDistance = distance
+			along = (travelled + edge.V0.Distance(s2.Project(p, edge.V0, edge.V1))).Radians() * EARTH_RADIUS_METERS
+			from = distance.Radians() * EARTH_RADIUS_METERS
+		}
+		travelled += edge.V0.Distance(edge.V1)
+	}
+
+	return RouteDistance{AlongRouteInMeter: along, FromRouteInMeter: from}
+}
+
+func EarthDistance(p1 s2.LatLng, p2 s2.LatLng) float64 {
+	return p1.Distance(p2).Radians() * EARTH_RADIUS_METERS
+}
+
+// InitialBearing returns the initial bearing in degrees, clockwise from
+// north, of the great circle path from p1 to p2.
+func InitialBearing(p1 s2.LatLng, p2 s2.LatLng) float64 {
+	lat1 := p1.Lat.Radians()
+	lat2 := p2.Lat.Radians()
+	deltaLng := (p2.Lng - p1.Lng).Radians()
+
+	y := math.Sin(deltaLng) * math.Cos(lat2)
+	x := math.Cos(lat1)*math.Sin(lat2) - math.Sin(lat1)*math.Cos(lat2)*math.Cos(deltaLng)
+	bearing := s1.Angle(math.Atan2(y, x)).Degrees()
+
+	return math.Mod(bearing+360, 360)
+}
+
+// RectFromTwoLatLng returns the rectangle spanning from the south western
+// corner min to the north eastern corner max. If min lies east of max, the
+// rectangle crosses the 180 degree longitude line.
+func RectFromTwoLatLng(min s2.LatLng, max s2.LatLng) s2.Rect {
+	return s2.Rect{
+		Lat: r1.IntervalFromPoint(min.Lat.Radians()).AddPoint(max.Lat.Radians()),
+		Lng: s1.IntervalFromEndpoints(min.Lng.Radians(), max.Lng.Radians()),
+	}
+}
@@ -0,0 +1,1199 @@
+package geo
+
+import (
+	"context"
+	"errors"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/golang/geo/s2"
+)
+
+func TestRangesHashKeySchemes(t *testing.T) {
+	center := s2.CellIDFromLatLng(s2.LatLngFromDegrees(40.7769099, -73.9822532))
+	cells := []s2.CellID{center.Parent(6), center.Parent(6).Next().ChildBeginAtLevel(10).Next(), center.Parent(6).Prev().ChildBeginAtLevel(14).Next()}
+
+	hashers := map[string]Hasher{
+		"decimal prefix": {Scheme: DecimalPrefixHashKey, Length: 5},
+		"parent cell":    {Scheme: ParentCellHashKey, CellLevel: 8},
+	}
+	for name, hasher := range hashers {
+		ranges, _ := hasher.Ranges(cells)
+
+		// geohashes are leaf cell IDs, which are odd
+		var covered uint64
+		for _, g := range ranges {
+			if g.Min > g.Max {
+				t.Errorf("%s: invalid range %d-%d", name, g.Min, g.Max)
+			}
+			if hasher.HashKey(g.Min) != hasher.HashKey(g.Max) {
+				t.Errorf("%s: range %d-%d spans several hash keys", name, g.Min, g.Max)
+			}
+			covered += (g.Max-g.Min)/2 + 1
+		}
+
+		var want uint64
+		for _, c := range cells {
+			want += (uint64(c.RangeMax())-uint64(c.RangeMin()))/2 + 1
+		}
+		if covered != want {
+			t.Errorf("%s: ranges cover %d geohashes, want %d", name, covered, want)
+		}
+	}
+
+	parent := Hasher{Scheme: ParentCellHashKey, CellLevel: 8}
+	if ranges, _ := parent.Ranges(cells[:1]); len(ranges) != 16 {
+		t.Errorf("level 6 cell split into %d ranges, want 16", len(ranges))
+	}
+	if got, want := parent.HashKey(uint64(center)), uint64(center.Parent(8)); got != want {
+		t.Errorf("hash key %d, want %d", got, want)
+	}
+}
+
+func TestRangesMerge(t *testing.T) {
+	cell := s2.CellIDFromLatLng(s2.LatLngFromDegrees(40.7769099, -73.9822532)).Parent(10)
+	cells := []s2.CellID{cell.Children()[3], cell.Children()[0], cell.Children()[1], cell.Children()[1].Children()[2], cell.Children()[0]}
+
+	hasher := Hasher{Length: 2}
+	ranges, saved := hasher.Ranges(cells)
+
+	want := []Range{
+		{Min: uint64(cell.Children()[0].RangeMin()), Max: uint64(cell.Children()[1].RangeMax())},
+		{Min: uint64(cell.Children()[3].RangeMin()), Max: uint64(cell.Children()[3].RangeMax())},
+	}
+	if len(ranges) != len(want) {
+		t.Fatalf("got %d ranges, want %d", len(ranges), len(want))
+	}
+	for i := range want {
+		if ranges[i] != want[i] {
+			t.Errorf("range %d: got %d-%d, want %d-%d", i, ranges[i].Min, ranges[i].Max, want[i].Min, want[i].Max)
+		}
+	}
+	if saved != 3 {
+		t.Errorf("saved %d queries, want 3", saved)
+	}
+}
+
+func TestRunTasksConcurrency(t *testing.T) {
+	pool := NewWorkerPool(3)
+	ctx := context.Background()
+
+	var mtx sync.Mutex
+	var tasks, running, maxRunning int
+	task := func(i int) {
+		if err := pool.Acquire(ctx); err != nil {
+			t.Error(err)
+			return
+		}
+		defer pool.Release()
+
+		mtx.Lock()
+		tasks++
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mtx.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mtx.Lock()
+		running--
+		mtx.Unlock()
+	}
+
+	// four queries with five workers each share the three slots of the pool
+	wg := &sync.WaitGroup{}
+	wg.Add(4)
+	for q := 0; q < 4; q++ {
+		go func() {
+			defer wg.Done()
+			RunTasks(ctx, 20, 5, task)
+		}()
+	}
+	wg.Wait()
+
+	if tasks != 80 {
+		t.Errorf("ran %d tasks, want 80", tasks)
+	}
+	if maxRunning != 3 {
+		t.Errorf("%d tasks ran at the same time, want 3", maxRunning)
+	}
+}
+
+func TestChunks(t *testing.T) {
+	chunks := Chunks(60, MAX_BATCH_WRITE_ITEMS)
+	want := [][2]int{{0, 25}, {25, 50}, {50, 60}}
+	if len(chunks) != len(want) {
+		t.Fatalf("got %v, want %v", chunks, want)
+	}
+	for i := range want {
+		if chunks[i] != want[i] {
+			t.Errorf("chunk %d: got %v, want %v", i, chunks[i], want[i])
+		}
+	}
+	if chunks := Chunks(0, MAX_BATCH_WRITE_ITEMS); len(chunks) != 0 {
+		t.Errorf("got %v for no items", chunks)
+	}
+}
+
+func TestRetryUnprocessed(t *testing.T) {
+	retryBaseDelay = time.Microsecond
+	defer func() { retryBaseDelay = BATCH_RETRY_BASE_DELAY }()
+	ctx := context.Background()
+
+	// the last item stays unprocessed twice, the first one forever
+	attempts := map[int]int{}
+	failures := RetryUnprocessed(ctx, []int{1, 2, 3}, func(items []int) ([]int, error) {
+		unprocessed := []int{}
+		for _, i := range items {
+			attempts[i]++
+			if i == 1 || (i == 3 && attempts[i] <= 2) {
+				unprocessed = append(unprocessed, i)
+			}
+		}
+		return unprocessed, nil
+	})
+
+	if len(failures) != 1 || failures[0].Item != 1 || failures[0].Err != ErrUnprocessed {
+		t.Errorf("got failures %v, want item 1 unprocessed", failures)
+	}
+	if attempts[1] != MAX_BATCH_RETRIES+1 || attempts[2] != 1 || attempts[3] != 3 {
+		t.Errorf("got attempts %v", attempts)
+	}
+
+	throttled := errors.New("throttled")
+	failures = RetryUnprocessed(ctx, []int{1, 2}, func(items []int) ([]int, error) {
+		return nil, throttled
+	})
+	if len(failures) != 2 || failures[0].Err != throttled || failures[1].Err != throttled {
+		t.Errorf("got failures %v, want both items failed", failures)
+	}
+}
+
+func TestRangeKey(t *testing.T) {
+	id := uuid.Must(uuid.FromString("6ba7b810-9dad-11d1-80b4-00c04fd430c8"))
+	tests := []struct {
+		rangeKey      RangeKey
+		value         string
+		attributeType string
+	}{
+		{RangeKeyFromString("tenant#42"), "tenant#42", "S"},
+		{RangeKeyFromInt(-1700000000), "-1700000000", "N"},
+		{RangeKeyFromFloat(1.5e-7), "0.00000015", "N"},
+		{RangeKeyFromUUID(id), "6ba7b810-9dad-11d1-80b4-00c04fd430c8", "S"},
+	}
+
+	for _, test := range tests {
+		if test.rangeKey.String() != test.value || test.rangeKey.Type().AttributeType() != test.attributeType {
+			t.Errorf("got %s of type %s, want %s of type %s", test.rangeKey, test.rangeKey.Type().AttributeType(), test.value, test.attributeType)
+		}
+	}
+}
+
+func TestPointFields(t *testing.T) {
+	type base struct {
+		ID uuid.UUID `dyngeo:"id"`
+	}
+	type point struct {
+		base
+		Lat      float64 `dyngeo:"lat"`
+		Lng      float32 `dyngeo:"lng"`
+		Distance float64 `dyngeo:"distance" dynamodbav:"dist,omitempty"`
+	}
+
+	fields, err := NewPointFields(reflect.TypeOf(point{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fields.DistanceAttributeName != "dist" {
+		t.Errorf("got distance attribute %s, want dist", fields.DistanceAttributeName)
+	}
+
+	id := uuid.Must(uuid.NewV4())
+	p := point{base: base{ID: id}, Lat: 52.52, Lng: 13.405}
+	if lat, lng := fields.LatLng(reflect.ValueOf(p)); lat != 52.52 || float32(lng) != 13.405 {
+		t.Errorf("got %f, %f, want 52.52, 13.405", lat, lng)
+	}
+	if rangeKey := fields.RangeKey(reflect.ValueOf(p)); rangeKey != RangeKeyFromUUID(id) {
+		t.Errorf("got range key %s, want %s", rangeKey, id)
+	}
+
+	out := point{}
+	fields.SetLatLng(reflect.ValueOf(&out), 52.52, 13.405)
+	fields.SetDistance(reflect.ValueOf(&out), 42)
+	if err := fields.SetRangeKey(reflect.ValueOf(&out), id.String()); err != nil {
+		t.Fatal(err)
+	}
+	if want := (point{base: base{ID: id}, Lat: 52.52, Lng: 13.405, Distance: 42}); out != want {
+		t.Errorf("got %+v, want %+v", out, want)
+	}
+
+	invalid := map[string]interface{}{
+		"not a struct": 42,
+		"missing id": struct {
+			Lat float64 `dyngeo:"lat"`
+			Lng float64 `dyngeo:"lng"`
+		}{},
+		"string latitude": struct {
+			ID  string  `dyngeo:"id"`
+			Lat string  `dyngeo:"lat"`
+			Lng float64 `dyngeo:"lng"`
+		}{},
+		"unknown tag": struct {
+			ID  string  `dyngeo:"id"`
+			Lat float64 `dyngeo:"lat"`
+			Lng float64 `dyngeo:"lng"`
+			Alt float64 `dyngeo:"alt"`
+		}{},
+	}
+	for name, v := range invalid {
+		if _, err := NewPointFields(reflect.TypeOf(v)); err == nil {
+			t.Errorf("%s: got no error", name)
+		}
+	}
+}
+
+func TestValidateLatLng(t *testing.T) {
+	tests := []struct {
+		lat, lng  float64
+		normalize bool
+		wantLng   float64
+		wantErr   bool
+	}{
+		{lat: 52.52, lng: 13.405, wantLng: 13.405},
+		{lat: -90, lng: 180, wantLng: 180},
+		{lat: 90.5, lng: 0, wantErr: true},
+		{lat: math.NaN(), lng: 0, wantErr: true},
+		{lat: 0, lng: math.Inf(1), normalize: true, wantErr: true},
+		{lat: 0, lng: 190, wantErr: true},
+		{lat: 0, lng: 190, normalize: true, wantLng: -170},
+		{lat: 0, lng: -540, normalize: true, wantLng: 180},
+	}
+
+	for _, tt := range tests {
+		_, lng, err := ValidateLatLng(tt.lat, tt.lng, tt.normalize)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidCoordinate) {
+				t.Errorf("%v, %v: got error %v, want ErrInvalidCoordinate", tt.lat, tt.lng, err)
+			}
+			continue
+		}
+		if err != nil || lng != tt.wantLng {
+			t.Errorf("%v, %v: got longitude %v, error %v, want %v", tt.lat, tt.lng, lng, err, tt.wantLng)
+		}
+	}
+}
+
+// testValue is the attribute value of the tests, modelled on the v1 SDK.
+type testValue struct {
+	S *string
+	N *string
+	B []byte
+	M map[string]*testValue
+	L []*testValue
+}
+
+type testAttributes struct{}
+
+func (testAttributes) String(v *testValue) (string, bool) {
+	if v == nil || v.S == nil {
+		return "", false
+	}
+	return *v.S, true
+}
+
+func (testAttributes) Number(v *testValue) (string, bool) {
+	if v == nil || v.N == nil {
+		return "", false
+	}
+	return *v.N, true
+}
+
+func (testAttributes) Binary(v *testValue) ([]byte, bool) {
+	if v == nil || v.B == nil {
+		return nil, false
+	}
+	return v.B, true
+}
+
+func (testAttributes) Map(v *testValue) (map[string]*testValue, bool) {
+	if v == nil || v.M == nil {
+		return nil, false
+	}
+	return v.M, true
+}
+
+func (testAttributes) List(v *testValue) ([]*testValue, bool) {
+	if v == nil || v.L == nil {
+		return nil, false
+	}
+	return v.L, true
+}
+
+func (testAttributes) NewString(s string) *testValue { return &testValue{S: &s} }
+
+func (testAttributes) NewNumber(n string) *testValue { return &testValue{N: &n} }
+
+func (testAttributes) NewBinary(b []byte) *testValue { return &testValue{B: b} }
+
+func (testAttributes) NewMap(m map[string]*testValue) *testValue { return &testValue{M: m} }
+
+func (testAttributes) NewList(l []*testValue) *testValue { return &testValue{L: l} }
+
+func newTestEngine() Engine[*testValue] {
+	return Engine[*testValue]{
+		Codec: Codec[*testValue]{
+			Attributes: testAttributes{},
+			Schema: Schema{
+				HashKeyAttributeName:   "hashKey",
+				RangeKeyAttributeName:  "rangeKey",
+				GeoHashAttributeName:   "geohash",
+				GeoJSONAttributeName:   "geoJson",
+				LatitudeAttributeName:  "lat",
+				LongitudeAttributeName: "lng",
+				LongitudeFirst:         true,
+				Hasher:                 Hasher{Scheme: ParentCellHashKey, CellLevel: 10},
+			},
+		},
+		Coverer: s2.RegionCoverer{MinLevel: 10, MaxLevel: 10, MaxCells: 10},
+	}
+}
+
+// fakeTable keeps items in memory and reads the pages of geohash ranges like
+// DynamoDB, at most pageSize items per page. Reads fail with the error fail
+// returns, if any.
+type fakeTable struct {
+	e        Engine[*testValue]
+	pageSize int
+	fail     func(hashKey uint64, r Range, startKey map[string]*testValue) error
+
+	mtx   sync.Mutex
+	items []map[string]*testValue
+	reads int
+}
+
+func newFakeTable(t *testing.T, e Engine[*testValue], points map[string]GeoPoint) *fakeTable {
+	table := &fakeTable{e: e}
+	for name, p := range points {
+		item, err := e.PointItem(map[string]*testValue{"name": e.NewString(name)}, PointInput{RangeKeyValue: RangeKeyFromString(name), GeoPoint: p})
+		if err != nil {
+			t.Fatal(err)
+		}
+		table.items = append(table.items, item)
+	}
+
+	return table
+}
+
+func (table *fakeTable) read(count bool) PageFunc[*testValue] {
+	return func(ctx context.Context, hashKey uint64, r Range, startKey map[string]*testValue, limit int) (Page[*testValue], error) {
+		table.mtx.Lock()
+		defer table.mtx.Unlock()
+
+		if err := ctx.Err(); err != nil {
+			return Page[*testValue]{}, err
+		}
+		table.reads++
+		if table.fail != nil {
+			if err := table.fail(hashKey, r, startKey); err != nil {
+				return Page[*testValue]{}, err
+			}
+		}
+
+		var matches []map[string]*testValue
+		for _, item := range table.items {
+			geoHash, _, _ := table.e.Float(item, "geohash")
+			if n, _ := table.e.Number(item["hashKey"]); n == strconv.FormatUint(hashKey, 10) && uint64(geoHash) >= r.Min && uint64(geoHash) <= r.Max {
+				matches = append(matches, item)
+			}
+		}
+		sort.Slice(matches, func(i, j int) bool {
+			return table.e.ItemKey(matches[i]) < table.e.ItemKey(matches[j])
+		})
+
+		if startKey != nil {
+			for i, item := range matches {
+				if table.e.ItemKey(item) == table.e.ItemKey(startKey) {
+					matches = matches[i+1:]
+					break
+				}
+			}
+		}
+
+		size := table.pageSize
+		if limit > 0 && (size == 0 || limit < size) {
+			size = limit
+		}
+		page := Page[*testValue]{CapacityUnits: 0.5}
+		if size > 0 && len(matches) > size {
+			matches = matches[:size]
+			page.LastEvaluatedKey = table.e.Key(PointInput{RangeKeyValue: RangeKeyFromString(testName(table.e, matches[size-1]))})
+			page.LastEvaluatedKey["hashKey"] = matches[size-1]["hashKey"]
+		}
+		page.Count = int64(len(matches))
+		page.ScannedCount = int64(len(matches))
+		if !count {
+			page.Items = matches
+		}
+
+		return page, nil
+	}
+}
+
+func (table *fakeTable) request(o Options) Request[*testValue] {
+	return Request[*testValue]{Options: o, Query: table.read(false), Count: table.read(true)}
+}
+
+func testName(e Engine[*testValue], item map[string]*testValue) string {
+	name, _ := e.String(item["name"])
+	return name
+}
+
+func testNames(e Engine[*testValue], items []map[string]*testValue) []string {
+	names := []string{}
+	for _, item := range items {
+		names = append(names, testName(e, item))
+	}
+	return names
+}
+
+// testPoints are spread over several cells around Central Park.
+var testPoints = map[string]GeoPoint{
+	"central park":  {Latitude: 40.7812, Longitude: -73.9665},
+	"times square":  {Latitude: 40.7580, Longitude: -73.9855},
+	"lincoln plaza": {Latitude: 40.7725, Longitude: -73.9835},
+	"harlem":        {Latitude: 40.8116, Longitude: -73.9465},
+	"chelsea":       {Latitude: 40.7465, Longitude: -74.0014},
+	"astoria":       {Latitude: 40.7644, Longitude: -73.9235},
+	"brooklyn":      {Latitude: 40.6782, Longitude: -73.9442},
+	"philadelphia":  {Latitude: 39.9526, Longitude: -75.1652},
+}
+
+var testCenter = GeoPoint{Latitude: 40.7769099, Longitude: -73.9822532}
+
+func TestDispatchDropsFailedRanges(t *testing.T) {
+	e := newTestEngine()
+	q, err := NewRadiusQuery(testCenter, 6000, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cells, err := e.cover(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the failing range reads a page before failing on its next one
+	table := newFakeTable(t, e, testPoints)
+	var failing *Range
+	lost := map[string]bool{}
+	for _, r := range e.ranges(cells, Options{}) {
+		page, err := table.read(false)(context.Background(), e.Hasher.HashKey(r.Min), r, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Items) > 1 {
+			failing = &r
+			for _, name := range testNames(e, page.Items) {
+				lost[name] = true
+			}
+			break
+		}
+	}
+	if failing == nil {
+		t.Fatal("got no range with several items")
+	}
+	all, err := e.Dispatch(context.Background(), cells, table.request(Options{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{}
+	for _, name := range testNames(e, all) {
+		if !lost[name] {
+			want = append(want, name)
+		}
+	}
+	sort.Strings(want)
+
+	table.pageSize = 1
+	table.fail = func(hashKey uint64, r Range, startKey map[string]*testValue) error {
+		if r == *failing && startKey != nil {
+			return errors.New("throttled")
+		}
+		return nil
+	}
+	items, err := e.Dispatch(context.Background(), cells, table.request(Options{AllowPartialResults: true}))
+	var queryErr *QueryError
+	if !errors.As(err, &queryErr) || len(queryErr.Failures) != 1 {
+		t.Fatalf("got error %v, want a *QueryError with the failing range", err)
+	}
+	found := testNames(e, items)
+	sort.Strings(found)
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("got %v, want %v without the items of the failed range", found, want)
+	}
+}
+
+func TestQueryPageResumesFailedRange(t *testing.T) {
+	e := newTestEngine()
+	q, err := NewRadiusQuery(testCenter, 6000, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cells, err := e.cover(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashRanges := e.ranges(cells, Options{})
+	sort.Slice(hashRanges, func(i, j int) bool { return hashRanges[i].Min < hashRanges[j].Min })
+	if len(hashRanges) < 2 {
+		t.Fatalf("got %d ranges, want several", len(hashRanges))
+	}
+
+	table := newFakeTable(t, e, testPoints)
+	all, err := e.Query(context.Background(), q, table.request(Options{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := testNames(e, all)
+	sort.Strings(want)
+
+	// the failing ranges need items, which a skipped range would lose
+	filled := []Range{}
+	for _, r := range hashRanges {
+		page, err := table.read(false)(context.Background(), e.Hasher.HashKey(r.Min), r, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Items) > 0 {
+			filled = append(filled, r)
+		}
+	}
+	if len(filled) < 2 {
+		t.Fatalf("got %d ranges with items, want several", len(filled))
+	}
+
+	for name, failing := range map[string]Range{"first range": filled[0], "last range": filled[len(filled)-1]} {
+		t.Run(name, func(t *testing.T) {
+			table := newFakeTable(t, e, testPoints)
+			failed := false
+			table.fail = func(hashKey uint64, r Range, startKey map[string]*testValue) error {
+				if r == failing && startKey == nil && !failed {
+					failed = true
+					return errors.New("throttled")
+				}
+				return nil
+			}
+
+			found := []string{}
+			page := PageInput{Limit: 100}
+			var queryErrors int
+			for i := 0; i < 10; i++ {
+				items, summary, err := e.QueryPage(context.Background(), q, table.request(Options{AllowPartialResults: true}), page)
+				var queryErr *QueryError
+				if errors.As(err, &queryErr) {
+					queryErrors++
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				found = append(found, testNames(e, items)...)
+				if summary.ContinuationToken == "" {
+					break
+				}
+				page.ContinuationToken = summary.ContinuationToken
+			}
+			sort.Strings(found)
+
+			if !failed || queryErrors != 1 {
+				t.Errorf("failed %v, got %d query errors, want 1", failed, queryErrors)
+			}
+			if !reflect.DeepEqual(found, want) {
+				t.Errorf("found %v across pages, want %v", found, want)
+			}
+		})
+	}
+}
+
+func TestQueryPage(t *testing.T) {
+	e := newTestEngine()
+	q, err := NewRadiusQuery(testCenter, 6000, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := newFakeTable(t, e, testPoints)
+	table.pageSize = 1
+	items, summary, err := e.QueryPage(context.Background(), q, table.request(Options{}), PageInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.ContinuationToken != "" {
+		t.Fatalf("got continuation token %q without a limit, want none", summary.ContinuationToken)
+	}
+	want := testNames(e, items)
+	if len(want) < 3 {
+		t.Fatalf("got %d results, want several", len(want))
+	}
+
+	for _, limit := range []int{1, 2, 3} {
+		found := []string{}
+		page := PageInput{Limit: limit}
+		for i := 0; i <= len(want); i++ {
+			items, summary, err := e.QueryPage(context.Background(), q, table.request(Options{}), page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(items) > limit {
+				t.Errorf("limit %d: got %d results on a page", limit, len(items))
+			}
+			found = append(found, testNames(e, items)...)
+			if summary.ContinuationToken == "" {
+				break
+			}
+			page.ContinuationToken = summary.ContinuationToken
+		}
+		if !reflect.DeepEqual(found, want) {
+			t.Errorf("limit %d: found %v across pages, want %v", limit, found, want)
+		}
+	}
+
+	_, summary, err = e.QueryPage(context.Background(), q, table.request(Options{}), PageInput{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewRadiusQuery(GeoPoint{Latitude: 39.9526, Longitude: -75.1652}, 6000, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, page := range map[string]PageInput{
+		"token of another query": {Limit: 1, ContinuationToken: summary.ContinuationToken},
+		"malformed token":        {Limit: 1, ContinuationToken: "not a token"},
+		"negative limit":         {Limit: -1},
+	} {
+		if _, _, err := e.QueryPage(context.Background(), other, table.request(Options{}), page); err == nil {
+			t.Errorf("%s: got no error", name)
+		}
+	}
+
+	if err := ValidateUnpaged(PageInput{Limit: 1}); !errors.Is(err, ErrPageInput) {
+		t.Errorf("got error %v for a limit, want ErrPageInput", err)
+	}
+	if err := ValidateUnpaged(PageInput{}); err != nil {
+		t.Errorf("got error %v without page input", err)
+	}
+}
+
+func TestIterateStopsReading(t *testing.T) {
+	e := newTestEngine()
+	q, err := NewRadiusQuery(testCenter, 6000, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := newFakeTable(t, e, testPoints)
+	table.pageSize = 1
+	reads := func() int {
+		table.mtx.Lock()
+		defer table.mtx.Unlock()
+		return table.reads
+	}
+
+	all, err := e.Query(context.Background(), q, table.request(Options{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) < 3 {
+		t.Fatalf("got %d results, want several", len(all))
+	}
+	total := reads()
+	table.reads = 0
+
+	it := e.Iterate(context.Background(), q, table.request(Options{MaxConcurrency: 1}))
+	if !it.Next() {
+		t.Fatalf("got no item, error %v", it.Err())
+	}
+
+	// the range waits for the consumer with its next page
+	time.Sleep(50 * time.Millisecond)
+	stopped := reads()
+	time.Sleep(50 * time.Millisecond)
+	if n := reads(); n != stopped || n >= total {
+		t.Errorf("got %d reads after reading a single item, then %d, want them to stop short of %d", stopped, n, total)
+	}
+
+	it.Close()
+	it.Close()
+	drained := make(chan struct{})
+	go func() {
+		for range it.items {
+		}
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(time.Second):
+		t.Fatal("the queries did not finish after Close")
+	}
+	n := reads()
+	time.Sleep(50 * time.Millisecond)
+	if reads() != n || n > stopped+1 {
+		t.Errorf("got %d reads after Close, then %d, want no more", n, reads())
+	}
+	if it.Next() {
+		t.Error("got an item after Close")
+	}
+}
+
+// queryNames returns the sorted names of the test points the query finds.
+func queryNames(t *testing.T, e Engine[*testValue], q Shape) []string {
+	t.Helper()
+	table := newFakeTable(t, e, testPoints)
+	items, err := e.Query(context.Background(), q, table.request(Options{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := testNames(e, items)
+	sort.Strings(names)
+
+	return names
+}
+
+func TestPolygonQuery(t *testing.T) {
+	e := newTestEngine()
+	box := `[[-74.01,40.74],[-73.93,40.74],[-73.93,40.82],[-74.01,40.82],[-74.01,40.74]]`
+	hole := `[[-73.975,40.775],[-73.975,40.79],[-73.955,40.79],[-73.955,40.775],[-73.975,40.775]]`
+
+	tests := []struct {
+		name    string
+		geoJSON string
+		want    []string
+	}{
+		{
+			name:    "polygon",
+			geoJSON: `{"type":"Polygon","coordinates":[` + box + `]}`,
+			want:    []string{"central park", "chelsea", "harlem", "lincoln plaza", "times square"},
+		},
+		{
+			name:    "hole",
+			geoJSON: `{"type":"Polygon","coordinates":[` + box + `,` + hole + `]}`,
+			want:    []string{"chelsea", "harlem", "lincoln plaza", "times square"},
+		},
+		{
+			// central park and harlem lie within the covering of the box, but
+			// beyond the diagonal
+			name:    "exact containment",
+			geoJSON: `{"type":"Polygon","coordinates":[[[-74.01,40.74],[-73.93,40.74],[-74.01,40.82],[-74.01,40.74]]]}`,
+			want:    []string{"chelsea", "lincoln plaza", "times square"},
+		},
+		{
+			name: "multipolygon",
+			geoJSON: `{"type":"MultiPolygon","coordinates":[` +
+				`[[[-73.96,40.67],[-73.93,40.67],[-73.93,40.69],[-73.96,40.69],[-73.96,40.67]]],` +
+				`[[[-73.93,40.755],[-73.91,40.755],[-73.91,40.775],[-73.93,40.775],[-73.93,40.755]]]]}`,
+			want: []string{"astoria", "brooklyn"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q, err := NewPolygonQuery([]byte(test.geoJSON), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := queryNames(t, e, q); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+
+			// the s2.Polygon input finds the same points
+			polygon, err := PolygonFromGeoJSON([]byte(test.geoJSON))
+			if err != nil {
+				t.Fatal(err)
+			}
+			q, err = NewPolygonQuery(nil, polygon)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := queryNames(t, e, q); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("s2.Polygon: got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSectorAndAnnulusQueries(t *testing.T) {
+	e := newTestEngine()
+	sector := func(radius int, heading, width float64) Shape {
+		q, err := NewSectorQuery(testCenter, radius, heading, width, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return q
+	}
+	annulus := func(minRadius, radius int) Shape {
+		q, err := NewRadiusQuery(testCenter, radius, minRadius, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return q
+	}
+
+	tests := []struct {
+		name string
+		q    Shape
+		want []string
+	}{
+		{name: "annulus", q: annulus(2500, 6000), want: []string{"astoria", "chelsea", "harlem"}},
+		{name: "narrow annulus", q: annulus(1000, 3000), want: []string{"central park", "times square"}},
+		{name: "sector east", q: sector(6000, 90, 90), want: []string{"astoria", "central park"}},
+		{name: "sector across north", q: sector(6000, 0, 90), want: []string{"harlem"}},
+		{name: "sector south", q: sector(15000, 180, 60), want: []string{"brooklyn", "chelsea", "lincoln plaza", "times square"}},
+		{name: "short sector south", q: sector(3000, 180, 60), want: []string{"lincoln plaza", "times square"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := queryNames(t, e, test.q); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+
+	// both cover less than the circle around them
+	fine := Engine[*testValue]{Coverer: s2.RegionCoverer{MinLevel: 14, MaxLevel: 14, MaxCells: 10000}}
+	area := func(q Shape) float64 {
+		cells, err := fine.Cover(q.Region())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sum float64
+		for _, cellID := range cells {
+			sum += s2.CellFromCellID(cellID).ExactArea()
+		}
+		return sum
+	}
+	circle := area(annulus(0, 6000))
+	if ring := area(annulus(3000, 6000)); ring >= circle*0.9 {
+		t.Errorf("annulus covers %.2f of the circle, want the inner circle left out", ring/circle)
+	}
+	if quarter := area(sector(6000, 90, 90)); quarter >= circle/2 {
+		t.Errorf("sector of 90 degrees covers %.2f of the circle, want about a quarter", quarter/circle)
+	}
+}
+
+func TestCorridor(t *testing.T) {
+	e := newTestEngine()
+	// north along a meridian west of Central Park, then east along 40.8
+	start := GeoPoint{Latitude: 40.70, Longitude: -73.99}
+	corner := GeoPoint{Latitude: 40.80, Longitude: -73.99}
+	end := GeoPoint{Latitude: 40.80, Longitude: -73.90}
+	q, err := NewCorridorQuery([]GeoPoint{start, corner, end}, 1500, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table := newFakeTable(t, e, testPoints)
+	items, distances, err := e.Corridor(context.Background(), q, table.request(Options{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"chelsea", "times square", "lincoln plaza", "harlem"}
+	if got := testNames(e, items); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v in driving order, want %v", got, want)
+	}
+	if len(distances) != len(items) {
+		t.Fatalf("got %d route distances for %d items", len(distances), len(items))
+	}
+
+	for i, name := range want {
+		p := testPoints[name]
+		var wantAlong, wantFrom float64
+		if name == "harlem" {
+			// closest to the second leg, roughly due south of it
+			onRoute := GeoPoint{Latitude: corner.Latitude, Longitude: p.Longitude}
+			wantAlong = EarthDistance(start.LatLng(), corner.LatLng()) + EarthDistance(corner.LatLng(), onRoute.LatLng())
+			wantFrom = EarthDistance(p.LatLng(), onRoute.LatLng())
+		} else {
+			// closest to the first leg, due west or east of it
+			onRoute := GeoPoint{Latitude: p.Latitude, Longitude: start.Longitude}
+			wantAlong = EarthDistance(start.LatLng(), onRoute.LatLng())
+			wantFrom = EarthDistance(p.LatLng(), onRoute.LatLng())
+		}
+
+		d := distances[i]
+		if math.Abs(d.AlongRouteInMeter-wantAlong) > 50 || math.Abs(d.FromRouteInMeter-wantFrom) > 50 {
+			t.Errorf("%s: got %+v, want about %.0fm along and %.0fm from the route", name, d, wantAlong, wantFrom)
+		}
+		if d.FromRouteInMeter > 1500 {
+			t.Errorf("%s: %.0fm from the route, beyond the buffer", name, d.FromRouteInMeter)
+		}
+	}
+}
+
+func TestDistanceAndBearing(t *testing.T) {
+	e := newTestEngine()
+	q, err := NewRadiusQuery(testCenter, 6000, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ascending := []string{"lincoln plaza", "central park", "times square", "chelsea", "harlem", "astoria"}
+	descending := make([]string, len(ascending))
+	for i, name := range ascending {
+		descending[len(ascending)-1-i] = name
+	}
+	// the quadrant of every result, as seen from the center
+	bearings := map[string][2]float64{
+		"lincoln plaza": {180, 270},
+		"central park":  {0, 90},
+		"times square":  {180, 270},
+		"chelsea":       {180, 270},
+		"harlem":        {0, 90},
+		"astoria":       {90, 180},
+	}
+
+	for order, want := range map[SortOrder][]string{SortAscending: ascending, SortDescending: descending} {
+		table := newFakeTable(t, e, testPoints)
+		items, err := e.Query(context.Background(), q, table.request(Options{
+			DistanceAttributeName: "distance",
+			BearingAttributeName:  "bearing",
+			SortByDistance:        order,
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := testNames(e, items); !reflect.DeepEqual(got, want) {
+			t.Errorf("sort order %d: got %v, want %v", order, got, want)
+		}
+
+		for _, item := range items {
+			name := testName(e, item)
+			distance, ok, err := e.Float(item, "distance")
+			if err != nil || !ok {
+				t.Fatalf("%s: got no distance, error %v", name, err)
+			}
+			if want := EarthDistance(testCenter.LatLng(), testPoints[name].LatLng()); math.Abs(distance-want) > 1e-6 {
+				t.Errorf("%s: got distance %v, want %v", name, distance, want)
+			}
+			bearing, ok, err := e.Float(item, "bearing")
+			if err != nil || !ok {
+				t.Fatalf("%s: got no bearing, error %v", name, err)
+			}
+			if quadrant := bearings[name]; bearing < quadrant[0] || bearing >= quadrant[1] {
+				t.Errorf("%s: got bearing %v, want within %v", name, bearing, quadrant)
+			}
+		}
+	}
+
+	// without annotations the items are left alone
+	table := newFakeTable(t, e, testPoints)
+	items, err := e.Query(context.Background(), q, table.request(Options{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range items {
+		if _, ok := item["distance"]; ok {
+			t.Errorf("%s: got a distance without DistanceAttributeName", testName(e, item))
+		}
+	}
+}
+
+func TestCancellationStopsQueries(t *testing.T) {
+	e := newTestEngine()
+	q, err := NewRadiusQuery(testCenter, 6000, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queries := map[string]func(ctx context.Context, req Request[*testValue]) error{
+		"query": func(ctx context.Context, req Request[*testValue]) error {
+			_, err := e.Query(ctx, q, req)
+			return err
+		},
+		"count": func(ctx context.Context, req Request[*testValue]) error {
+			_, err := e.Count(ctx, q, req)
+			return err
+		},
+		"page": func(ctx context.Context, req Request[*testValue]) error {
+			_, _, err := e.QueryPage(ctx, q, req, PageInput{})
+			return err
+		},
+		"iterator": func(ctx context.Context, req Request[*testValue]) error {
+			it := e.Iterate(ctx, q, req)
+			defer it.Close()
+			for it.Next() {
+			}
+			return it.Err()
+		},
+	}
+	for name, query := range queries {
+		t.Run(name, func(t *testing.T) {
+			table := newFakeTable(t, e, testPoints)
+			if err := query(context.Background(), table.request(Options{MaxConcurrency: 1})); err != nil {
+				t.Fatal(err)
+			}
+			total := table.reads
+			if total < 3 {
+				t.Fatalf("got %d reads, want several", total)
+			}
+
+			// the first read cancels the query, e.g. as the client went away
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			table = newFakeTable(t, e, testPoints)
+			table.fail = func(hashKey uint64, r Range, startKey map[string]*testValue) error {
+				cancel()
+				return nil
+			}
+			err := query(ctx, table.request(Options{MaxConcurrency: 1}))
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("got error %v, want context.Canceled", err)
+			}
+
+			table.mtx.Lock()
+			defer table.mtx.Unlock()
+			if table.reads > 2 {
+				t.Errorf("got %d of %d reads after cancelling, want at most 2", table.reads, total)
+			}
+		})
+	}
+}
+
+func TestNearest(t *testing.T) {
+	e := newTestEngine()
+	byDistance := make([]string, 0, len(testPoints))
+	for name := range testPoints {
+		byDistance = append(byDistance, name)
+	}
+	sort.Slice(byDistance, func(i, j int) bool {
+		return EarthDistance(testCenter.LatLng(), testPoints[byDistance[i]].LatLng()) <
+			EarthDistance(testCenter.LatLng(), testPoints[byDistance[j]].LatLng())
+	})
+
+	within5km := []string{}
+	for _, name := range byDistance {
+		if EarthDistance(testCenter.LatLng(), testPoints[name].LatLng()) <= 5000 {
+			within5km = append(within5km, name)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		k           int
+		maxDistance int
+		want        []string
+		maxReads    int
+	}{
+		{name: "closest", k: 1, want: byDistance[:1], maxReads: 10},
+		{name: "k nearest in order", k: 4, want: byDistance[:4]},
+		{name: "max distance", k: 8, maxDistance: 5000, want: within5km},
+		// without a maximum distance the search stops at MAX_NEAREST_QUERIES
+		// instead of covering the earth, short of philadelphia 130km away
+		{name: "fewer than k", k: 100, want: byDistance[:len(byDistance)-1], maxReads: MAX_NEAREST_QUERIES},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q, err := NewNearestQuery(testCenter, test.k, test.maxDistance, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			table := newFakeTable(t, e, testPoints)
+			items, err := e.Nearest(context.Background(), q, table.request(Options{}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := testNames(e, items); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+			if test.maxReads > 0 && table.reads > test.maxReads {
+				t.Errorf("got %d reads, want at most %d", table.reads, test.maxReads)
+			}
+		})
+	}
+}
+
+func TestCoverLevels(t *testing.T) {
+	tests := []struct {
+		min, max         int
+		wantMin, wantMax int
+		wantErr          string
+	}{
+		{min: 0, max: 0, wantMin: 10, wantMax: 10},
+		{min: 0, max: 12, wantMin: 0, wantMax: 12},
+		{min: 0, max: 5, wantMin: 0, wantMax: 5},
+		{min: 5, max: 0, wantMin: 5, wantMax: 10},
+		{min: 12, max: 0, wantMin: 12, wantMax: 12},
+		{min: -1, max: 10, wantErr: "MinCellLevel is -1"},
+		{min: 0, max: -2, wantErr: "MaxCellLevel is -2"},
+		{min: 3, max: 31, wantErr: "MaxCellLevel is 31"},
+		{min: 12, max: 11, wantErr: "MinCellLevel 12 is greater than MaxCellLevel 11"},
+	}
+	for _, test := range tests {
+		min, max, err := CoverLevels(test.min, test.max)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%d, %d: got error %v, want %q", test.min, test.max, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil || min != test.wantMin || max != test.wantMax {
+			t.Errorf("%d, %d: got %d, %d, error %v, want %d, %d", test.min, test.max, min, max, err, test.wantMin, test.wantMax)
+		}
+	}
+}
+
+func TestAdaptiveCoveringRanges(t *testing.T) {
+	hashers := map[string]Hasher{
+		"decimal prefix": {Length: 2},
+		"long prefix":    {Length: 5},
+		"parent cell 8":  {Scheme: ParentCellHashKey, CellLevel: 8},
+		"parent cell 10": {Scheme: ParentCellHashKey, CellLevel: 10},
+	}
+	tests := []struct {
+		hasher    string
+		radius    int
+		maxRanges int
+		tooLarge  bool
+	}{
+		{hasher: "decimal prefix", radius: 100, maxRanges: 10},
+		{hasher: "decimal prefix", radius: 200000, maxRanges: 10},
+		{hasher: "long prefix", radius: 100, maxRanges: 10},
+		{hasher: "long prefix", radius: 200000, maxRanges: MAX_ADAPTIVE_CELLS},
+		{hasher: "parent cell 8", radius: 100, maxRanges: 10},
+		{hasher: "parent cell 8", radius: 200000, maxRanges: MAX_ADAPTIVE_CELLS},
+		{hasher: "parent cell 10", radius: 100, maxRanges: 10},
+		// about 1500 hash keys of level 10 cells
+		{hasher: "parent cell 10", radius: 200000, tooLarge: true},
+	}
+	for _, test := range tests {
+		e := Engine[*testValue]{
+			Codec:            Codec[*testValue]{Schema: Schema{Hasher: hashers[test.hasher]}},
+			Coverer:          s2.RegionCoverer{MaxCells: 10},
+			AdaptiveCovering: true,
+		}
+		q, err := NewRadiusQuery(testCenter, test.radius, 0, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cells, err := e.cover(q)
+		if test.tooLarge {
+			if !errors.Is(err, ErrRegionTooLarge) {
+				t.Errorf("%s, %dm: got error %v, want ErrRegionTooLarge", test.hasher, test.radius, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashRanges, _ := e.Hasher.Ranges(cells)
+		if len(hashRanges) == 0 || len(hashRanges) > test.maxRanges {
+			t.Errorf("%s, %dm: got %d ranges, want 1 to %d", test.hasher, test.radius, len(hashRanges), test.maxRanges)
+		}
+		if !cells.ContainsCellID(s2.CellIDFromLatLng(testCenter.LatLng())) {
+			t.Errorf("%s, %dm: covering misses the center", test.hasher, test.radius)
+		}
+	}
+}
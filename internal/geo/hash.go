@@ -0,0 +1,292 @@
+package geo
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/golang/geo/s2"
+)
+
+// HashKeyScheme defines how the hash key of an item is derived from its geohash.
+type HashKeyScheme int
+
+const (
+	// DecimalPrefixHashKey uses the first HashKeyLength decimal digits of the
+	// geohash as hash key.
+	DecimalPrefixHashKey HashKeyScheme = iota
+	// ParentCellHashKey uses the ID of the geohash's ancestor cell at
+	// HashKeyCellLevel as hash key, so that every hash key covers exactly one
+	// S2 cell.
+	ParentCellHashKey
+)
+
+// MERGE_THRESHOLD is the largest gap between two geohash ranges that are
+// merged into one. Geohashes are odd leaf cell IDs, so ranges of adjacent
+// cells are 2 apart.
+const MERGE_THRESHOLD = 2
+
+// MAX_CELL_LEVEL is the level of S2 leaf cells.
+const MAX_CELL_LEVEL = 30
+
+// MAX_ADAPTIVE_CELLS limits the cells of an adaptive covering.
+const MAX_ADAPTIVE_CELLS = 1000
+
+// Hasher derives geohashes and hash keys following a HashKeyScheme. Length is
+// the hash key length of DecimalPrefixHashKey, CellLevel the cell level of
+// ParentCellHashKey.
+type Hasher struct {
+	Scheme    HashKeyScheme
+	Length    int8
+	CellLevel int
+}
+
+// GeoHash returns the geohash of the location, the ID of its leaf cell.
+func GeoHash(latLng s2.LatLng) uint64 {
+	return uint64(s2.CellIDFromLatLng(latLng))
+}
+
+// Hashes returns the geohash and the hash key of the location.
+func (h Hasher) Hashes(latLng s2.LatLng) (uint64, uint64) {
+	geoHash := GeoHash(latLng)
+
+	return geoHash, h.HashKey(geoHash)
+}
+
+// HashKey returns the hash key of the geohash.
+func (h Hasher) HashKey(geoHash uint64) uint64 {
+	if h.Scheme == ParentCellHashKey {
+		return generateParentCellHashKey(geoHash, h.CellLevel)
+	}
+
+	return generateHashKey(geoHash, h.Length)
+}
+
+// Level returns the cell level matching the size of a hash key partition.
+func (h Hasher) Level() int {
+	if h.Scheme == ParentCellHashKey {
+		return h.CellLevel
+	}
+
+	return hashKeyLevel(h.Length)
+}
+
+// Range is a range of geohashes within a single hash key.
+type Range struct {
+	Min uint64
+	Max uint64
+}
+
+// tryMerge extends the range by r if both overlap or are at most
+// MERGE_THRESHOLD apart. As geohashes are odd leaf cell IDs, this merges the
+// ranges of adjacent cells without adding any geohashes in between.
+func (g *Range) tryMerge(r Range) bool {
+	if r.Min > g.Max+MERGE_THRESHOLD || g.Min > r.Max+MERGE_THRESHOLD {
+		return false
+	}
+
+	if r.Min < g.Min {
+		g.Min = r.Min
+	}
+	if r.Max > g.Max {
+		g.Max = r.Max
+	}
+
+	return true
+}
+
+func (g Range) trySplit(hashKeyLength int8) []Range {
+	result := []Range{}
+
+	minHashKey := generateHashKey(g.Min, hashKeyLength)
+	maxHashKey := generateHashKey(g.Max, hashKeyLength)
+
+	rangeMinHashString := strconv.FormatUint(g.Min, 10)
+	minHashKeyString := strconv.FormatUint(minHashKey, 10)
+	denominator := uint64(math.Pow10(len(rangeMinHashString) - len(minHashKeyString)))
+
+	if minHashKey == maxHashKey {
+		result = append(result, g)
+	} else {
+		for m := minHashKey; m <= maxHashKey; m++ {
+			var min uint64
+			var max uint64
+
+			if m > 0 {
+				if m == minHashKey {
+					min = g.Min
+				} else {
+					min = m * denominator
+				}
+				if m == maxHashKey {
+					max = g.Max
+				} else {
+					max = (m+1)*denominator - 1
+				}
+			} else {
+				if m == minHashKey {
+					min = g.Min
+				} else {
+					min = (m-1)*denominator + 1
+				}
+
+				if m == maxHashKey {
+					max = g.Max
+				} else {
+					max = m * denominator
+				}
+			}
+
+			result = append(result, Range{Min: min, Max: max})
+		}
+	}
+
+	return result
+}
+
+// splitByParentCell splits the range into one range per ancestor cell at
+// the given level, so that every resulting range lies within a single hash key.
+func (g Range) splitByParentCell(level int) []Range {
+	result := []Range{}
+
+	for c := s2.CellID(g.Min).Parent(level); c.IsValid() && uint64(c.RangeMin()) <= g.Max; c = c.Next() {
+		min := uint64(c.RangeMin())
+		if min < g.Min {
+			min = g.Min
+		}
+		max := uint64(c.RangeMax())
+		if max > g.Max {
+			max = g.Max
+		}
+
+		result = append(result, Range{Min: min, Max: max})
+	}
+
+	return result
+}
+
+// Ranges returns the geohash ranges to query for the cells, split by hash key
+// and sorted. Overlapping or adjacent ranges of the same hash key are merged,
+// so every geohash is queried once. It also returns the number of queries
+// saved by merging.
+func (h Hasher) Ranges(cellIDs []s2.CellID) ([]Range, int) {
+	ranges := []Range{}
+
+	for _, cellID := range cellIDs {
+		gh := Range{Min: uint64(cellID.RangeMin()), Max: uint64(cellID.RangeMax())}
+		if h.Scheme == ParentCellHashKey {
+			ranges = append(ranges, gh.splitByParentCell(h.CellLevel)...)
+		} else {
+			ranges = append(ranges, gh.trySplit(h.Length)...)
+		}
+	}
+
+	merged := h.merge(ranges)
+
+	return merged, len(ranges) - len(merged)
+}
+
+// merge sorts the ranges by hash key and geohash and merges overlapping or
+// adjacent ranges of the same hash key.
+func (h Hasher) merge(ranges []Range) []Range {
+	sort.Slice(ranges, func(i, j int) bool {
+		hi, hj := h.HashKey(ranges[i].Min), h.HashKey(ranges[j].Min)
+		if hi != hj {
+			return hi < hj
+		}
+		return ranges[i].Min < ranges[j].Min
+	})
+
+	merged := []Range{}
+	for _, r := range ranges {
+		last := len(merged) - 1
+		if last >= 0 && h.HashKey(merged[last].Min) == h.HashKey(r.Min) && merged[last].tryMerge(r) {
+			continue
+		}
+		merged = append(merged, r)
+	}
+
+	return merged
+}
+
+func generateHashKey(geoHash uint64, hashKeyLength int8) uint64 {
+	if geoHash < 0 {
+		hashKeyLength++
+	}
+
+	geoHashString := strconv.FormatUint(geoHash, 10)
+	denominator := math.Pow10(len(geoHashString) - int(hashKeyLength))
+
+	return geoHash / uint64(denominator)
+}
+
+// generateParentCellHashKey returns the ID of the geohash's ancestor cell at
+// the given level.
+func generateParentCellHashKey(geoHash uint64, level int) uint64 {
+	return uint64(s2.CellID(geoHash).Parent(level))
+}
+
+// hashKeyLevel returns the lowest cell level whose cells are not larger than
+// a hash key partition. Hash keys are the first hashKeyLength decimal digits
+// of the usually 19 digit cell ID, while a cell at level l spans 2^(61-2l)
+// IDs. Coarser cells have to be split into a query per hash key.
+func hashKeyLevel(hashKeyLength int8) int {
+	partitionBits := float64(19-int(hashKeyLength)) * math.Log2(10)
+	level := int(math.Ceil((61 - partitionBits) / 2))
+
+	if level < 0 {
+		return 0
+	}
+	if level > MAX_CELL_LEVEL {
+		return MAX_CELL_LEVEL
+	}
+
+	return level
+}
+
+// AdaptiveCoverer lets the coverer pick cells of any level for the region,
+// using at most maxCells cells. A region spanning more hash key partitions
+// than that needs a query per partition anyway, so it may be covered with up
+// to as many cells as partitions, which wastes fewer reads on its boundary.
+// partitionLevel is the cell level matching the size of a hash key partition.
+// Cells are never coarser than a partition, as those would have to be split
+// into a query per partition. Regions spanning more than MAX_ADAPTIVE_CELLS
+// partitions fail with ErrRegionTooLarge.
+func AdaptiveCoverer(region s2.Region, partitionLevel int, maxCells int) (s2.RegionCoverer, error) {
+	partitions := partitionCount(region, partitionLevel)
+	if partitions > MAX_ADAPTIVE_CELLS {
+		return s2.RegionCoverer{}, fmt.Errorf("%w: more than %d hash keys", ErrRegionTooLarge, MAX_ADAPTIVE_CELLS)
+	}
+	if partitions > maxCells {
+		maxCells = partitions
+	}
+
+	return s2.RegionCoverer{
+		MinLevel: partitionLevel,
+		MaxLevel: MAX_CELL_LEVEL,
+		MaxCells: maxCells,
+	}, nil
+}
+
+// partitionCount returns an upper bound of the hash key partitions the region
+// spans, counted in a coarse covering, so that it stays cheap for regions
+// spanning too many of them. Coarse cells are counted as all partitions they
+// contain.
+func partitionCount(region s2.Region, partitionLevel int) int {
+	coverer := s2.RegionCoverer{MinLevel: 0, MaxLevel: partitionLevel, MaxCells: MAX_ADAPTIVE_CELLS}
+
+	count := 0
+	for _, cellID := range coverer.Covering(region) {
+		shift := 2 * (partitionLevel - cellID.Level())
+		if shift >= 30 {
+			return MAX_ADAPTIVE_CELLS + 1
+		}
+		count += 1 << uint(shift)
+		if count > MAX_ADAPTIVE_CELLS {
+			return count
+		}
+	}
+
+	return count
+}
@@ -0,0 +1,189 @@
+package geo
+
+import (
+	"context"
+	"sync"
+)
+
+// Iterator streams the results of a query as the pages of the single hash
+// ranges arrive, see QueryIterator.
+type Iterator[V any] struct {
+	items chan map[string]V
+	done  chan struct{}
+	item  map[string]V
+
+	closeOnce sync.Once
+	mtx       sync.Mutex
+	err       error
+	failures  []RangeError
+}
+
+func newIterator[V any]() *Iterator[V] {
+	return &Iterator[V]{
+		items: make(chan map[string]V),
+		done:  make(chan struct{}),
+	}
+}
+
+// FailedIterator returns an iterator stopped by err, e.g. of an invalid
+// query.
+func FailedIterator[V any](err error) *Iterator[V] {
+	it := newIterator[V]()
+	it.fail(err)
+
+	return it
+}
+
+// Next advances the iterator to the next item. It returns false once all
+// items have been read, an error occurred or the iterator has been closed.
+func (it *Iterator[V]) Next() bool {
+	select {
+	case <-it.done:
+		return false
+	default:
+	}
+
+	select {
+	case item, ok := <-it.items:
+		if !ok {
+			return false
+		}
+		it.item = item
+		return true
+	case <-it.done:
+		return false
+	}
+}
+
+// Item returns the current item.
+func (it *Iterator[V]) Item() map[string]V {
+	return it.item
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *Iterator[V]) Err() error {
+	it.mtx.Lock()
+	defer it.mtx.Unlock()
+
+	return it.err
+}
+
+// Close stops the iteration. No further DynamoDB queries are issued and the
+// goroutines querying the ranges exit. It has to be called if the iteration
+// ends before Next returned false, and may be called any number of times.
+func (it *Iterator[V]) Close() {
+	it.closeOnce.Do(func() {
+		close(it.done)
+	})
+}
+
+func (it *Iterator[V]) fail(err error) {
+	it.mtx.Lock()
+	if it.err == nil {
+		it.err = err
+	}
+	it.mtx.Unlock()
+
+	it.Close()
+}
+
+// failRange records the failed range. Unless partial results were requested,
+// it stops the iteration.
+func (it *Iterator[V]) failRange(failure RangeError, partial bool) {
+	if !partial {
+		it.fail(&QueryError{Failures: []RangeError{failure}})
+		return
+	}
+
+	it.mtx.Lock()
+	it.failures = append(it.failures, failure)
+	it.mtx.Unlock()
+}
+
+// send hands the item to the consumer and reports whether it is still
+// reading.
+func (it *Iterator[V]) send(item map[string]V) bool {
+	select {
+	case it.items <- item:
+		return true
+	case <-it.done:
+		return false
+	}
+}
+
+// Iterate queries the hash ranges of the covering concurrently, page by page,
+// at most MaxConcurrency at the same time. As the items channel is
+// unbuffered, a range only requests its next page once the consumer has read
+// all items of the previous one.
+func (e Engine[V]) Iterate(ctx context.Context, q Shape, req Request[V]) *Iterator[V] {
+	cells, err := e.cover(q)
+	if err != nil {
+		return FailedIterator[V](err)
+	}
+
+	it := newIterator[V]()
+	hashRanges := e.ranges(cells, req.Options)
+	filter := e.annotator(q, req.Options)
+
+	// cancelling the context stops the iteration like Close
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			it.fail(ctx.Err())
+		case <-it.done:
+		case <-finished:
+		}
+	}()
+
+	go func() {
+		RunTasks(ctx, len(hashRanges), e.concurrency(req.Options), func(i int) {
+			g := hashRanges[i]
+			hashKey := e.Hasher.HashKey(g.Min)
+
+			var startKey map[string]V
+			for {
+				select {
+				case <-it.done:
+					return
+				default:
+				}
+
+				output, err := req.Query(ctx, hashKey, g, startKey, 0)
+				if err != nil {
+					it.failRange(NewRangeError(hashKey, g, err), req.AllowPartialResults)
+					return
+				}
+
+				filtered, err := filter(output.Items)
+				if err != nil {
+					it.fail(err)
+					return
+				}
+				for _, item := range filtered {
+					if !it.send(item) {
+						return
+					}
+				}
+
+				startKey = output.LastEvaluatedKey
+				if len(startKey) == 0 {
+					return
+				}
+			}
+		})
+
+		close(finished)
+		it.mtx.Lock()
+		if it.err == nil {
+			it.err = ctx.Err()
+		}
+		if it.err == nil {
+			it.err = NewQueryError(it.failures)
+		}
+		it.mtx.Unlock()
+		close(it.items)
+	}()
+
+	return it
+}
@@ -0,0 +1,118 @@
+package geo
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+
+	"github.com/golang/geo/s2"
+)
+
+// MAX_NEAREST_QUERIES bounds the geohash ranges a nearest search queries,
+// summed over all of its rounds. Without a maximum distance, the search would
+// otherwise grow up to the whole earth when there are fewer than K points.
+const MAX_NEAREST_QUERIES = 1000
+
+// Nearest searches outward in growing caps around the center point. Each
+// round only queries the cells that were not covered by a previous round. As
+// the covering of a cap contains the whole cap, every point in a cell not yet
+// queried is farther away than the current radius, so the search can stop as
+// soon as the Kth closest candidate lies within it. It also stops before a
+// round would take the search beyond MAX_NEAREST_QUERIES ranges, and then only
+// returns the candidates within the radius searched so far. It returns at most
+// K results, closest first, annotated as requested.
+func (e Engine[V]) Nearest(ctx context.Context, q NearestQuery, req Request[V]) ([]map[string]V, error) {
+	center := s2.PointFromLatLng(q.center)
+
+	maxRadius := math.Pi * EARTH_RADIUS_METERS
+	if q.maxDistance > 0 && q.maxDistance < maxRadius {
+		maxRadius = q.maxDistance
+	}
+	level := e.nearestLevel()
+	radius := s2.AvgEdgeMetric.Value(level) * EARTH_RADIUS_METERS
+	searched := 0.0
+	queries := 0
+
+	candidates := []DistanceItem[V]{}
+	queried := s2.CellUnion{}
+	var queryErr error
+	for {
+		radius = math.Min(radius, maxRadius)
+		capRegion := s2.CapFromCenterAngle(center, Angle(radius))
+		// estimate the ranges before covering, which takes long for caps
+		// much larger than the cells
+		if searched > 0 && capRegion.Area()/s2.AvgAreaMetric.Value(level) > MAX_NEAREST_QUERIES {
+			break
+		}
+		covering, err := e.Cover(capRegion)
+		if errors.Is(err, ErrRegionTooLarge) && searched > 0 {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		cells := s2.CellUnionFromDifference(covering, queried)
+		hashRanges, _ := e.Hasher.Ranges(cells)
+		if searched > 0 && queries+len(hashRanges) > MAX_NEAREST_QUERIES {
+			break
+		}
+		queries += len(hashRanges)
+
+		if len(cells) > 0 {
+			items, err := e.Dispatch(ctx, cells, req)
+			if req.Failed(err) {
+				return nil, err
+			}
+			queryErr = MergeQueryErrors(queryErr, err)
+
+			for _, item := range items {
+				latLng, err := e.LatLng(item)
+				if err != nil {
+					return nil, err
+				}
+
+				distance := EarthDistance(q.center, latLng)
+				if distance <= maxRadius {
+					candidates = append(candidates, DistanceItem[V]{Item: item, LatLng: latLng, Distance: distance})
+				}
+			}
+			queried = s2.CellUnionFromUnion(queried, cells)
+		}
+		searched = radius
+
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].Distance < candidates[j].Distance
+		})
+
+		if len(candidates) >= q.k && candidates[q.k-1].Distance <= radius {
+			break
+		}
+		if radius >= maxRadius {
+			break
+		}
+		radius *= 2
+	}
+
+	// candidates beyond the searched radius may be farther away than points in
+	// cells not queried
+	n := sort.Search(len(candidates), func(i int) bool {
+		return candidates[i].Distance > searched
+	})
+	if n > q.k {
+		n = q.k
+	}
+
+	return e.Annotate(candidates[:n], q.center, req.Options), queryErr
+}
+
+// nearestLevel returns the level of the finest cells a nearest search has to
+// query, which the estimate of its ranges is based on.
+func (e Engine[V]) nearestLevel() int {
+	level := e.Hasher.Level()
+	if !e.AdaptiveCovering && e.Coverer.MinLevel > level {
+		level = e.Coverer.MinLevel
+	}
+
+	return level
+}
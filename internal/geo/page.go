@@ -0,0 +1,235 @@
+package geo
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+)
+
+// PageInput limits paged queries to Limit results per page.
+// ContinuationToken resumes the query where the previous page ended.
+type PageInput struct {
+	Limit             int
+	ContinuationToken string
+}
+
+// ErrPageInput is the error of queries reading all results at once whose
+// input sets Limit or ContinuationToken, which only paged queries read.
+var ErrPageInput = errors.New("Limit and ContinuationToken are only read by paged queries")
+
+// ValidateUnpaged checks that the page input of a query reading all results
+// at once is unset.
+func ValidateUnpaged(page PageInput) error {
+	if page.Limit != 0 || page.ContinuationToken != "" {
+		return ErrPageInput
+	}
+
+	return nil
+}
+
+// PageSummary summarizes a query page. ContinuationToken is empty once all
+// results have been read.
+type PageSummary struct {
+	Count             int64
+	ScannedCount      int64
+	CapacityUnits     float64
+	ContinuationToken string
+}
+
+// continuationToken is the decoded form of the opaque token handed out with a
+// page. The page ended in the hash range at Range, all ranges before it have
+// been read completely. With Resume, the query continues within that range,
+// after LastEvaluatedKey or, if the range failed before returning a page, from
+// its start. Otherwise the range has been read completely as well and the
+// query continues with the next one. RangeMin and RangeMax guard against
+// tokens being used with a different query. Both SDK flavours use the same
+// encoding, so tokens can be passed between them.
+type continuationToken struct {
+	Range            int                      `json:"e"`
+	Resume           bool                     `json:"r,omitempty"`
+	RangeMin         uint64                   `json:"min"`
+	RangeMax         uint64                   `json:"max"`
+	LastEvaluatedKey map[string]tokenKeyValue `json:"k,omitempty"`
+}
+
+// tokenKeyValue holds a key attribute, which can only be a string, number or
+// binary.
+type tokenKeyValue struct {
+	S *string `json:"S,omitempty"`
+	N *string `json:"N,omitempty"`
+	B []byte  `json:"B,omitempty"`
+}
+
+// newContinuationToken returns the token of a page ending in the hash range
+// at i, see continuationToken. It is empty if there is nothing left to read.
+func (c Codec[V]) newContinuationToken(hashRanges []Range, i int, resume bool, lastEvaluatedKey map[string]V) (string, error) {
+	if !resume && i == len(hashRanges)-1 {
+		return "", nil
+	}
+
+	token := continuationToken{
+		Range:    i,
+		Resume:   resume,
+		RangeMin: hashRanges[i].Min,
+		RangeMax: hashRanges[i].Max,
+	}
+	if len(lastEvaluatedKey) > 0 {
+		token.LastEvaluatedKey = map[string]tokenKeyValue{}
+		for k, v := range lastEvaluatedKey {
+			if s, ok := c.String(v); ok {
+				token.LastEvaluatedKey[k] = tokenKeyValue{S: &s}
+			} else if n, ok := c.Number(v); ok {
+				token.LastEvaluatedKey[k] = tokenKeyValue{N: &n}
+			} else if b, ok := c.Binary(v); ok {
+				token.LastEvaluatedKey[k] = tokenKeyValue{B: b}
+			} else {
+				return "", errors.New("key attributes need to be strings, numbers or binaries")
+			}
+		}
+	}
+
+	data, err := json.Marshal(token)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeContinuationToken(s string) (*continuationToken, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid continuation token")
+	}
+
+	token := continuationToken{}
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, errors.New("invalid continuation token")
+	}
+
+	return &token, nil
+}
+
+func (c Codec[V]) lastEvaluatedKey(t continuationToken) map[string]V {
+	if t.LastEvaluatedKey == nil {
+		return nil
+	}
+
+	key := map[string]V{}
+	for k, v := range t.LastEvaluatedKey {
+		switch {
+		case v.S != nil:
+			key[k] = c.NewString(*v.S)
+		case v.N != nil:
+			key[k] = c.NewNumber(*v.N)
+		default:
+			key[k] = c.NewBinary(v.B)
+		}
+	}
+
+	return key
+}
+
+// QueryPage reads the hash ranges of the covering one after another, in the
+// order of their geohashes, until page.Limit items passed the filter. Reading
+// the ranges sequentially keeps the order stable across pages.
+// If a range fails with partial results allowed, the page ends early and its
+// token continues with the failed range.
+func (e Engine[V]) QueryPage(ctx context.Context, q Shape, req Request[V], page PageInput) ([]map[string]V, PageSummary, error) {
+	if page.Limit < 0 {
+		return nil, PageSummary{}, errors.New("Limit needs to be at least 0")
+	}
+	token, err := decodeContinuationToken(page.ContinuationToken)
+	if err != nil {
+		return nil, PageSummary{}, err
+	}
+
+	cells, err := e.cover(q)
+	if err != nil {
+		return nil, PageSummary{}, err
+	}
+	hashRanges := e.ranges(cells, req.Options)
+	sort.SliceStable(hashRanges, func(i, j int) bool {
+		return hashRanges[i].Min < hashRanges[j].Min
+	})
+
+	first := 0
+	var startKey map[string]V
+	if token != nil {
+		if token.Range < 0 || token.Range >= len(hashRanges) ||
+			hashRanges[token.Range].Min != token.RangeMin ||
+			hashRanges[token.Range].Max != token.RangeMax {
+			return nil, PageSummary{}, errors.New("continuation token does not match the query")
+		}
+		first = token.Range
+		if token.Resume {
+			startKey = e.lastEvaluatedKey(*token)
+		} else {
+			first++
+		}
+	}
+
+	filter := e.annotator(q, req.Options)
+	results := []map[string]V{}
+	summary := PageSummary{}
+	var queryErr error
+
+	for i := first; i < len(hashRanges) && summary.ContinuationToken == ""; i++ {
+		g := hashRanges[i]
+		hashKey := e.Hasher.HashKey(g.Min)
+
+		for {
+			limit := 0
+			if page.Limit > 0 {
+				limit = page.Limit - len(results)
+			}
+
+			output, err := req.Query(ctx, hashKey, g, startKey, limit)
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, PageSummary{}, ctxErr
+			}
+			if err != nil {
+				queryErr = &QueryError{Failures: []RangeError{NewRangeError(hashKey, g, err)}}
+				if !req.AllowPartialResults {
+					return nil, PageSummary{}, queryErr
+				}
+
+				// end the page here, the token retries the failed range
+				summary.ContinuationToken, err = e.newContinuationToken(hashRanges, i, true, startKey)
+				if err != nil {
+					return nil, PageSummary{}, err
+				}
+				break
+			}
+			summary.ScannedCount += output.ScannedCount
+			summary.CapacityUnits += output.CapacityUnits
+
+			filtered, err := filter(output.Items)
+			if err != nil {
+				return nil, PageSummary{}, err
+			}
+			results = append(results, filtered...)
+			startKey = output.LastEvaluatedKey
+
+			if page.Limit > 0 && len(results) >= page.Limit {
+				summary.ContinuationToken, err = e.newContinuationToken(hashRanges, i, len(startKey) > 0, startKey)
+				if err != nil {
+					return nil, PageSummary{}, err
+				}
+				break
+			}
+			if len(startKey) == 0 {
+				break
+			}
+		}
+	}
+	summary.Count = int64(len(results))
+
+	return results, summary, queryErr
+}
@@ -0,0 +1,82 @@
+package geo
+
+import (
+	"context"
+	"sync"
+)
+
+// WorkerPool limits the number of DynamoDB queries in flight. It is shared by
+// all queries of a DynGeo instance, so concurrent geo queries together never
+// exceed the limit.
+type WorkerPool struct {
+	slots chan struct{}
+}
+
+// NewWorkerPool returns a pool with size slots. A size of 0 or less means no
+// limit.
+func NewWorkerPool(size int) *WorkerPool {
+	if size <= 0 {
+		return &WorkerPool{}
+	}
+
+	return &WorkerPool{
+		slots: make(chan struct{}, size),
+	}
+}
+
+// Acquire blocks until a slot is free or the context is done.
+func (p *WorkerPool) Acquire(ctx context.Context) error {
+	if p == nil || p.slots == nil {
+		return ctx.Err()
+	}
+
+	select {
+	case p.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release frees the slot taken by Acquire.
+func (p *WorkerPool) Release() {
+	if p == nil || p.slots == nil {
+		return
+	}
+
+	<-p.slots
+}
+
+// RunTasks calls task for every index in [0, n) using at most limit
+// goroutines at the same time, or one per task if limit is 0 or less. Tasks
+// not started yet are skipped once the context is done.
+func RunTasks(ctx context.Context, n int, limit int, task func(i int)) {
+	workers := n
+	if limit > 0 && limit < workers {
+		workers = limit
+	}
+
+	next := make(chan int)
+	wg := &sync.WaitGroup{}
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				task(i)
+			}
+		}()
+	}
+
+feed:
+	for i := 0; i < n; i++ {
+		select {
+		case next <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(next)
+
+	wg.Wait()
+}
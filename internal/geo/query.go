@@ -0,0 +1,329 @@
+package geo
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/golang/geo/s1"
+	"github.com/golang/geo/s2"
+)
+
+// SortOrder defines how query results are ordered by their distance to the
+// query center.
+type SortOrder int
+
+const (
+	// SortNone keeps the order the query found the results in.
+	SortNone SortOrder = iota
+	SortAscending
+	SortDescending
+)
+
+func lessByDistance(order SortOrder, a float64, b float64) bool {
+	if order == SortDescending {
+		return a > b
+	}
+
+	return a < b
+}
+
+// QueryStats reports how many DynamoDB queries a geo query issued for its
+// geohash ranges and how many were saved by merging adjacent ranges.
+type QueryStats struct {
+	Queries      int
+	SavedQueries int
+}
+
+// Record adds the queries of a covering to the stats, which may be nil.
+func (stats *QueryStats) Record(queries, saved int) {
+	if stats == nil {
+		return
+	}
+
+	stats.Queries += queries
+	stats.SavedQueries += saved
+}
+
+// Options are the options of a geo query that don't depend on the SDK
+// flavour, see GeoQueryInput.
+type Options struct {
+	DistanceAttributeName string
+	BearingAttributeName  string
+	SortByDistance        SortOrder
+	Stats                 *QueryStats
+	AllowPartialResults   bool
+	MaxConcurrency        int
+}
+
+// Annotated reports whether distances to the query center are needed.
+func (o Options) Annotated() bool {
+	return o.DistanceAttributeName != "" || o.BearingAttributeName != "" || o.SortByDistance != SortNone
+}
+
+// Failed reports whether err aborts the query. Failed geohash ranges don't if
+// partial results were requested.
+func (o Options) Failed(err error) bool {
+	var queryErr *QueryError
+	return err != nil && !(o.AllowPartialResults && errors.As(err, &queryErr))
+}
+
+// Shape is the region of a geo query together with the exact test which of
+// the items found in its covering are results.
+type Shape interface {
+	// Region is covered to find the geohash ranges to query.
+	Region() s2.Region
+	// Contains reports whether the location of an item is a result.
+	Contains(latLng s2.LatLng) bool
+	// Center is the point distances and bearings of results are measured
+	// from.
+	Center() s2.LatLng
+}
+
+// RadiusQuery finds the points within a radius around a center point and, with
+// a minimum radius, not closer than that.
+type RadiusQuery struct {
+	center    s2.LatLng
+	radius    float64
+	minRadius float64
+}
+
+// NewRadiusQuery validates the query, see QueryRadiusInput.
+func NewRadiusQuery(center GeoPoint, radius int, minRadius int, normalizeLongitude bool) (RadiusQuery, error) {
+	center, err := ValidGeoPoint("CenterPoint", center, normalizeLongitude)
+	if err != nil {
+		return RadiusQuery{}, err
+	}
+	if err := ValidateRadius("RadiusInMeter", radius, 1); err != nil {
+		return RadiusQuery{}, err
+	}
+	if err := ValidateRadius("MinRadiusInMeter", minRadius, 0); err != nil {
+		return RadiusQuery{}, err
+	}
+	if minRadius >= radius {
+		return RadiusQuery{}, fmt.Errorf("%w: MinRadiusInMeter needs to be less than RadiusInMeter", ErrInvalidRadius)
+	}
+
+	return RadiusQuery{center: center.LatLng(), radius: float64(radius), minRadius: float64(minRadius)}, nil
+}
+
+// Region returns a cap or, with a minimum radius, a ring. Unlike a
+// latitude/longitude rectangle a cap has no trouble with the 180 degree
+// longitude line or the poles.
+func (q RadiusQuery) Region() s2.Region {
+	center := s2.PointFromLatLng(q.center)
+	if q.minRadius > 0 {
+		return NewAnnulus(center, Angle(q.minRadius), Angle(q.radius))
+	}
+
+	return s2.CapFromCenterAngle(center, Angle(q.radius))
+}
+
+func (q RadiusQuery) Contains(latLng s2.LatLng) bool {
+	distance := EarthDistance(q.center, latLng)
+	return distance <= q.radius && distance >= q.minRadius
+}
+
+func (q RadiusQuery) Center() s2.LatLng {
+	return q.center
+}
+
+// RectangleQuery finds the points within a latitude/longitude rectangle.
+type RectangleQuery struct {
+	rect s2.Rect
+}
+
+// NewRectangleQuery validates the query, see QueryRectangleInput.
+func NewRectangleQuery(minPoint *GeoPoint, maxPoint *GeoPoint, normalizeLongitude bool) (RectangleQuery, error) {
+	if minPoint == nil || maxPoint == nil {
+		return RectangleQuery{}, fmt.Errorf("%w: MinPoint and MaxPoint are required", ErrMissingBounds)
+	}
+
+	min, err := ValidGeoPoint("MinPoint", *minPoint, normalizeLongitude)
+	if err != nil {
+		return RectangleQuery{}, err
+	}
+	max, err := ValidGeoPoint("MaxPoint", *maxPoint, normalizeLongitude)
+	if err != nil {
+		return RectangleQuery{}, err
+	}
+
+	return RectangleQuery{rect: RectFromTwoLatLng(min.LatLng(), max.LatLng())}, nil
+}
+
+func (q RectangleQuery) Region() s2.Region {
+	return q.rect
+}
+
+func (q RectangleQuery) Contains(latLng s2.LatLng) bool {
+	return q.rect.ContainsLatLng(latLng)
+}
+
+func (q RectangleQuery) Center() s2.LatLng {
+	return q.rect.Center()
+}
+
+// SectorQuery finds the points within a radius around a center point whose
+// bearing from the center lies within the sector.
+type SectorQuery struct {
+	sector Sector
+	center s2.LatLng
+	radius float64
+}
+
+// NewSectorQuery validates the query, see QuerySectorInput.
+func NewSectorQuery(center GeoPoint, radius int, heading float64, width float64, normalizeLongitude bool) (SectorQuery, error) {
+	center, err := ValidGeoPoint("CenterPoint", center, normalizeLongitude)
+	if err != nil {
+		return SectorQuery{}, err
+	}
+	if err := ValidateRadius("RadiusInMeter", radius, 1); err != nil {
+		return SectorQuery{}, err
+	}
+	if math.IsNaN(heading) || math.IsInf(heading, 0) {
+		return SectorQuery{}, fmt.Errorf("%w: HeadingInDegree is %v", ErrInvalidShape, heading)
+	}
+	if width <= 0 || math.IsNaN(width) || math.IsInf(width, 0) {
+		return SectorQuery{}, fmt.Errorf("%w: WidthInDegree is %v, needs to be greater than 0", ErrInvalidShape, width)
+	}
+
+	latLng := center.LatLng()
+
+	return SectorQuery{
+		sector: NewSector(s2.PointFromLatLng(latLng), Angle(float64(radius)), heading, width),
+		center: latLng,
+		radius: float64(radius),
+	}, nil
+}
+
+func (q SectorQuery) Region() s2.Region {
+	return q.sector
+}
+
+func (q SectorQuery) Contains(latLng s2.LatLng) bool {
+	return EarthDistance(q.center, latLng) <= q.radius && q.sector.WedgeContainsPoint(s2.PointFromLatLng(latLng))
+}
+
+func (q SectorQuery) Center() s2.LatLng {
+	return q.center
+}
+
+// PolygonQuery finds the points within a polygon, excluding its holes.
+type PolygonQuery struct {
+	polygon *s2.Polygon
+}
+
+// NewPolygonQuery returns the query of the polygon, given either as GeoJSON
+// Polygon or MultiPolygon geometry or as s2.Polygon.
+func NewPolygonQuery(geoJSON []byte, polygon *s2.Polygon) (PolygonQuery, error) {
+	if polygon != nil {
+		if err := polygon.Validate(); err != nil {
+			return PolygonQuery{}, fmt.Errorf("%w: Polygon: %v", ErrInvalidShape, err)
+		}
+		return PolygonQuery{polygon: polygon}, nil
+	}
+	if len(geoJSON) == 0 {
+		return PolygonQuery{}, fmt.Errorf("%w: either GeoJSON or Polygon is required", ErrMissingBounds)
+	}
+
+	polygon, err := PolygonFromGeoJSON(geoJSON)
+	if err != nil {
+		return PolygonQuery{}, err
+	}
+
+	return PolygonQuery{polygon: polygon}, nil
+}
+
+func (q PolygonQuery) Region() s2.Region {
+	return q.polygon
+}
+
+func (q PolygonQuery) Contains(latLng s2.LatLng) bool {
+	return q.polygon.ContainsPoint(s2.PointFromLatLng(latLng))
+}
+
+// Center returns the center of the polygon's bounding rectangle.
+func (q PolygonQuery) Center() s2.LatLng {
+	return q.polygon.RectBound().Center()
+}
+
+// CorridorQuery finds the points within a buffer around a route.
+type CorridorQuery struct {
+	polyline *s2.Polyline
+	start    s2.LatLng
+	buffer   float64
+}
+
+// NewCorridorQuery validates the query, see QueryCorridorInput.
+func NewCorridorQuery(points []GeoPoint, buffer int, normalizeLongitude bool) (CorridorQuery, error) {
+	if len(points) < 2 {
+		return CorridorQuery{}, fmt.Errorf("%w: Polyline needs at least 2 points", ErrMissingBounds)
+	}
+
+	latLngs := make([]s2.LatLng, len(points))
+	for i, p := range points {
+		valid, err := ValidGeoPoint(fmt.Sprintf("Polyline[%d]", i), p, normalizeLongitude)
+		if err != nil {
+			return CorridorQuery{}, err
+		}
+		latLngs[i] = valid.LatLng()
+	}
+
+	if err := ValidateRadius("BufferInMeter", buffer, 0); err != nil {
+		return CorridorQuery{}, err
+	}
+
+	return CorridorQuery{polyline: s2.PolylineFromLatLngs(latLngs), start: latLngs[0], buffer: float64(buffer)}, nil
+}
+
+// Region returns the route itself, its covering is grown by the buffer.
+func (q CorridorQuery) Region() s2.Region {
+	return q.polyline
+}
+
+func (q CorridorQuery) Contains(latLng s2.LatLng) bool {
+	return q.RouteDistance(latLng).FromRouteInMeter <= q.buffer
+}
+
+// Center returns the start of the route.
+func (q CorridorQuery) Center() s2.LatLng {
+	return q.start
+}
+
+// RouteDistance returns where the location lies relative to the route.
+func (q CorridorQuery) RouteDistance(latLng s2.LatLng) RouteDistance {
+	return DistanceToRoute(q.polyline, s2.PointFromLatLng(latLng))
+}
+
+// Buffer returns the buffer around the route as angle.
+func (q CorridorQuery) Buffer() s1.Angle {
+	return Angle(q.buffer)
+}
+
+// NearestQuery finds the K points closest to a center point, optionally
+// within a maximum distance.
+type NearestQuery struct {
+	center      s2.LatLng
+	k           int
+	maxDistance float64
+}
+
+// NewNearestQuery validates the query, see QueryNearestInput.
+func NewNearestQuery(center GeoPoint, k int, maxDistance int, normalizeLongitude bool) (NearestQuery, error) {
+	center, err := ValidGeoPoint("CenterPoint", center, normalizeLongitude)
+	if err != nil {
+		return NearestQuery{}, err
+	}
+	if err := ValidateRadius("MaxDistanceInMeter", maxDistance, 0); err != nil {
+		return NearestQuery{}, err
+	}
+	if k <= 0 {
+		return NearestQuery{}, errors.New("K must be greater than 0")
+	}
+
+	return NearestQuery{center: center.LatLng(), k: k, maxDistance: float64(maxDistance)}, nil
+}
+
+func (q NearestQuery) Center() s2.LatLng {
+	return q.center
+}
@@ -0,0 +1,64 @@
+package geo
+
+import (
+	"strconv"
+
+	"github.com/gofrs/uuid"
+)
+
+// RangeKeyType is the DynamoDB attribute type of the range key.
+type RangeKeyType int
+
+const (
+	// StringRangeKey stores range keys as strings.
+	StringRangeKey RangeKeyType = iota
+	// NumberRangeKey stores range keys as numbers.
+	NumberRangeKey
+)
+
+// AttributeType returns the DynamoDB attribute type of range keys, S or N.
+func (t RangeKeyType) AttributeType() string {
+	if t == NumberRangeKey {
+		return "N"
+	}
+
+	return "S"
+}
+
+// RangeKey is the value of a range key, either a string or a number.
+type RangeKey struct {
+	value string
+	typ   RangeKeyType
+}
+
+// RangeKeyFromString returns a string range key, e.g. a business ID or a
+// composite "tenant#id" key.
+func RangeKeyFromString(s string) RangeKey {
+	return RangeKey{value: s, typ: StringRangeKey}
+}
+
+// RangeKeyFromInt returns a number range key, e.g. a timestamp.
+func RangeKeyFromInt(n int64) RangeKey {
+	return RangeKey{value: strconv.FormatInt(n, 10), typ: NumberRangeKey}
+}
+
+// RangeKeyFromFloat returns a number range key.
+func RangeKeyFromFloat(f float64) RangeKey {
+	return RangeKey{value: strconv.FormatFloat(f, 'f', -1, 64), typ: NumberRangeKey}
+}
+
+// RangeKeyFromUUID returns a string range key holding the UUID.
+func RangeKeyFromUUID(u uuid.UUID) RangeKey {
+	return RangeKeyFromString(u.String())
+}
+
+// String returns the value of the range key, formatted as DynamoDB expects it
+// for its type.
+func (k RangeKey) String() string {
+	return k.value
+}
+
+// Type returns whether the range key is a string or a number.
+func (k RangeKey) Type() RangeKeyType {
+	return k.typ
+}
@@ -0,0 +1,164 @@
+package geo
+
+import (
+	"math"
+
+	"github.com/golang/geo/r3"
+	"github.com/golang/geo/s1"
+	"github.com/golang/geo/s2"
+)
+
+// Annulus is the ring between two concentric caps. Points on the inner
+// boundary are part of the ring.
+type Annulus struct {
+	outer s2.Cap
+	inner s2.Cap
+}
+
+func NewAnnulus(center s2.Point, minRadius s1.Angle, maxRadius s1.Angle) Annulus {
+	return Annulus{
+		outer: s2.CapFromCenterAngle(center, maxRadius),
+		inner: s2.CapFromCenterAngle(center, minRadius),
+	}
+}
+
+func (a Annulus) CapBound() s2.Cap {
+	return a.outer.CapBound()
+}
+
+func (a Annulus) RectBound() s2.Rect {
+	return a.outer.RectBound()
+}
+
+func (a Annulus) ContainsCell(cell s2.Cell) bool {
+	return a.outer.ContainsCell(cell) && !a.inner.IntersectsCell(cell)
+}
+
+func (a Annulus) IntersectsCell(cell s2.Cell) bool {
+	return a.outer.IntersectsCell(cell) && !a.inner.ContainsCell(cell)
+}
+
+func (a Annulus) ContainsPoint(p s2.Point) bool {
+	return a.outer.ContainsPoint(p) && !a.inner.InteriorContainsPoint(p)
+}
+
+func (a Annulus) CellUnionBound() []s2.CellID {
+	return a.outer.CellUnionBound()
+}
+
+// Sector is the part of a cap whose initial bearing from the center lies
+// within width/2 degrees of heading. The two boundary bearings are great
+// circles through the center, so the wedge between them is the intersection
+// (or, wider than 180 degrees, the union) of the two hemispheres on the inner
+// side of those great circles.
+type Sector struct {
+	cap   s2.Cap
+	left  r3.Vector
+	right r3.Vector
+	wide  bool
+	full  bool
+}
+
+func NewSector(center s2.Point, radius s1.Angle, heading float64, width float64) Sector {
+	latLng := s2.LatLngFromPoint(center)
+	lat := latLng.Lat.Radians()
+	lng := latLng.Lng.Radians()
+
+	north := r3.Vector{X: -math.Sin(lat) * math.Cos(lng), Y: -math.Sin(lat) * math.Sin(lng), Z: math.Cos(lat)}
+	east := r3.Vector{X: -math.Sin(lng), Y: math.Cos(lng), Z: 0}
+	direction := func(bearing float64) r3.Vector {
+		b := (s1.Angle(bearing) * s1.Degree).Radians()
+		return north.Mul(math.Cos(b)).Add(east.Mul(math.Sin(b)))
+	}
+
+	// points with a bearing clockwise of the left boundary lie on the positive
+	// side of left, points counter clockwise of the right boundary on the
+	// positive side of right
+	return Sector{
+		cap:   s2.CapFromCenterAngle(center, radius),
+		left:  direction(heading - width/2).Cross(center.Vector).Normalize(),
+		right: center.Vector.Cross(direction(heading + width/2)).Normalize(),
+		wide:  width > 180,
+		full:  width >= 360,
+	}
+}
+
+func (s Sector) CapBound() s2.Cap {
+	return s.cap.CapBound()
+}
+
+func (s Sector) RectBound() s2.Rect {
+	return s.cap.RectBound()
+}
+
+func (s Sector) ContainsCell(cell s2.Cell) bool {
+	if !s.cap.ContainsCell(cell) {
+		return false
+	}
+	if s.full {
+		return true
+	}
+
+	bound := cell.CapBound()
+	left := hemisphereContainsCap(s.left, bound)
+	right := hemisphereContainsCap(s.right, bound)
+	if s.wide {
+		return left || right
+	}
+
+	return left && right
+}
+
+func (s Sector) IntersectsCell(cell s2.Cell) bool {
+	if !s.cap.IntersectsCell(cell) {
+		return false
+	}
+	if s.full {
+		return true
+	}
+
+	bound := cell.CapBound()
+	left := hemisphereIntersectsCap(s.left, bound)
+	right := hemisphereIntersectsCap(s.right, bound)
+	if s.wide {
+		return left || right
+	}
+
+	return left && right
+}
+
+func (s Sector) ContainsPoint(p s2.Point) bool {
+	return s.cap.ContainsPoint(p) && s.WedgeContainsPoint(p)
+}
+
+// WedgeContainsPoint reports whether the bearing of p from the center lies
+// within the sector, regardless of the distance.
+func (s Sector) WedgeContainsPoint(p s2.Point) bool {
+	if s.full {
+		return true
+	}
+
+	left := p.Dot(s.left) >= 0
+	right := p.Dot(s.right) >= 0
+	if s.wide {
+		return left || right
+	}
+
+	return left && right
+}
+
+func (s Sector) CellUnionBound() []s2.CellID {
+	return s.cap.CellUnionBound()
+}
+
+// hemisphereContainsCap reports whether the cap lies completely within the
+// hemisphere around the normal n.
+func hemisphereContainsCap(n r3.Vector, c s2.Cap) bool {
+	return c.Center().Angle(n) <= math.Pi/2-c.Radius()
+}
+
+// hemisphereIntersectsCap reports whether the cap and the hemisphere around
+// the normal n have any point in common.
+func hemisphereIntersectsCap(n r3.Vector, c s2.Cap) bool {
+	return c.Center().Angle(n) <= math.Pi/2+c.Radius()
+}
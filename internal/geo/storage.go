@@ -0,0 +1,48 @@
+package geo
+
+import "strconv"
+
+// StorageFormat selects the attributes the location of a point is stored in.
+// GeoJSONMapStorage and LatLngStorage can be combined to store both.
+type StorageFormat int
+
+const (
+	// GeoJSONStringStorage stores a GeoJSON point encoded as JSON string, the
+	// format of earlier versions.
+	GeoJSONStringStorage StorageFormat = 0
+	// GeoJSONMapStorage stores a GeoJSON point as Map attribute.
+	GeoJSONMapStorage StorageFormat = 1
+	// LatLngStorage stores latitude and longitude as separate Number
+	// attributes.
+	LatLngStorage StorageFormat = 2
+)
+
+// GeoJSONString reports whether the GeoJSON point is stored as string.
+func (f StorageFormat) GeoJSONString() bool {
+	return f == GeoJSONStringStorage
+}
+
+// GeoJSONMap reports whether the GeoJSON point is stored as Map attribute.
+func (f StorageFormat) GeoJSONMap() bool {
+	return f&GeoJSONMapStorage != 0
+}
+
+// LatLng reports whether latitude and longitude are stored as Number
+// attributes.
+func (f StorageFormat) LatLng() bool {
+	return f&LatLngStorage != 0
+}
+
+// ParseNumbers parses the values of Number attributes.
+func ParseNumbers(values ...string) ([]float64, error) {
+	numbers := make([]float64, len(values))
+	for i, v := range values {
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, err
+		}
+		numbers[i] = n
+	}
+
+	return numbers, nil
+}
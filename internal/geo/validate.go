@@ -0,0 +1,126 @@
+package geo
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrInvalidCoordinate is the error of latitudes outside ±90, longitudes
+// outside ±180 and coordinates that are NaN or infinite.
+var ErrInvalidCoordinate = errors.New("invalid coordinate")
+
+// ErrInvalidRadius is the error of radiuses and distances that are negative
+// or otherwise don't fit the query.
+var ErrInvalidRadius = errors.New("invalid radius")
+
+// ErrInvalidRangeKey is the error of points whose range key is empty or of
+// another type than the range key of the table.
+var ErrInvalidRangeKey = errors.New("invalid range key")
+
+// ErrInvalidShape is the error of sectors whose heading or width is NaN,
+// infinite or, for widths, not greater than 0, and of invalid polygons.
+var ErrInvalidShape = errors.New("invalid shape")
+
+// ErrMissingBounds is the error of queries lacking the points or shape that
+// bound the queried region.
+var ErrMissingBounds = errors.New("missing bounds")
+
+// ValidateLatLng checks the coordinates in degrees. With normalizeLongitude,
+// longitudes outside ±180 are wrapped into that range instead of rejected.
+func ValidateLatLng(lat float64, lng float64, normalizeLongitude bool) (float64, float64, error) {
+	if math.IsNaN(lat) || math.IsNaN(lng) || math.IsInf(lat, 0) || math.IsInf(lng, 0) || lat < -90 || lat > 90 {
+		return 0, 0, fmt.Errorf("%w: latitude %v, longitude %v", ErrInvalidCoordinate, lat, lng)
+	}
+
+	if lng < -180 || lng > 180 {
+		if !normalizeLongitude {
+			return 0, 0, fmt.Errorf("%w: latitude %v, longitude %v", ErrInvalidCoordinate, lat, lng)
+		}
+		lng = math.Remainder(lng, 360)
+	}
+
+	return lat, lng, nil
+}
+
+// ValidateRadius checks that the radius or distance in meters is at least
+// min.
+func ValidateRadius(name string, meters int, min int) error {
+	if meters < min {
+		return fmt.Errorf("%w: %s is %d, needs to be at least %d", ErrInvalidRadius, name, meters, min)
+	}
+
+	return nil
+}
+
+// ValidGeoPoint checks the coordinates of the point called name in errors.
+// With normalizeLongitude its longitude is wrapped into ±180.
+func ValidGeoPoint(name string, p GeoPoint, normalizeLongitude bool) (GeoPoint, error) {
+	lat, lng, err := ValidateLatLng(p.Latitude, p.Longitude, normalizeLongitude)
+	if err != nil {
+		return p, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return GeoPoint{Latitude: lat, Longitude: lng}, nil
+}
+
+// ValidPointInput checks the location of the point and that its range key
+// is set and of rangeKeyType.
+func ValidPointInput(input PointInput, rangeKeyType RangeKeyType, normalizeLongitude bool) (PointInput, error) {
+	if input.RangeKeyValue.String() == "" {
+		return input, fmt.Errorf("%w: RangeKeyValue is empty", ErrInvalidRangeKey)
+	}
+	if input.RangeKeyValue.Type() != rangeKeyType {
+		return input, fmt.Errorf("%w: RangeKeyValue %q has type %s, the table expects %s", ErrInvalidRangeKey, input.RangeKeyValue, input.RangeKeyValue.Type().AttributeType(), rangeKeyType.AttributeType())
+	}
+
+	p, err := ValidGeoPoint("GeoPoint", input.GeoPoint, normalizeLongitude)
+	if err != nil {
+		return input, err
+	}
+	input.GeoPoint = p
+
+	return input, nil
+}
+
+// DEFAULT_CELL_LEVEL is the level of the cells of a covering unless
+// configured otherwise, with cells about 9km wide.
+const DEFAULT_CELL_LEVEL = 10
+
+// ValidateLevel checks that the cell level called name in errors is between 0
+// and MAX_CELL_LEVEL.
+func ValidateLevel(name string, level int) error {
+	if level < 0 || level > MAX_CELL_LEVEL {
+		return fmt.Errorf("%s is %d, needs to be in the range 0 to %d", name, level, MAX_CELL_LEVEL)
+	}
+
+	return nil
+}
+
+// CoverLevels checks the minimum and maximum cell levels of a covering and
+// fills in the defaults. If neither is set, both are DEFAULT_CELL_LEVEL. A
+// maximum of 0 with a minimum set is at least DEFAULT_CELL_LEVEL, while a
+// minimum of 0 with a maximum set is kept.
+func CoverLevels(minLevel int, maxLevel int) (int, int, error) {
+	switch {
+	case minLevel == 0 && maxLevel == 0:
+		minLevel, maxLevel = DEFAULT_CELL_LEVEL, DEFAULT_CELL_LEVEL
+	case maxLevel == 0:
+		maxLevel = DEFAULT_CELL_LEVEL
+		if minLevel > maxLevel {
+			maxLevel = minLevel
+		}
+	}
+
+	if err := ValidateLevel("MinCellLevel", minLevel); err != nil {
+		return 0, 0, err
+	}
+	if err := ValidateLevel("MaxCellLevel", maxLevel); err != nil {
+		return 0, 0, err
+	}
+	if minLevel > maxLevel {
+		return 0, 0, fmt.Errorf("MinCellLevel %d is greater than MaxCellLevel %d", minLevel, maxLevel)
+	}
+
+	return minLevel, maxLevel, nil
+}
@@ -0,0 +1,56 @@
+package dyngeo
+
+import (
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/crolly/dyngeo/internal/geo"
+)
+
+// QueryIterator streams the results of a query as the pages of the single
+// hash ranges arrive. Call Next to advance to the next item and Close once
+// done reading, which stops all outstanding DynamoDB queries. A range only
+// queries its next page once its previous one has been read, so a consumer
+// that stops reading stops the queries, but the goroutines querying the
+// ranges wait until Close is called.
+//
+//	it := dg.QueryRadiusIter(input)
+//	defer it.Close()
+//	for it.Next() {
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type QueryIterator struct {
+	it *geo.Iterator[*dynamodb.AttributeValue]
+}
+
+// Next advances the iterator to the next item. It returns false once all
+// items have been read, an error occurred or the iterator has been closed.
+func (it *QueryIterator) Next() bool {
+	return it.it.Next()
+}
+
+// Item returns the current item.
+func (it *QueryIterator) Item() map[string]*dynamodb.AttributeValue {
+	return it.it.Item()
+}
+
+// Unmarshal unmarshals the current item into out.
+func (it *QueryIterator) Unmarshal(out interface{}) error {
+	return dynamodbattribute.UnmarshalMap(it.it.Item(), out)
+}
+
+// Err returns the error that stopped the iteration, if any. With
+// AllowPartialResults, failed ranges don't stop the iteration and Err
+// returns a *QueryError listing them once all other items have been read.
+func (it *QueryIterator) Err() error {
+	return it.it.Err()
+}
+
+// Close stops the iteration. No further DynamoDB queries are issued and the
+// goroutines querying the ranges exit. It has to be called if the iteration
+// ends before Next returned false, and may be called any number of times.
+func (it *QueryIterator) Close() {
+	it.it.Close()
+}
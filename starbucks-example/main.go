@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"time"
@@ -19,8 +20,6 @@ var (
 	err      error
 )
 
-const BATCH_SIZE = 25
-
 type Starbucks struct {
 	Position Position `json:"position"`
 	Name     string   `json:"name"`
@@ -88,7 +87,7 @@ func loadData() {
 				},
 			},
 		}
-		input.RangeKeyValue = id
+		input.RangeKeyValue = dyngeo.RangeKeyFromUUID(id)
 		input.GeoPoint = dyngeo.GeoPoint{
 			Latitude:  s.Position.Latitude,
 			Longitude: s.Position.Longitude,
@@ -96,19 +95,17 @@ func loadData() {
 		batchInput = append(batchInput, input)
 	}
 
-	batches := [][]dyngeo.PutPointInput{}
-	for BATCH_SIZE < len(batchInput) {
-		batchInput, batches = batchInput[BATCH_SIZE:], append(batches, batchInput[0:BATCH_SIZE:BATCH_SIZE])
+	// BatchWritePoints splits the points into batches of 25 itself
+	// a *BatchWriteError means the other points have been written
+	output, err := dg.BatchWritePoints(batchInput)
+	var batchErr *dyngeo.BatchWriteError
+	if err != nil && !errors.As(err, &batchErr) {
+		panic(err)
 	}
-	batches = append(batches, batchInput)
-
-	for count, batch := range batches {
-		output, err := dg.BatchWritePoints(batch)
-		if err != nil {
-			panic(err)
-		}
-		fmt.Printf("Batch %d written: %s", count, output)
+	for _, f := range output.Failures {
+		fmt.Printf("%s not written: %v\n", f.Input.RangeKeyValue, f.Err)
 	}
+	fmt.Printf("%d coffee shops written, %d failed\n", len(batchInput)-len(output.Failures), len(output.Failures))
 }
 
 func queryData() {